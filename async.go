@@ -0,0 +1,315 @@
+// Package pflog defines all of the pflog package
+package pflog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// OverflowPolicy is what an asynchronous output does with an entry when
+// its queue is full
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for room in the queue, slowing logging down to
+	// the speed of the target
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest discards the entry being logged
+	OverflowDropNewest
+	// OverflowDropOldest discards the oldest queued entry
+	OverflowDropOldest
+	// OverflowDropBelowLevel discards the entry being logged if it is below
+	// the options' Level and waits for room otherwise
+	OverflowDropBelowLevel
+)
+
+// Defaults of AsyncOptions
+const (
+	DefaultAsyncQueueSize  = 1024
+	DefaultAsyncBatchBytes = 64 * 1024
+)
+
+// AsyncOptions configure asynchronous output, see Log.SetAsync
+type AsyncOptions struct {
+	QueueSize  int // entries queued per target, defaults to DefaultAsyncQueueSize
+	BatchBytes int // most bytes written at once, defaults to DefaultAsyncBatchBytes
+	Overflow   OverflowPolicy
+	Level      LogLevel // for OverflowDropBelowLevel
+}
+
+// asyncItem is a formatted entry queued for a target, or with dump set a
+// marker that a trigger dump was queued before it
+type asyncItem struct {
+	data  []byte
+	level LogLevel
+	sync  *targetSync
+	dump  bool
+}
+
+// asyncQueue is the bounded queue of an output target and the goroutine
+// writing it out in batches
+type asyncQueue struct {
+	target   *targetHealth
+	records  bool // write every entry on its own
+	options  AsyncOptions
+	items    []asyncItem
+	writing  bool   // a batch is being written
+	dropped  uint64 // entries discarded on overflow or after close
+	reported uint64 // dropped entries already reported
+	closed   bool
+	changed  *sync.Cond // signalled on every change of the above
+	done     chan struct{}
+	mu       sync.Mutex
+}
+
+// convertStringToOverflowPolicy converts a configuration value to a policy
+func convertStringToOverflowPolicy(policy string) (OverflowPolicy, error) {
+	switch strings.ToLower(policy) {
+	case "", "block":
+		return OverflowBlock, nil
+	case "drop_newest":
+		return OverflowDropNewest, nil
+	case "drop_oldest":
+		return OverflowDropOldest, nil
+	case "drop_below_level":
+		return OverflowDropBelowLevel, nil
+	}
+	return OverflowBlock, fmt.Errorf("unknown overflow policy: %v", policy)
+}
+
+// withDefaults returns the options with zero values defaulted
+func (options AsyncOptions) withDefaults() (AsyncOptions, error) {
+	if options.QueueSize < 0 || options.BatchBytes < 0 {
+		return options, fmt.Errorf("async queue size and batch bytes must not be negative")
+	}
+	if options.Overflow < OverflowBlock || options.Overflow > OverflowDropBelowLevel {
+		return options, fmt.Errorf("unknown overflow policy: %d", options.Overflow)
+	}
+	if options.Level < Trace || options.Level > Fatal {
+		return options, fmt.Errorf("overflow level is out of range: %d", options.Level)
+	}
+	if options.QueueSize == 0 {
+		options.QueueSize = DefaultAsyncQueueSize
+	}
+	if options.BatchBytes == 0 {
+		options.BatchBytes = DefaultAsyncBatchBytes
+	}
+	return options, nil
+}
+
+// recordWriter is implemented by writers that may treat every Write as one
+// record, such as ones framing records for the network, asynchronous
+// output writes their entries one by one instead of in batches
+type recordWriter interface {
+	writesRecords() bool
+}
+
+// writesRecords returns true if writer treats every Write as one record
+func writesRecords(writer io.Writer) bool {
+	recorder, ok := writer.(recordWriter)
+	return ok && recorder.writesRecords()
+}
+
+// newAsyncQueue starts the writer goroutine of the indexed target
+func newAsyncQueue(target *targetHealth, options AsyncOptions) *asyncQueue {
+	queue := &asyncQueue{
+		target:  target,
+		options: options,
+		items:   make([]asyncItem, 0, options.QueueSize),
+		done:    make(chan struct{}),
+	}
+	queue.records = writesRecords(target.writer)
+	queue.changed = sync.NewCond(&queue.mu)
+	go queue.run()
+	return queue
+}
+
+// enqueue queues item applying the overflow policy, dump markers are
+// never dropped
+func (queue *asyncQueue) enqueue(item asyncItem) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	for !queue.closed && !item.dump && len(queue.items) >= queue.options.QueueSize {
+		switch queue.options.Overflow {
+		case OverflowDropNewest:
+			queue.dropped++
+			return
+		case OverflowDropBelowLevel:
+			if item.level < queue.options.Level {
+				queue.dropped++
+				return
+			}
+		case OverflowDropOldest:
+			if queue.dropOldest() {
+				continue
+			}
+		}
+		queue.changed.Wait()
+	}
+	if queue.closed {
+		queue.dropped++
+		return
+	}
+	queue.items = append(queue.items, item)
+	queue.changed.Broadcast()
+}
+
+// dropOldest discards the oldest queued entry, false if only dump markers
+// are queued
+func (queue *asyncQueue) dropOldest() bool {
+	for i, item := range queue.items {
+		if !item.dump {
+			queue.items = append(queue.items[:i], queue.items[i+1:]...)
+			queue.dropped++
+			return true
+		}
+	}
+	return false
+}
+
+// run writes out batches until the queue is closed and drained
+func (queue *asyncQueue) run() {
+	defer close(queue.done)
+
+	var batch []byte
+	for {
+		queue.mu.Lock()
+		for len(queue.items) == 0 && !queue.closed {
+			queue.changed.Wait()
+		}
+		if len(queue.items) == 0 {
+			queue.mu.Unlock()
+			return
+		}
+		count, size := 0, 0
+		for _, item := range queue.items {
+			if count > 0 && size+len(item.data) > queue.options.BatchBytes {
+				break
+			}
+			count++
+			size += len(item.data)
+		}
+		items := append([]asyncItem(nil), queue.items[:count]...)
+		queue.items = append(queue.items[:0], queue.items[count:]...)
+		queue.writing = true
+		dropped := queue.dropped - queue.reported
+		queue.reported = queue.dropped
+		queue.changed.Broadcast()
+		queue.mu.Unlock()
+
+		if dropped > 0 {
+			reportError(&TargetError{Index: queue.target.index, Err: fmt.Errorf("queue full, dropped %d entries", dropped)})
+		}
+		batch = queue.write(batch[:0], items)
+
+		queue.mu.Lock()
+		queue.writing = false
+		queue.changed.Broadcast()
+		queue.mu.Unlock()
+	}
+}
+
+// write writes items out with one write between dump markers, applying
+// their sync policy, and returns the buffer for reuse
+func (queue *asyncQueue) write(batch []byte, items []asyncItem) []byte {
+	level := LogLevel(-1)
+	entries := 0
+	var pending *targetSync
+	flush := func() {
+		if len(batch) > 0 {
+			queue.target.write(batch, entries)
+			pending.written(level)
+		}
+		batch = batch[:0]
+		level = -1
+		entries = 0
+	}
+	for _, item := range items {
+		if item.dump {
+			flush()
+			item.sync.dumped()
+			continue
+		}
+		batch = append(batch, item.data...)
+		entries++
+		if item.level > level {
+			level = item.level
+		}
+		pending = item.sync
+		if queue.records {
+			flush()
+		}
+	}
+	flush()
+	return batch
+}
+
+// wait waits until the queue is drained and idle, or with closed set
+// until its goroutine exited, or until ctx is done
+func (queue *asyncQueue) wait(ctx context.Context, closed bool) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			queue.mu.Lock()
+			queue.changed.Broadcast()
+			queue.mu.Unlock()
+		case <-stop:
+		}
+	}()
+
+	queue.mu.Lock()
+	for len(queue.items) > 0 || queue.writing {
+		if err := ctx.Err(); err != nil {
+			queue.mu.Unlock()
+			return err
+		}
+		queue.changed.Wait()
+	}
+	queue.mu.Unlock()
+	if !closed {
+		return nil
+	}
+	select {
+	case <-queue.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close stops accepting entries, the goroutine exits once the queue is drained
+func (queue *asyncQueue) close() {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.closed = true
+	queue.changed.Broadcast()
+}
+
+// discard drops every queued entry
+func (queue *asyncQueue) discard() {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	for _, item := range queue.items {
+		if !item.dump {
+			queue.dropped++
+		}
+	}
+	queue.items = queue.items[:0]
+	queue.changed.Broadcast()
+}
+
+// droppedEntries returns the number of entries discarded so far
+func (queue *asyncQueue) droppedEntries() uint64 {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	return queue.dropped
+}
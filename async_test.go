@@ -0,0 +1,253 @@
+package pflog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// gateWriter blocks every write until the gate is opened, signalling
+// entered when a write starts
+type gateWriter struct {
+	entered chan struct{}
+	gate    chan struct{}
+	writes  int
+	buffer  bytes.Buffer
+	mu      sync.Mutex
+}
+
+func newGateWriter() *gateWriter {
+	return &gateWriter{entered: make(chan struct{}, 100), gate: make(chan struct{})}
+}
+
+func (gw *gateWriter) Write(p []byte) (int, error) {
+	gw.entered <- struct{}{}
+	<-gw.gate
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	gw.writes++
+	return gw.buffer.Write(p)
+}
+
+func (gw *gateWriter) open() {
+	close(gw.gate)
+}
+
+// messages returns the messages written, one per line
+func (gw *gateWriter) messages() []string {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	var messages []string
+	for _, line := range strings.Split(strings.TrimSpace(gw.buffer.String()), "\n") {
+		fields := strings.Fields(line)
+		messages = append(messages, fields[len(fields)-1])
+	}
+	return messages
+}
+
+// syncGateWriter is a gateWriter recording the messages written when synced
+type syncGateWriter struct {
+	*gateWriter
+	synced []string
+}
+
+func (sw *syncGateWriter) Sync() error {
+	sw.synced = sw.messages()
+	return nil
+}
+
+type AsyncTestSuite struct {
+	suite.Suite
+}
+
+// newLog returns an asynchronous log writing to a gate whose first write
+// has started and is blocked
+func (suite *AsyncTestSuite) newLog(options AsyncOptions) (*Log, *gateWriter) {
+	log := New()
+	suite.Require().Nil(log.SetLevel(Trace))
+	suite.Require().Nil(log.SetAsync(options))
+	writer := newGateWriter()
+	log.AddOutputTargetAndFormatter(writer, &TextFormatter{})
+	log.Information("first")
+	<-writer.entered
+	return log, writer
+}
+
+func (suite *AsyncTestSuite) flush(log *Log) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	suite.Require().Nil(log.Flush(ctx))
+}
+
+func (suite *AsyncTestSuite) TestBatching() {
+	log, writer := suite.newLog(AsyncOptions{})
+	for _, message := range []string{"second", "third", "fourth"} {
+		log.Information(message)
+	}
+	writer.open()
+	suite.flush(log)
+	suite.Assert().Equal([]string{"first", "second", "third", "fourth"}, writer.messages())
+	suite.Assert().Equal(2, writer.writes)
+}
+
+func (suite *AsyncTestSuite) TestDropNewest() {
+	log, writer := suite.newLog(AsyncOptions{QueueSize: 2, Overflow: OverflowDropNewest})
+	for _, message := range []string{"second", "third", "fourth"} {
+		log.Information(message)
+	}
+	writer.open()
+	suite.flush(log)
+	suite.Assert().Equal([]string{"first", "second", "third"}, writer.messages())
+	dropped, err := log.GetOutputDropped(0)
+	suite.Require().Nil(err)
+	suite.Assert().Equal(uint64(1), dropped)
+}
+
+func (suite *AsyncTestSuite) TestDropOldest() {
+	log, writer := suite.newLog(AsyncOptions{QueueSize: 2, Overflow: OverflowDropOldest})
+	for _, message := range []string{"second", "third", "fourth"} {
+		log.Information(message)
+	}
+	writer.open()
+	suite.flush(log)
+	suite.Assert().Equal([]string{"first", "third", "fourth"}, writer.messages())
+}
+
+func (suite *AsyncTestSuite) TestDropBelowLevel() {
+	log, writer := suite.newLog(AsyncOptions{QueueSize: 1, Overflow: OverflowDropBelowLevel, Level: Warning})
+	log.Information("second")
+	log.Information("third")
+	logged := make(chan struct{})
+	go func() {
+		log.Error("fourth")
+		close(logged)
+	}()
+	select {
+	case <-logged:
+		suite.Fail("an entry at the level must wait for room")
+	case <-time.After(20 * time.Millisecond):
+	}
+	writer.open()
+	<-logged
+	suite.flush(log)
+	suite.Assert().Equal([]string{"first", "second", "fourth"}, writer.messages())
+	dropped, err := log.GetOutputDropped(0)
+	suite.Require().Nil(err)
+	suite.Assert().Equal(uint64(1), dropped)
+}
+
+func (suite *AsyncTestSuite) TestTriggerDumpSynced() {
+	log := New()
+	suite.Require().Nil(log.SetAsync(AsyncOptions{}))
+	counter := &syncCounter{}
+	index := log.AddOutputTargetAndFormatter(counter, &TextFormatter{})
+	suite.Require().Nil(log.SetOutputSyncPolicy(index, SyncPolicy{Mode: SyncAfterTrigger}))
+	log.Debug("context")
+	log.Fatal("crash")
+	suite.flush(log)
+	suite.Assert().Equal(int32(1), counter.syncs.Load())
+	suite.Assert().Contains(counter.String(), "context")
+}
+
+func (suite *AsyncTestSuite) TestSyncDrains() {
+	log := New()
+	suite.Require().Nil(log.SetLevel(Trace))
+	suite.Require().Nil(log.SetAsync(AsyncOptions{}))
+	writer := &syncGateWriter{gateWriter: newGateWriter()}
+	log.AddOutputTargetAndFormatter(writer, &TextFormatter{})
+	log.Information("first")
+	<-writer.entered
+	log.Information("second")
+
+	synced := make(chan error)
+	go func() { synced <- log.Sync() }()
+	select {
+	case <-synced:
+		suite.FailNow("synced before the queue was drained")
+	case <-time.After(50 * time.Millisecond):
+	}
+	writer.open()
+	suite.Require().Nil(<-synced)
+	suite.Assert().Equal([]string{"first", "second"}, writer.synced)
+	suite.Require().Nil(log.Close(context.Background()))
+}
+
+func (suite *AsyncTestSuite) TestClose() {
+	filename := filepath.Join(suite.T().TempDir(), "app.log")
+	file, err := NewFileWriter(filename)
+	suite.Require().Nil(err)
+	log := New()
+	suite.Require().Nil(log.SetAsync(AsyncOptions{}))
+	log.AddOutputTargetAndFormatter(file, &TextFormatter{})
+	log.Error("before close")
+	suite.Require().Nil(log.Close(context.Background()))
+	log.Error("after close")
+	suite.Assert().Nil(log.Close(context.Background()))
+
+	_, err = file.Write([]byte("closed\n"))
+	suite.Assert().NotNil(err)
+}
+
+func (suite *AsyncTestSuite) TestCloneSharesClose() {
+	log, writer := suite.newLog(AsyncOptions{})
+	clone := log.Clone()
+	clone.Information("clone")
+	suite.Assert().True(errors.Is(clone.Close(context.Background()), ErrCloneClose))
+	writer.open()
+	log.Information("log")
+	suite.flush(log)
+	suite.Assert().Equal([]string{"first", "clone", "log"}, writer.messages())
+
+	suite.Require().Nil(log.Close(context.Background()))
+	clone.Information("after close")
+	dropped, err := clone.GetOutputDropped(0)
+	suite.Require().Nil(err)
+	suite.Assert().Equal(uint64(0), dropped)
+	suite.Assert().Len(writer.messages(), 3)
+}
+
+func (suite *AsyncTestSuite) TestCloseTimeout() {
+	log, writer := suite.newLog(AsyncOptions{})
+	log.Information("second")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	suite.Assert().True(errors.Is(log.Close(ctx), context.DeadlineExceeded))
+	writer.open()
+	dropped, err := log.GetOutputDropped(0)
+	suite.Require().Nil(err)
+	suite.Assert().Equal(uint64(1), dropped)
+}
+
+func (suite *AsyncTestSuite) TestOptions() {
+	for value, expected := range map[string]OverflowPolicy{
+		"":                 OverflowBlock,
+		"drop_newest":      OverflowDropNewest,
+		"Drop_Oldest":      OverflowDropOldest,
+		"drop_below_level": OverflowDropBelowLevel,
+	} {
+		policy, err := convertStringToOverflowPolicy(value)
+		suite.Require().Nil(err)
+		suite.Assert().Equal(expected, policy, value)
+	}
+	_, err := convertStringToOverflowPolicy("drop_everything")
+	suite.Assert().NotNil(err)
+
+	log := New()
+	suite.Assert().NotNil(log.SetAsync(AsyncOptions{QueueSize: -1}))
+	suite.Assert().NotNil(log.SetAsync(AsyncOptions{Overflow: OverflowPolicy(9)}))
+	suite.Require().Nil(log.SetAsync(AsyncOptions{}))
+	suite.Assert().NotNil(log.SetAsync(AsyncOptions{}))
+}
+
+func TestAsyncTestSuite(t *testing.T) {
+	suite.Run(t, new(AsyncTestSuite))
+}
@@ -0,0 +1,196 @@
+// Package pflog defines all of the pflog package
+package pflog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BackupNaming is how a RotatingWriter names rotated backups
+type BackupNaming int
+
+const (
+	// BackupTimestamp names backups after the time of rotation with
+	// millisecond precision, app.log.20060102-150405.000, or after the
+	// period they cover when rotating on a schedule
+	BackupTimestamp BackupNaming = iota
+	// BackupNumbered names the newest backup app.log.1, shifting older
+	// backups up by one on every rotation like logrotate.  The file is
+	// rotated to app.log.0 and numbered by the background worker, so
+	// writers never wait for the renames.
+	BackupNumbered
+)
+
+// backupTimestampFormat is the layout of BackupTimestamp names
+const backupTimestampFormat = "20060102-150405.000"
+
+// collisionSeparator precedes the counter making a backup name unique,
+// it sorts after the other characters of a name so a renamed backup
+// still sorts after the one it collided with
+const collisionSeparator = "_"
+
+// backupStampPattern matches the part of a backup name after the log
+// file name: a timestamp or period label, optionally made unique
+var backupStampPattern = regexp.MustCompile(`^\d{8}([-.]\d+)*(_\d+)?$`)
+
+// numberedStampPattern matches the stamp of a BackupNumbered name, or of
+// a backup rotated but not numbered yet: 0 optionally made unique
+var numberedStampPattern = regexp.MustCompile(`^(\d{1,7}|0_\d+)$`)
+
+// convertStringToBackupNaming converts a configuration value to a naming scheme
+func convertStringToBackupNaming(naming string) (BackupNaming, error) {
+	switch strings.ToLower(naming) {
+	case "", "timestamp":
+		return BackupTimestamp, nil
+	case "numbered":
+		return BackupNumbered, nil
+	}
+	return BackupTimestamp, fmt.Errorf("unknown backup naming: %v", naming)
+}
+
+// backupStem returns a backup name without its encryption and
+// compression suffixes
+func backupStem(name string) string {
+	name = strings.TrimSuffix(name, encryptedSuffix)
+	return strings.TrimSuffix(name, compressionSuffix(name))
+}
+
+// backupStamp returns the part of the backup name after base, the log
+// file name, and whether name is a backup of base at all.  Only names
+// of the schemes this package writes match, so other files sharing the
+// prefix such as app.log.old are never taken for backups.  Logrotate
+// style numbers are only matched if numbered is true, so files such as
+// app.log.2024 are not taken for backups with timestamp naming.
+func backupStamp(base string, name string, numbered bool) (string, bool) {
+	stem := backupStem(name)
+	if !strings.HasPrefix(stem, base+".") {
+		return "", false
+	}
+	stamp := stem[len(base)+1:]
+	return stamp, backupStampPattern.MatchString(stamp) || numbered && numberedStampPattern.MatchString(stamp)
+}
+
+// backupNumber returns the number of a BackupNumbered backup, 0 for a
+// backup not numbered yet, and the counter making such a backup unique
+func backupNumber(base string, name string) (int, int, bool) {
+	stamp, ok := backupStamp(base, name, true)
+	if !ok || !numberedStampPattern.MatchString(stamp) {
+		return 0, 0, false
+	}
+	stamp, unique, _ := strings.Cut(stamp, collisionSeparator)
+	number, err := strconv.Atoi(stamp)
+	if err != nil {
+		return 0, 0, false
+	}
+	counter := 0
+	if unique != "" {
+		counter, err = strconv.Atoi(unique)
+	}
+	return number, counter, err == nil
+}
+
+// sortBackups sorts the backups of the log file named base oldest first:
+// timestamped backups by name, then numbered backups from the highest
+// number down, as a directory switched to numbering has newer numbered
+// backups, then backups not numbered yet in the order they were rotated
+func sortBackups(base string, paths []string) {
+	sort.SliceStable(paths, func(i, j int) bool {
+		left, leftCounter, leftNumbered := backupNumber(base, filepath.Base(paths[i]))
+		right, rightCounter, rightNumbered := backupNumber(base, filepath.Base(paths[j]))
+		switch {
+		case leftNumbered && rightNumbered && left != right:
+			return right == 0 || left > right
+		case leftNumbered && rightNumbered:
+			return leftCounter < rightCounter
+		case leftNumbered != rightNumbered:
+			return rightNumbered
+		}
+		return paths[i] < paths[j]
+	})
+}
+
+// uniqueBackupName returns backup, or if a backup with the same stem
+// already exists, possibly compressed or encrypted, backup with the
+// first free counter appended
+func (rw *RotatingWriter) uniqueBackupName(backup string) string {
+	stems := make(map[string]bool)
+	for _, path := range rw.allBackups() {
+		stems[backupStem(path)] = true
+	}
+	if !stems[backup] {
+		return backup
+	}
+	for counter := 1; ; counter++ {
+		candidate := backup + collisionSeparator + strconv.Itoa(counter)
+		if !stems[candidate] {
+			return candidate
+		}
+	}
+}
+
+// stagedBackupName returns the name the current file is rotated to with
+// BackupNumbered naming until the worker numbers it, after any backups
+// still waiting to be numbered so they are numbered in order
+func (rw *RotatingWriter) stagedBackupName() string {
+	base := filepath.Base(rw.filename)
+	last := -1
+	for _, path := range rw.allBackups() {
+		if number, counter, ok := backupNumber(base, filepath.Base(path)); ok && number == 0 && counter > last {
+			last = counter
+		}
+	}
+	if last < 0 {
+		return rw.filename + ".0"
+	}
+	return rw.filename + ".0" + collisionSeparator + strconv.Itoa(last+1)
+}
+
+// numberStagedBackups numbers the backups rotated since the last pass,
+// oldest first: every numbered backup is shifted to the next number,
+// keeping its suffixes, and the staged backup becomes number 1.  Renames
+// are made highest first so nothing is overwritten.  Stems in rw.rotated
+// follow their backups.  Must be called with rw.mu held so no rotation
+// runs meanwhile.
+func (rw *RotatingWriter) numberStagedBackups() error {
+	base := filepath.Base(rw.filename)
+	backups := rw.allBackups()
+	sortBackups(base, backups)
+	var numbered, staged []string
+	for _, path := range backups {
+		if number, _, ok := backupNumber(base, filepath.Base(path)); ok && number == 0 {
+			staged = append(staged, path)
+		} else if ok {
+			numbered = append(numbered, path)
+		}
+	}
+
+	for _, stage := range staged {
+		renamed := make(map[string]string)
+		for index, path := range append(numbered, stage) {
+			name := filepath.Base(path)
+			number, _, _ := backupNumber(base, name)
+			stem := backupStem(name)
+			shifted := filepath.Join(filepath.Dir(path), base+"."+strconv.Itoa(number+1)+name[len(stem):])
+			if err := os.Rename(path, shifted); err != nil {
+				return fmt.Errorf("shift %s: %w", name, err)
+			}
+			renamed[filepath.Join(filepath.Dir(path), stem)] = backupStem(shifted)
+			if index < len(numbered) {
+				numbered[index] = shifted
+			} else {
+				numbered = append(numbered, shifted)
+			}
+		}
+		for index, stem := range rw.rotated {
+			if shifted, ok := renamed[stem]; ok {
+				rw.rotated[index] = shifted
+			}
+		}
+	}
+	return nil
+}
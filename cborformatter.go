@@ -0,0 +1,164 @@
+// Package pflog defines all of the pflog package
+package pflog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+var cborformatterTypeID = "cbor"
+
+// CBOR major types (RFC 8949)
+const (
+	cborUnsigned   = 0 << 5
+	cborNegative   = 1 << 5
+	cborBytes      = 2 << 5
+	cborText       = 3 << 5
+	cborArray      = 4 << 5
+	cborMap        = 5 << 5
+	cborTag        = 6 << 5
+	cborSimple     = 7 << 5
+	cborFalse      = cborSimple | 20
+	cborTrue       = cborSimple | 21
+	cborNull       = cborSimple | 22
+	cborFloat32    = cborSimple | 26
+	cborFloat64    = cborSimple | 27
+	cborTagRFC3339 = 0
+)
+
+// Keys of the record map
+const (
+	cborKeyTimestamp = "ts"
+	cborKeyLevel     = "level"
+	cborKeyMessage   = "msg"
+	cborKeyTags      = "tags"
+)
+
+// cborLengthPrefixSize is the size of the big endian length
+// that precedes every record
+const cborLengthPrefixSize = 4
+
+// CBORFormatter encodes entries as length prefixed CBOR maps, each
+// record is a 4 byte big endian length followed by a map of:
+//
+//	ts    unsigned nanoseconds since the unix epoch
+//	level unsigned log level
+//	msg   text message
+//	tags  map of tag name to value
+//
+// Tag values that are not booleans, numbers, strings, byte slices or
+// times are stored as their fmt %v representation.  Records can be
+// read back with a CBORReader.
+type CBORFormatter struct {
+	timeFormat string
+}
+
+// ID returns the specified ID of this formatter
+func (cf *CBORFormatter) ID() string {
+	return cborformatterTypeID
+}
+
+// SetTimestampFormat is accepted for compatibility, timestamps
+// are always stored in binary form
+func (cf *CBORFormatter) SetTimestampFormat(format string) {
+	cf.timeFormat = format
+}
+
+// Format formats a log entry into a length prefixed CBOR record
+func (cf *CBORFormatter) Format(entry *Entry) []byte {
+	record := make([]byte, cborLengthPrefixSize, 64+len(entry.message))
+
+	record = cborAppendHead(record, cborMap, 4)
+	record = cborAppendText(record, cborKeyTimestamp)
+	record = cborAppendInt(record, entry.timestamp.UnixNano())
+	record = cborAppendText(record, cborKeyLevel)
+	record = cborAppendInt(record, int64(entry.level))
+	record = cborAppendText(record, cborKeyMessage)
+	record = cborAppendText(record, entry.message)
+	record = cborAppendText(record, cborKeyTags)
+	record = cborAppendHead(record, cborMap, uint64(len(entry.tags)))
+	for _, v := range entry.tags {
+		record = cborAppendText(record, v.name)
+		record = cborAppendValue(record, v.value)
+	}
+
+	binary.BigEndian.PutUint32(record, uint32(len(record)-cborLengthPrefixSize))
+	return record
+}
+
+func cborAppendHead(buffer []byte, major byte, length uint64) []byte {
+	switch {
+	case length < 24:
+		return append(buffer, major|byte(length))
+	case length <= math.MaxUint8:
+		return append(buffer, major|24, byte(length))
+	case length <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buffer, major|25), uint16(length))
+	case length <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buffer, major|26), uint32(length))
+	}
+	return binary.BigEndian.AppendUint64(append(buffer, major|27), length)
+}
+
+func cborAppendInt(buffer []byte, value int64) []byte {
+	if value < 0 {
+		return cborAppendHead(buffer, cborNegative, uint64(-(value + 1)))
+	}
+	return cborAppendHead(buffer, cborUnsigned, uint64(value))
+}
+
+func cborAppendText(buffer []byte, text string) []byte {
+	buffer = cborAppendHead(buffer, cborText, uint64(len(text)))
+	return append(buffer, text...)
+}
+
+func cborAppendValue(buffer []byte, value interface{}) []byte {
+	switch v := value.(type) {
+	case nil:
+		return append(buffer, cborNull)
+	case bool:
+		if v {
+			return append(buffer, cborTrue)
+		}
+		return append(buffer, cborFalse)
+	case int:
+		return cborAppendInt(buffer, int64(v))
+	case int8:
+		return cborAppendInt(buffer, int64(v))
+	case int16:
+		return cborAppendInt(buffer, int64(v))
+	case int32:
+		return cborAppendInt(buffer, int64(v))
+	case int64:
+		return cborAppendInt(buffer, v)
+	case uint:
+		return cborAppendHead(buffer, cborUnsigned, uint64(v))
+	case uint8:
+		return cborAppendHead(buffer, cborUnsigned, uint64(v))
+	case uint16:
+		return cborAppendHead(buffer, cborUnsigned, uint64(v))
+	case uint32:
+		return cborAppendHead(buffer, cborUnsigned, uint64(v))
+	case uint64:
+		return cborAppendHead(buffer, cborUnsigned, v)
+	case float32:
+		return binary.BigEndian.AppendUint32(append(buffer, cborFloat32), math.Float32bits(v))
+	case float64:
+		return binary.BigEndian.AppendUint64(append(buffer, cborFloat64), math.Float64bits(v))
+	case string:
+		return cborAppendText(buffer, v)
+	case []byte:
+		buffer = cborAppendHead(buffer, cborBytes, uint64(len(v)))
+		return append(buffer, v...)
+	case time.Time:
+		buffer = cborAppendHead(buffer, cborTag, cborTagRFC3339)
+		return cborAppendText(buffer, v.Format(time.RFC3339Nano))
+	case error:
+		return cborAppendText(buffer, v.Error())
+	case fmt.Stringer:
+		return cborAppendText(buffer, v.String())
+	}
+	return cborAppendText(buffer, fmt.Sprintf("%v", value))
+}
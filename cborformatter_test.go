@@ -0,0 +1,81 @@
+package pflog
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CBORFormatterTestSuite struct {
+	suite.Suite
+}
+
+func (suite *CBORFormatterTestSuite) TestRoundTrip() {
+	formatter := &CBORFormatter{}
+	timestamp := time.Date(2021, time.March, 4, 5, 6, 7, 891, time.UTC)
+	tags := []*Tag{
+		CreateTag("int", -42),
+		CreateTag("uint", uint64(1)<<40),
+		CreateTag("float", 1.5),
+		CreateTag("bool", true),
+		CreateTag("nil", nil),
+		CreateTag("bytes", []byte{1, 2, 3}),
+		CreateTag("time", timestamp),
+		CreateTag("error", errors.New("boom")),
+		CreateTag("other", []int{1, 2}),
+	}
+
+	var stream bytes.Buffer
+	stream.Write(formatter.Format(NewEntry(Warning, timestamp, "first", tags)))
+	stream.Write(formatter.Format(NewEntry(Trace, timestamp, "second", nil)))
+
+	reader := NewCBORReader(&stream)
+
+	entry, err := reader.Next()
+	suite.Require().Nil(err)
+	suite.Assert().Equal(LogLevel(Warning), entry.Level())
+	suite.Assert().True(timestamp.Equal(entry.Timestamp()))
+	suite.Assert().Equal("first", entry.Message())
+	suite.Require().Equal(len(tags), len(entry.Tags()))
+	expected := []interface{}{int64(-42), int64(1) << 40, 1.5, true, nil, []byte{1, 2, 3}, timestamp, "boom", "[1 2]"}
+	for index, tag := range entry.Tags() {
+		suite.Assert().Equal(tags[index].Name(), tag.Name())
+		if expectedTime, ok := expected[index].(time.Time); ok {
+			suite.Assert().True(expectedTime.Equal(tag.Value().(time.Time)))
+			continue
+		}
+		suite.Assert().Equal(expected[index], tag.Value())
+	}
+
+	entry, err = reader.Next()
+	suite.Require().Nil(err)
+	suite.Assert().Equal("second", entry.Message())
+	suite.Assert().Empty(entry.Tags())
+
+	_, err = reader.Next()
+	suite.Assert().Equal(io.EOF, err)
+}
+
+func (suite *CBORFormatterTestSuite) TestTruncated() {
+	record := (&CBORFormatter{}).Format(NewEntry(Error, time.Now(), "truncated", nil))
+
+	_, err := NewCBORReader(bytes.NewReader(record[:len(record)-2])).Next()
+	suite.Assert().Equal(io.ErrUnexpectedEOF, err)
+}
+
+func (suite *CBORFormatterTestSuite) TestConvertToText() {
+	record := (&CBORFormatter{}).Format(NewEntry(Error, time.Now(), "convert", []*Tag{CreateTag("one", 1)}))
+
+	entry, err := NewCBORReader(bytes.NewReader(record)).Next()
+	suite.Require().Nil(err)
+
+	suite.Assert().Contains(string((&TextFormatter{}).Format(entry)), "[ERROR] one: 1 convert")
+}
+
+func TestCBORFormatterTestSuite(t *testing.T) {
+	suite.Run(t, new(CBORFormatterTestSuite))
+}
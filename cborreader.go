@@ -0,0 +1,324 @@
+// Package pflog defines all of the pflog package
+package pflog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// cborMaxRecordSize guards against reading garbage as a huge length
+const cborMaxRecordSize = 64 * 1024 * 1024
+
+// CBORReader iterates over a stream of records written by
+// the CBORFormatter, returning them as entries
+type CBORReader struct {
+	reader *bufio.Reader
+}
+
+// NewCBORReader returns a reader of CBOR records from the given stream
+func NewCBORReader(reader io.Reader) *CBORReader {
+	return &CBORReader{reader: bufio.NewReader(reader)}
+}
+
+// Next returns the next entry in the stream, io.EOF is returned
+// at the clean end of the stream and io.ErrUnexpectedEOF if the
+// stream ends part way through a record
+func (cr *CBORReader) Next() (*Entry, error) {
+	var prefix [cborLengthPrefixSize]byte
+	_, err := io.ReadFull(cr.reader, prefix[:])
+	if err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(prefix[:])
+	if length > cborMaxRecordSize {
+		return nil, fmt.Errorf("cbor record too large: %d", length)
+	}
+
+	record := make([]byte, length)
+	_, err = io.ReadFull(cr.reader, record)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return decodeCBOREntry(record)
+}
+
+// decodeCBOREntry decodes a single record without its length prefix
+func decodeCBOREntry(record []byte) (*Entry, error) {
+	decoder := cborDecoder{data: record}
+	count, err := decoder.readHead(cborMap)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &Entry{tags: make([]*Tag, 0)}
+	var haveTimestamp, haveLevel bool
+	for index := uint64(0); index < count; index++ {
+		key, err := decoder.decode()
+		if err != nil {
+			return nil, err
+		}
+		if key == cborKeyTags {
+			entry.tags, err = decoder.decodeTags()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		value, err := decoder.decode()
+		if err != nil {
+			return nil, err
+		}
+		switch key {
+		case cborKeyTimestamp:
+			timestamp, ok := value.(int64)
+			if !ok {
+				return nil, fmt.Errorf("cbor record has a bad timestamp")
+			}
+			entry.timestamp = time.Unix(0, timestamp)
+			haveTimestamp = true
+		case cborKeyLevel:
+			level, ok := value.(int64)
+			if !ok {
+				return nil, fmt.Errorf("cbor record has a bad level")
+			}
+			entry.level = LogLevel(level)
+			haveLevel = true
+		case cborKeyMessage:
+			entry.message, _ = value.(string)
+		}
+	}
+	if !haveTimestamp || !haveLevel {
+		return nil, fmt.Errorf("cbor record is missing a timestamp or level")
+	}
+	if decoder.offset != len(record) {
+		return nil, fmt.Errorf("cbor record has %d trailing bytes", len(record)-decoder.offset)
+	}
+
+	return entry, nil
+}
+
+// cborDecoder decodes the subset of CBOR produced by the
+// formatter, definite length items only
+type cborDecoder struct {
+	data   []byte
+	offset int
+}
+
+// readHead reads an item head that must be of the given major type
+// and returns its argument
+func (cd *cborDecoder) readHead(major byte) (uint64, error) {
+	initial, err := cd.readByte()
+	if err != nil {
+		return 0, err
+	}
+	if initial&0xe0 != major {
+		return 0, fmt.Errorf("unexpected cbor major type: %d", initial>>5)
+	}
+	return cd.readArgument(initial & 0x1f)
+}
+
+// decodeTags decodes the tags map in record order
+func (cd *cborDecoder) decodeTags() ([]*Tag, error) {
+	count, err := cd.readHead(cborMap)
+	if err != nil {
+		return nil, err
+	}
+	if count > uint64(len(cd.data)-cd.offset) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	tags := make([]*Tag, 0, count)
+	for index := uint64(0); index < count; index++ {
+		key, err := cd.decode()
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("cbor tag name is not text")
+		}
+		value, err := cd.decode()
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, CreateTag(name, value))
+	}
+	return tags, nil
+}
+
+func (cd *cborDecoder) readByte() (byte, error) {
+	if cd.offset >= len(cd.data) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	b := cd.data[cd.offset]
+	cd.offset++
+	return b, nil
+}
+
+func (cd *cborDecoder) readBytes(count uint64) ([]byte, error) {
+	if count > uint64(len(cd.data)-cd.offset) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	out := cd.data[cd.offset : cd.offset+int(count)]
+	cd.offset += int(count)
+	return out, nil
+}
+
+func (cd *cborDecoder) readArgument(info byte) (uint64, error) {
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info == 24:
+		b, err := cd.readBytes(1)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(b[0]), nil
+	case info == 25:
+		b, err := cd.readBytes(2)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case info == 26:
+		b, err := cd.readBytes(4)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	case info == 27:
+		b, err := cd.readBytes(8)
+		if err != nil {
+			return 0, err
+		}
+		return binary.BigEndian.Uint64(b), nil
+	}
+	return 0, fmt.Errorf("unsupported cbor argument: %d", info)
+}
+
+func (cd *cborDecoder) decode() (interface{}, error) {
+	initial, err := cd.readByte()
+	if err != nil {
+		return nil, err
+	}
+	major := initial & 0xe0
+	info := initial & 0x1f
+
+	if major == cborSimple {
+		return cd.decodeSimple(info)
+	}
+
+	argument, err := cd.readArgument(info)
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborUnsigned:
+		if argument > math.MaxInt64 {
+			return argument, nil
+		}
+		return int64(argument), nil
+	case cborNegative:
+		if argument > math.MaxInt64 {
+			return nil, fmt.Errorf("cbor negative integer out of range")
+		}
+		return -1 - int64(argument), nil
+	case cborBytes:
+		b, err := cd.readBytes(argument)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case cborText:
+		b, err := cd.readBytes(argument)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case cborArray:
+		return cd.decodeArray(argument)
+	case cborMap:
+		return cd.decodeMap(argument)
+	case cborTag:
+		value, err := cd.decode()
+		if err != nil {
+			return nil, err
+		}
+		if text, ok := value.(string); ok && argument == cborTagRFC3339 {
+			return time.Parse(time.RFC3339Nano, text)
+		}
+		return value, nil
+	}
+	return nil, fmt.Errorf("unsupported cbor major type: %d", major>>5)
+}
+
+func (cd *cborDecoder) decodeSimple(info byte) (interface{}, error) {
+	switch initial := cborSimple | info; initial {
+	case cborFalse:
+		return false, nil
+	case cborTrue:
+		return true, nil
+	case cborNull:
+		return nil, nil
+	case cborFloat32:
+		b, err := cd.readBytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case cborFloat64:
+		b, err := cd.readBytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	}
+	return nil, fmt.Errorf("unsupported cbor simple value: %d", info)
+}
+
+func (cd *cborDecoder) decodeArray(count uint64) (interface{}, error) {
+	if count > uint64(len(cd.data)-cd.offset) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	out := make([]interface{}, 0, count)
+	for index := uint64(0); index < count; index++ {
+		value, err := cd.decode()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, value)
+	}
+	return out, nil
+}
+
+func (cd *cborDecoder) decodeMap(count uint64) (interface{}, error) {
+	if count > uint64(len(cd.data)-cd.offset) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	out := make(map[string]interface{}, count)
+	for index := uint64(0); index < count; index++ {
+		key, err := cd.decode()
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("cbor map key is not text")
+		}
+		value, err := cd.decode()
+		if err != nil {
+			return nil, err
+		}
+		out[name] = value
+	}
+	return out, nil
+}
@@ -0,0 +1,419 @@
+// Package pflog defines all of the pflog package
+package pflog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// chainHeaderPrefix starts the header line written before every record
+const chainHeaderPrefix = "#chain "
+
+// chainFormatterHeaderPrefix starts the line written before a formatter
+// header, such as the csv one, at the start of a file
+const chainFormatterHeaderPrefix = "#chain-header "
+
+// chainMaxRecordSize guards against a corrupt header claiming a huge record
+const chainMaxRecordSize = 64 * 1024 * 1024
+
+// ChainWriter makes a log tamper evident by writing a header line
+// before every record:
+//
+//	#chain <sequence> <length> <hash>
+//
+// where hash is the hex sha256 (or HMAC-SHA256 when a key is given) of
+// the previous record's hash, the big endian sequence number and the
+// record bytes.  Deleting, reordering or editing records breaks the
+// chain, which VerifyChain detects.  Each Write must be one record.  A
+// formatter header set with SetHeader is written at the start of each
+// file preceded by
+//
+//	#chain-header <length> <hash>
+//
+// where hash is the hex sha256 (or HMAC-SHA256) of the header alone, so
+// any other line at the head of a file is reported.
+type ChainWriter struct {
+	writer   io.Writer
+	key      []byte
+	sequence uint64
+	previous []byte
+	mu       sync.Mutex
+}
+
+// ChainState is the position of a chain, used to continue it
+type ChainState struct {
+	Sequence uint64 // sequence number of the next record
+	Previous []byte // hash of the last record, nil at the start of a chain
+}
+
+// NewChainWriter returns a chain writer continuing from state
+func NewChainWriter(writer io.Writer, key []byte, state ChainState) *ChainWriter {
+	return &ChainWriter{
+		writer:   writer,
+		key:      key,
+		sequence: state.Sequence,
+		previous: state.Previous,
+	}
+}
+
+// newFileChainWriter returns a chain writer for the log file filename,
+// continuing the chain found in the file or its newest backup.  A torn
+// final record, such as left by a crash, is skipped so the chain
+// continues from the last complete record and verification reports
+// the torn record.  encryptionKey decrypts encrypted files, if any.
+func newFileChainWriter(writer io.Writer, filename string, key []byte, encryptionKey []byte) (*ChainWriter, error) {
+	files := append(backupFiles(filename), filename)
+	for index := len(files) - 1; index >= 0; index-- {
+		state, err := lastChainState(files[index], encryptionKey)
+		var chainErr *ChainError
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil && !errors.As(err, &chainErr) {
+			return nil, fmt.Errorf("unable to continue chain: %w", err)
+		}
+		if state.Previous != nil {
+			return NewChainWriter(writer, key, state), nil
+		}
+	}
+	return NewChainWriter(writer, key, ChainState{}), nil
+}
+
+// Write chains and writes a single record
+func (cw *ChainWriter) Write(p []byte) (int, error) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	sum := chainHash(cw.key, cw.previous, cw.sequence, p)
+	record := make([]byte, 0, len(chainHeaderPrefix)+96+len(p))
+	record = append(record, chainHeaderPrefix...)
+	record = strconv.AppendUint(record, cw.sequence, 10)
+	record = append(record, ' ')
+	record = strconv.AppendInt(record, int64(len(p)), 10)
+	record = append(record, ' ')
+	record = append(record, hex.EncodeToString(sum)...)
+	record = append(record, '\n')
+	record = append(record, p...)
+
+	_, err := cw.writer.Write(record)
+	if err != nil {
+		return 0, err
+	}
+	cw.previous = sum
+	cw.sequence++
+	return len(p), nil
+}
+
+// Reopen reopens the underlying writer if it supports reopening, the
+// chain continues in the reopened file
+func (cw *ChainWriter) Reopen() error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	if reopener, ok := cw.writer.(Reopener); ok {
+		return reopener.Reopen()
+	}
+	return nil
+}
+
+// Sync syncs the underlying writer if it supports syncing
+func (cw *ChainWriter) Sync() error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	if syncer, ok := cw.writer.(Syncer); ok {
+		return syncer.Sync()
+	}
+	return nil
+}
+
+// Unwrap returns the underlying writer
+func (cw *ChainWriter) Unwrap() io.Writer {
+	return cw.writer
+}
+
+// SetHeader sets the formatter header written at the start of every file
+// by the underlying writer, such as a RotatingWriter or FileWriter.  A
+// writer without headers gets it once as a chained record.
+func (cw *ChainWriter) SetHeader(header []byte) error {
+	headerWriter, ok := cw.writer.(interface{ SetHeader([]byte) error })
+	if !ok {
+		_, err := cw.Write(header)
+		return err
+	}
+	sum := chainHeaderHash(cw.key, header)
+	frame := make([]byte, 0, len(chainFormatterHeaderPrefix)+96+len(header))
+	frame = append(frame, chainFormatterHeaderPrefix...)
+	frame = strconv.AppendInt(frame, int64(len(header)), 10)
+	frame = append(frame, ' ')
+	frame = append(frame, hex.EncodeToString(sum)...)
+	frame = append(frame, '\n')
+	frame = append(frame, header...)
+	return headerWriter.SetHeader(frame)
+}
+
+// writesRecords returns true as every Write is chained as one record, so
+// asynchronous output does not join entries into one record
+func (cw *ChainWriter) writesRecords() bool {
+	return true
+}
+
+// State returns the current position of the chain
+func (cw *ChainWriter) State() ChainState {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	return ChainState{Sequence: cw.sequence, Previous: append([]byte(nil), cw.previous...)}
+}
+
+func newChainHash(key []byte) hash.Hash {
+	if len(key) > 0 {
+		return hmac.New(sha256.New, key)
+	}
+	return sha256.New()
+}
+
+func chainHash(key []byte, previous []byte, sequence uint64, record []byte) []byte {
+	h := newChainHash(key)
+	if previous == nil {
+		previous = make([]byte, sha256.Size)
+	}
+	h.Write(previous)
+	var sequenceBytes [8]byte
+	binary.BigEndian.PutUint64(sequenceBytes[:], sequence)
+	h.Write(sequenceBytes[:])
+	h.Write(record)
+	return h.Sum(nil)
+}
+
+// chainHeaderHash hashes a formatter header, prefixed so it can not pass
+// as a record
+func chainHeaderHash(key []byte, header []byte) []byte {
+	h := newChainHash(key)
+	h.Write([]byte(chainFormatterHeaderPrefix))
+	h.Write(header)
+	return h.Sum(nil)
+}
+
+// ChainError describes where a chain failed to verify
+type ChainError struct {
+	Filename string
+	Sequence uint64
+	Reason   string
+}
+
+func (ce *ChainError) Error() string {
+	return fmt.Sprintf("chain broken in %s at record %d: %s", ce.Filename, ce.Sequence, ce.Reason)
+}
+
+// ChainVerification is the result of a verification, on failure up to
+// the last record verified
+type ChainVerification struct {
+	Records       uint64     // number of records verified
+	FirstSequence uint64     // sequence number of the first record
+	Last          ChainState // state after the last record, a checkpoint for later verifications
+}
+
+// VerifyChain verifies the chain across files given oldest first,
+// gzip compressed files are read transparently.  The chain must start
+// at its first record, so missing records at the head are reported;
+// use VerifyChainFrom with a checkpoint once older backups are pruned.
+func VerifyChain(files []string, key []byte) (ChainVerification, error) {
+	return VerifyChainFrom(files, key, nil, ChainState{})
+}
+
+// VerifyEncryptedChain is VerifyChain for files that may also be
+// encrypted with encryptionKey
+func VerifyEncryptedChain(files []string, key []byte, encryptionKey []byte) (ChainVerification, error) {
+	return VerifyChainFrom(files, key, encryptionKey, ChainState{})
+}
+
+// VerifyChainFrom verifies the chain across files given oldest first
+// starting at the trusted state start, such as the Last state of an
+// earlier verification kept as a checkpoint.  Records before the
+// checkpoint are skipped, the first record verified must be
+// start.Sequence chained from start.Previous, so edited, deleted or
+// pruned records at the head are reported.  The zero state is the start
+// of a chain.  Records cut from the tail are only detected by comparing
+// the returned Last state with a checkpoint of the writer's State.
+func VerifyChainFrom(files []string, key []byte, encryptionKey []byte, start ChainState) (ChainVerification, error) {
+	result := ChainVerification{
+		FirstSequence: start.Sequence,
+		Last:          ChainState{Sequence: start.Sequence, Previous: append([]byte(nil), start.Previous...)},
+	}
+	started := false
+	for _, filename := range files {
+		header := func(sum []byte, header []byte) error {
+			if !hmac.Equal(chainHeaderHash(key, header), sum) {
+				return &ChainError{Filename: filename, Sequence: result.Last.Sequence, Reason: "formatter header hash mismatch"}
+			}
+			return nil
+		}
+		err := readChainFile(filename, encryptionKey, header, func(sequence uint64, sum []byte, record []byte) error {
+			if !started {
+				if sequence < start.Sequence {
+					// covered by the checkpoint
+					return nil
+				}
+				started = true
+				if sequence != start.Sequence {
+					return &ChainError{Filename: filename, Sequence: sequence, Reason: fmt.Sprintf("records %d to %d are missing", start.Sequence, sequence-1)}
+				}
+			}
+			if sequence != result.Last.Sequence {
+				return &ChainError{Filename: filename, Sequence: sequence, Reason: fmt.Sprintf("expected record %d", result.Last.Sequence)}
+			}
+			expected := chainHash(key, result.Last.Previous, sequence, record)
+			if !hmac.Equal(expected, sum) {
+				return &ChainError{Filename: filename, Sequence: sequence, Reason: "hash mismatch"}
+			}
+			result.Last.Previous = sum
+			result.Last.Sequence++
+			result.Records++
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// VerifyChainFiles verifies the log file filename together with
+// all of its rotated backups
+func VerifyChainFiles(filename string, key []byte) (ChainVerification, error) {
+	return VerifyEncryptedChainFiles(filename, key, nil)
+}
+
+// VerifyEncryptedChainFiles is VerifyChainFiles for files that may
+// also be encrypted with encryptionKey
+func VerifyEncryptedChainFiles(filename string, key []byte, encryptionKey []byte) (ChainVerification, error) {
+	files := backupFiles(filename)
+	if _, err := os.Stat(filename); err == nil {
+		files = append(files, filename)
+	}
+	return VerifyChainFrom(files, key, encryptionKey, ChainState{})
+}
+
+// VerifyChainFilesFrom is VerifyEncryptedChainFiles starting at the
+// trusted state start as for VerifyChainFrom
+func VerifyChainFilesFrom(filename string, key []byte, encryptionKey []byte, start ChainState) (ChainVerification, error) {
+	files := backupFiles(filename)
+	if _, err := os.Stat(filename); err == nil {
+		files = append(files, filename)
+	}
+	return VerifyChainFrom(files, key, encryptionKey, start)
+}
+
+// lastChainState returns the state after the last record in the file
+func lastChainState(filename string, encryptionKey []byte) (ChainState, error) {
+	var state ChainState
+	err := readChainFile(filename, encryptionKey, nil, func(sequence uint64, sum []byte, record []byte) error {
+		state.Sequence = sequence + 1
+		state.Previous = sum
+		return nil
+	})
+	return state, err
+}
+
+// readChainFile calls record for every record in the file in order and
+// header, unless nil, for a formatter header at the start of the file
+func readChainFile(filename string, encryptionKey []byte, header func(sum []byte, header []byte) error, record func(sequence uint64, sum []byte, record []byte) error) error {
+	reader, err := OpenLogFile(filename, encryptionKey)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	buffered := bufio.NewReader(reader)
+	for first := true; ; first = false {
+		line, readErr := buffered.ReadBytes('\n')
+		if readErr == io.EOF && len(line) == 0 {
+			return nil
+		}
+		if readErr != nil && readErr != io.EOF {
+			return &ChainError{Filename: filename, Reason: readErr.Error()}
+		}
+		if first && bytes.HasPrefix(line, []byte(chainFormatterHeaderPrefix)) {
+			err = readChainFormatterHeader(filename, buffered, line, header)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		sequence, length, sum, parseErr := parseChainHeader(line)
+		if parseErr != nil {
+			return &ChainError{Filename: filename, Sequence: sequence, Reason: parseErr.Error()}
+		}
+		data := make([]byte, length)
+		_, readErr = io.ReadFull(buffered, data)
+		if readErr != nil {
+			return &ChainError{Filename: filename, Sequence: sequence, Reason: "truncated record"}
+		}
+		err = record(sequence, sum, data)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readChainFormatterHeader reads the formatter header announced by line
+// and checks it with header, unless nil
+func readChainFormatterHeader(filename string, buffered *bufio.Reader, line []byte, header func(sum []byte, header []byte) error) error {
+	fields := strings.Fields(string(line[len(chainFormatterHeaderPrefix):]))
+	if len(fields) != 2 || line[len(line)-1] != '\n' {
+		return &ChainError{Filename: filename, Reason: "malformed formatter header"}
+	}
+	length, err := strconv.Atoi(fields[0])
+	if err != nil || length < 0 || length > chainMaxRecordSize {
+		return &ChainError{Filename: filename, Reason: "malformed formatter header length"}
+	}
+	sum, err := hex.DecodeString(fields[1])
+	if err != nil || len(sum) != sha256.Size {
+		return &ChainError{Filename: filename, Reason: "malformed formatter header hash"}
+	}
+	data := make([]byte, length)
+	_, err = io.ReadFull(buffered, data)
+	if err != nil {
+		return &ChainError{Filename: filename, Reason: "truncated formatter header"}
+	}
+	if header == nil {
+		return nil
+	}
+	return header(sum, data)
+}
+
+func parseChainHeader(line []byte) (uint64, int, []byte, error) {
+	if !bytes.HasPrefix(line, []byte(chainHeaderPrefix)) || line[len(line)-1] != '\n' {
+		return 0, 0, nil, fmt.Errorf("missing chain header")
+	}
+	fields := strings.Fields(string(line[len(chainHeaderPrefix):]))
+	if len(fields) != 3 {
+		return 0, 0, nil, fmt.Errorf("malformed chain header")
+	}
+	sequence, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("malformed chain sequence")
+	}
+	length, err := strconv.Atoi(fields[1])
+	if err != nil || length < 0 || length > chainMaxRecordSize {
+		return sequence, 0, nil, fmt.Errorf("malformed chain length")
+	}
+	sum, err := hex.DecodeString(fields[2])
+	if err != nil || len(sum) != sha256.Size {
+		return sequence, 0, nil, fmt.Errorf("malformed chain hash")
+	}
+	return sequence, length, sum, nil
+}
@@ -0,0 +1,235 @@
+package pflog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ChainWriterTestSuite struct {
+	suite.Suite
+}
+
+var testChainKey = []byte("chain key")
+
+func (suite *ChainWriterTestSuite) writeRecords(writer *ChainWriter, first int, count int) {
+	for index := first; index < first+count; index++ {
+		_, err := writer.Write([]byte(fmt.Sprintf("record %d\n", index)))
+		suite.Require().Nil(err)
+	}
+}
+
+func (suite *ChainWriterTestSuite) TestAcrossRotations() {
+	filename := filepath.Join(suite.T().TempDir(), "audit.log")
+	rotating, err := newRotatingWriter(filename, 200, 0, true)
+	suite.Require().Nil(err)
+	writer, err := newFileChainWriter(rotating, filename, testChainKey, nil)
+	suite.Require().Nil(err)
+	suite.writeRecords(writer, 0, 5)
+
+	// a restart continues the chain from the existing file
+	writer, err = newFileChainWriter(rotating, filename, testChainKey, nil)
+	suite.Require().Nil(err)
+	suite.Assert().Equal(uint64(5), writer.State().Sequence)
+	suite.writeRecords(writer, 5, 5)
+	suite.Require().Nil(rotating.Close())
+	suite.Assert().Greater(len(backupFiles(filename)), 2)
+
+	result, err := VerifyChainFiles(filename, testChainKey)
+	suite.Require().Nil(err)
+	suite.Assert().Equal(uint64(10), result.Records)
+
+	_, err = VerifyChainFiles(filename, []byte("wrong key"))
+	suite.Assert().NotNil(err)
+}
+
+func (suite *ChainWriterTestSuite) TestCompressedBackup() {
+	directory := suite.T().TempDir()
+	older := filepath.Join(directory, "audit.log.1")
+	newer := filepath.Join(directory, "audit.log.2")
+
+	var log bytes.Buffer
+	writer := NewChainWriter(&log, testChainKey, ChainState{})
+	suite.writeRecords(writer, 0, 3)
+	suite.Require().Nil(os.WriteFile(older, log.Bytes(), 0600))
+	suite.Require().Nil(compressFile(older, gzipCodec{}, gzip.BestCompression))
+	log.Reset()
+	suite.writeRecords(writer, 3, 3)
+	suite.Require().Nil(os.WriteFile(newer, log.Bytes(), 0600))
+
+	result, err := VerifyChain([]string{older + ".gz", newer}, testChainKey)
+	suite.Require().Nil(err)
+	suite.Assert().Equal(uint64(6), result.Records)
+
+	_, err = VerifyChain([]string{newer, older + ".gz"}, testChainKey)
+	suite.Assert().NotNil(err)
+}
+
+func (suite *ChainWriterTestSuite) TestTamper() {
+	var log bytes.Buffer
+	writer := NewChainWriter(&log, nil, ChainState{})
+	suite.writeRecords(writer, 0, 3)
+	original := log.Bytes()
+
+	check := func(contents []byte) error {
+		filename := filepath.Join(suite.T().TempDir(), "tampered.log")
+		suite.Require().Nil(os.WriteFile(filename, contents, 0600))
+		_, err := VerifyChain([]string{filename}, nil)
+		return err
+	}
+	suite.Assert().Nil(check(original))
+	suite.Assert().NotNil(check(bytes.Replace(original, []byte("record 1"), []byte("record X"), 1)))
+	second := bytes.Index(original, []byte("#chain 1 "))
+	third := bytes.Index(original, []byte("#chain 2 "))
+	deleted := append(append([]byte(nil), original[:second]...), original[third:]...)
+	suite.Assert().NotNil(check(deleted))
+	// deleting the first record is a gap at the head
+	var chainErr *ChainError
+	suite.Require().True(errors.As(check(original[second:]), &chainErr))
+	suite.Assert().Equal(uint64(1), chainErr.Sequence)
+	reordered := append(append(append([]byte(nil), original[:second]...), original[third:]...), original[second:third]...)
+	suite.Assert().NotNil(check(reordered))
+	suite.Assert().NotNil(check(original[:len(original)-2]))
+}
+
+func (suite *ChainWriterTestSuite) TestFormatterHeader() {
+	filename := filepath.Join(suite.T().TempDir(), "audit.csv")
+	file, err := NewFileWriter(filename)
+	suite.Require().Nil(err)
+	writer, err := newFileChainWriter(file, filename, testChainKey, nil)
+	suite.Require().Nil(err)
+	suite.Require().Nil(writer.SetHeader([]byte("message\n")))
+	suite.writeRecords(writer, 0, 3)
+	suite.Require().Nil(file.Close())
+	original, err := os.ReadFile(filename)
+	suite.Require().Nil(err)
+
+	check := func(contents []byte) error {
+		suite.Require().Nil(os.WriteFile(filename, contents, 0600))
+		_, err := VerifyChain([]string{filename}, testChainKey)
+		return err
+	}
+	suite.Assert().Nil(check(original))
+	// a line inserted at the head is reported
+	suite.Assert().NotNil(check(append([]byte("forged\n"), original...)))
+	body := original[bytes.Index(original, []byte("#chain 0 ")):]
+	suite.Assert().NotNil(check(append([]byte("forged\n"), body...)))
+	// so is an edited header
+	suite.Assert().NotNil(check(bytes.Replace(original, []byte("message\n"), []byte("massage\n"), 1)))
+	// and a header framed without the key
+	var frame bytes.Buffer
+	forged := NewChainWriter(&headerRecorder{writer: &frame}, []byte("wrong key"), ChainState{})
+	suite.Require().Nil(forged.SetHeader([]byte("message\n")))
+	suite.Assert().NotNil(check(append(frame.Bytes(), body...)))
+}
+
+func (suite *ChainWriterTestSuite) TestCheckpoint() {
+	var log bytes.Buffer
+	writer := NewChainWriter(&log, testChainKey, ChainState{})
+	suite.writeRecords(writer, 0, 2)
+	checkpoint := writer.State()
+	suite.writeRecords(writer, 2, 3)
+	original := log.Bytes()
+	third := bytes.Index(original, []byte("#chain 2 "))
+	fourth := bytes.Index(original, []byte("#chain 3 "))
+
+	verify := func(contents []byte, start ChainState) (ChainVerification, error) {
+		filename := filepath.Join(suite.T().TempDir(), "audit.log")
+		suite.Require().Nil(os.WriteFile(filename, contents, 0600))
+		return VerifyChainFrom([]string{filename}, testChainKey, nil, start)
+	}
+	// the records before the checkpoint were pruned
+	result, err := verify(original[third:], checkpoint)
+	suite.Require().Nil(err)
+	suite.Assert().Equal(uint64(3), result.Records)
+	suite.Assert().Equal(uint64(2), result.FirstSequence)
+	suite.Assert().Equal(writer.State(), result.Last)
+
+	// without the checkpoint the pruned head is reported
+	_, err = verify(original[third:], ChainState{})
+	suite.Assert().NotNil(err)
+	// records after the checkpoint are missing
+	_, err = verify(original[fourth:], checkpoint)
+	suite.Assert().NotNil(err)
+	// the first record after the checkpoint was edited
+	_, err = verify(bytes.Replace(original, []byte("record 2"), []byte("record X"), 1), checkpoint)
+	suite.Assert().NotNil(err)
+	// a truncated tail verifies but ends before the writer's state
+	result, err = verify(original[:fourth], checkpoint)
+	suite.Require().Nil(err)
+	suite.Assert().NotEqual(writer.State(), result.Last)
+}
+
+func (suite *ChainWriterTestSuite) TestConfiguration() {
+	directory := suite.T().TempDir()
+	keyFile := filepath.Join(directory, "key")
+	suite.Require().Nil(os.WriteFile(keyFile, append(testChainKey, '\n'), 0600))
+	filename := filepath.Join(directory, "audit.log")
+
+	configuration := Configuration{
+		Settings:   Settings{Level: "Information", TriggerLevel: "Fatal", Backlog: 10},
+		Formatters: []FormatterEntry{{ID: "json", Filename: filename, Chain: true, ChainKeyFile: keyFile}},
+	}
+	suite.Require().Nil(configuration.LoadConfiguration())
+	configuration.GetLogger().Error("audited")
+	configuration.GetLogger().Warning("audited again")
+
+	result, err := VerifyChainFiles(filename, testChainKey)
+	suite.Require().Nil(err)
+	suite.Assert().Equal(uint64(2), result.Records)
+
+	// a key that resolves to nothing must not silently leave the chain unkeyed
+	suite.Require().Nil(os.WriteFile(keyFile, []byte("\n"), 0600))
+	suite.Assert().NotNil(configuration.LoadConfiguration())
+	configuration.Formatters[0].ChainKeyFile = ""
+	configuration.Formatters[0].ChainKeyEnv = "PFLOG_TEST_CHAIN_KEY"
+	suite.T().Setenv("PFLOG_TEST_CHAIN_KEY", "")
+	suite.Assert().NotNil(configuration.LoadConfiguration())
+	suite.T().Setenv("PFLOG_TEST_CHAIN_KEY", string(testChainKey))
+	suite.Require().Nil(configuration.LoadConfiguration())
+}
+
+func (suite *ChainWriterTestSuite) TestAsyncRecordPerEntry() {
+	filename := filepath.Join(suite.T().TempDir(), "audit.log")
+	configuration := Configuration{
+		Settings:   Settings{Level: "Information", TriggerLevel: "Fatal", Backlog: 10, Async: &AsyncEntry{}},
+		Formatters: []FormatterEntry{{ID: "text", Filename: filename, Chain: true}},
+	}
+	suite.Require().Nil(configuration.LoadConfiguration())
+	log := configuration.GetLogger()
+	log.SetCompactDuplicates(false)
+	for index := 0; index < 50; index++ {
+		log.Errorf("audited %d", index)
+	}
+	suite.Require().Nil(log.Close(context.Background()))
+
+	result, err := VerifyChainFiles(filename, nil)
+	suite.Require().Nil(err)
+	suite.Assert().Equal(uint64(50), result.Records)
+}
+
+// headerRecorder writes the header it is given
+type headerRecorder struct {
+	writer io.Writer
+}
+
+func (hr *headerRecorder) Write(p []byte) (int, error) {
+	return hr.writer.Write(p)
+}
+
+func (hr *headerRecorder) SetHeader(header []byte) error {
+	_, err := hr.writer.Write(header)
+	return err
+}
+
+func TestChainWriterTestSuite(t *testing.T) {
+	suite.Run(t, new(ChainWriterTestSuite))
+}
@@ -0,0 +1,116 @@
+// Command pflog-verify verifies hash chained pflog files, by default
+// the given log file together with all of its rotated backups.  The chain
+// must start at its first record unless a checkpoint, the
+// "<sequence>:<hash>" printed by an earlier run, is given with -from.
+//
+// Usage:
+//
+//	pflog-verify [-key-file file | -key-env name]
+//	             [-encryption-key-file file | -encryption-key-env name]
+//	             [-from sequence:hash] [-files] log...
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/PageFaultCode/pflog"
+)
+
+func main() {
+	keyFile := flag.String("key-file", "", "file holding the chain HMAC key")
+	keyEnv := flag.String("key-env", "", "environment variable holding the chain HMAC key")
+	encryptionKeyFile := flag.String("encryption-key-file", "", "file holding the key of encrypted logs")
+	encryptionKeyEnv := flag.String("encryption-key-env", "", "environment variable holding the key of encrypted logs")
+	filesOnly := flag.Bool("files", false, "verify exactly the given files, oldest first, instead of a log and its backups")
+	from := flag.String("from", "", "checkpoint sequence:hash to verify from, as printed by an earlier run")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	start, err := parseCheckpoint(*from)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pflog-verify: %v\n", err)
+		os.Exit(2)
+	}
+
+	var key []byte
+	if *keyFile != "" {
+		contents, err := os.ReadFile(filepath.Clean(*keyFile))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "pflog-verify: %v\n", err)
+			os.Exit(2)
+		}
+		key = bytes.TrimSpace(contents)
+	} else if *keyEnv != "" {
+		key = []byte(os.Getenv(*keyEnv))
+	}
+
+	var encryptionKey []byte
+	if *encryptionKeyFile != "" || *encryptionKeyEnv != "" {
+		contents := []byte(os.Getenv(*encryptionKeyEnv))
+		if *encryptionKeyFile != "" {
+			contents, err = os.ReadFile(filepath.Clean(*encryptionKeyFile))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "pflog-verify: %v\n", err)
+				os.Exit(2)
+			}
+		}
+		encryptionKey, err = pflog.ParseEncryptionKey(contents)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "pflog-verify: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	if *filesOnly {
+		os.Exit(report(pflog.VerifyChainFrom(flag.Args(), key, encryptionKey, start)))
+	}
+	status := 0
+	for _, filename := range flag.Args() {
+		fmt.Printf("%s: ", filename)
+		if result := report(pflog.VerifyChainFilesFrom(filename, key, encryptionKey, start)); result != 0 {
+			status = result
+		}
+	}
+	os.Exit(status)
+}
+
+func report(result pflog.ChainVerification, err error) int {
+	if err != nil {
+		fmt.Printf("FAILED after %d records: %v\n", result.Records, err)
+		return 1
+	}
+	fmt.Printf("OK, %d records from %d, checkpoint %d:%x\n", result.Records, result.FirstSequence, result.Last.Sequence, result.Last.Previous)
+	return 0
+}
+
+// parseCheckpoint parses a "<sequence>:<hash>" checkpoint, empty for the
+// start of a chain
+func parseCheckpoint(checkpoint string) (pflog.ChainState, error) {
+	if checkpoint == "" {
+		return pflog.ChainState{}, nil
+	}
+	sequence, sum, found := strings.Cut(checkpoint, ":")
+	next, err := strconv.ParseUint(sequence, 10, 64)
+	if !found || err != nil {
+		return pflog.ChainState{}, fmt.Errorf("malformed checkpoint: %s", checkpoint)
+	}
+	previous, err := hex.DecodeString(sum)
+	if err != nil {
+		return pflog.ChainState{}, fmt.Errorf("malformed checkpoint: %s", checkpoint)
+	}
+	if len(previous) == 0 {
+		previous = nil
+	}
+	return pflog.ChainState{Sequence: next, Previous: previous}, nil
+}
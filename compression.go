@@ -0,0 +1,197 @@
+// Package pflog defines all of the pflog package
+package pflog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressionCodec compresses rotated backups and reads them back
+type CompressionCodec interface {
+	// Suffix is appended to the backup name, e.g. ".gz", empty if the
+	// codec does not compress
+	Suffix() string
+	// Magic are the leading bytes identifying compressed data, used to
+	// read backups transparently, nil if the codec does not compress
+	Magic() []byte
+	// NewWriter compresses to w at level, DefaultCompressionLevel for
+	// the codec's default
+	NewWriter(w io.Writer, level int) (io.WriteCloser, error)
+	// NewReader decompresses r
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// DefaultCompressionLevel selects the default level of a codec, as zero
+// is a valid level for some codecs such as gzip
+const DefaultCompressionLevel = math.MinInt32
+
+// Names of the built in codecs
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+	CompressionNone = "none"
+)
+
+// ErrUnknownCompressionCodec is returned when looking up a codec
+// that has not been registered
+var ErrUnknownCompressionCodec = errors.New("unknown compression codec")
+
+var (
+	compressionCodecs     map[string]CompressionCodec
+	compressionCodecsLock sync.RWMutex
+)
+
+func init() {
+	compressionCodecs = make(map[string]CompressionCodec)
+	for name, codec := range map[string]CompressionCodec{
+		CompressionGzip: gzipCodec{},
+		CompressionZstd: zstdCodec{},
+		CompressionNone: noCompressionCodec{},
+	} {
+		if err := RegisterCompressionCodec(name, codec); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterCompressionCodec registers a codec by name for use in
+// configuration files, its suffix must be unique.  Names are case
+// insensitive.
+func RegisterCompressionCodec(name string, codec CompressionCodec) error {
+	compressionCodecsLock.Lock()
+	defer compressionCodecsLock.Unlock()
+
+	name = strings.ToLower(name)
+	if codec == nil {
+		return fmt.Errorf("compression codec %v is nil", name)
+	}
+	if _, exists := compressionCodecs[name]; exists {
+		return fmt.Errorf("compression codec %v already exists", name)
+	}
+	for existing, registered := range compressionCodecs {
+		if codec.Suffix() != "" && registered.Suffix() == codec.Suffix() {
+			return fmt.Errorf("compression codec %v uses the suffix of %v", name, existing)
+		}
+	}
+	compressionCodecs[name] = codec
+	return nil
+}
+
+// LookupCompressionCodec returns the codec registered by name, in any case
+func LookupCompressionCodec(name string) (CompressionCodec, error) {
+	compressionCodecsLock.RLock()
+	defer compressionCodecsLock.RUnlock()
+
+	codec, exists := compressionCodecs[strings.ToLower(name)]
+	if !exists {
+		return nil, fmt.Errorf("%v: %w", name, ErrUnknownCompressionCodec)
+	}
+	return codec, nil
+}
+
+// compressionSuffix returns the suffix of any registered codec the
+// file name ends with, empty if none does
+func compressionSuffix(name string) string {
+	compressionCodecsLock.RLock()
+	defer compressionCodecsLock.RUnlock()
+
+	for _, codec := range compressionCodecs {
+		if suffix := codec.Suffix(); suffix != "" && strings.HasSuffix(name, suffix) {
+			return suffix
+		}
+	}
+	return ""
+}
+
+// codecForData returns the registered codec whose magic starts data, nil if none
+func codecForData(data []byte) CompressionCodec {
+	compressionCodecsLock.RLock()
+	defer compressionCodecsLock.RUnlock()
+
+	for _, codec := range compressionCodecs {
+		if magic := codec.Magic(); len(magic) > 0 && bytes.HasPrefix(data, magic) {
+			return codec
+		}
+	}
+	return nil
+}
+
+// maxCompressionMagic is the number of bytes read to detect a codec
+const maxCompressionMagic = 8
+
+// gzipCodec levels follow compress/gzip, 0 storing the data uncompressed,
+// defaulting to gzip.BestCompression
+type gzipCodec struct{}
+
+func (gzipCodec) Suffix() string { return ".gz" }
+
+func (gzipCodec) Magic() []byte { return []byte{0x1f, 0x8b} }
+
+func (gzipCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == DefaultCompressionLevel {
+		level = gzip.BestCompression
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// zstdCodec levels follow the zstd command line, 1 to 22, defaulting to 3
+// which 0 selects too
+type zstdCodec struct{}
+
+func (zstdCodec) Suffix() string { return ".zst" }
+
+func (zstdCodec) Magic() []byte { return []byte{0x28, 0xb5, 0x2f, 0xfd} }
+
+func (zstdCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == DefaultCompressionLevel {
+		level = 0
+	}
+	if level < 0 || level > 22 {
+		return nil, fmt.Errorf("invalid zstd level: %d", level)
+	}
+	if level == 0 {
+		level = 3
+	}
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)), zstd.WithEncoderConcurrency(1))
+}
+
+func (zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return decoder.IOReadCloser(), nil
+}
+
+// noCompressionCodec leaves backups as they are
+type noCompressionCodec struct{}
+
+func (noCompressionCodec) Suffix() string { return "" }
+
+func (noCompressionCodec) Magic() []byte { return nil }
+
+func (noCompressionCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return nopWriteCloser{w}, nil
+}
+
+func (noCompressionCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(r), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,152 @@
+package pflog
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/yaml.v3"
+)
+
+type CompressionTestSuite struct {
+	suite.Suite
+}
+
+func (suite *CompressionTestSuite) TestRoundTrip() {
+	contents := strings.Repeat("a line of log output\n", 1000)
+	for _, name := range []string{CompressionGzip, CompressionZstd, CompressionNone} {
+		codec, err := LookupCompressionCodec(name)
+		suite.Require().Nil(err)
+		filename := filepath.Join(suite.T().TempDir(), "app.log.20240101")
+		suite.Require().Nil(os.WriteFile(filename, []byte(contents), 0600))
+		if codec.Suffix() == "" {
+			continue
+		}
+		suite.Require().Nil(compressFile(filename, codec, DefaultCompressionLevel))
+		suite.Assert().NoFileExists(filename)
+
+		reader, err := OpenLogFile(filename+codec.Suffix(), nil)
+		suite.Require().Nil(err)
+		read, err := io.ReadAll(reader)
+		suite.Require().Nil(err)
+		suite.Require().Nil(reader.Close())
+		suite.Assert().Equal(contents, string(read), name)
+	}
+}
+
+func (suite *CompressionTestSuite) TestRegistry() {
+	_, err := LookupCompressionCodec("xz")
+	suite.Assert().True(errors.Is(err, ErrUnknownCompressionCodec))
+	codec, err := LookupCompressionCodec("ZSTD")
+	suite.Require().Nil(err)
+	suite.Assert().Equal(".zst", codec.Suffix())
+
+	suite.Assert().NotNil(RegisterCompressionCodec(CompressionGzip, zstdCodec{}))
+	suite.Assert().NotNil(RegisterCompressionCodec("zstd2", zstdCodec{}))
+	suite.Assert().NotNil(RegisterCompressionCodec("nil", nil))
+
+	// names are case insensitive when registering too
+	suite.Assert().NotNil(RegisterCompressionCodec("GZIP", noCompressionCodec{}))
+	suite.Require().Nil(RegisterCompressionCodec("Stored", noCompressionCodec{}))
+	defer func() {
+		compressionCodecsLock.Lock()
+		defer compressionCodecsLock.Unlock()
+		delete(compressionCodecs, "stored")
+	}()
+	codec, err = LookupCompressionCodec("stored")
+	suite.Require().Nil(err)
+	suite.Assert().Equal(noCompressionCodec{}, codec)
+	_, err = LookupCompressionCodec("STORED")
+	suite.Assert().Nil(err)
+}
+
+func (suite *CompressionTestSuite) TestMixedDirectory() {
+	filename := filepath.Join(suite.T().TempDir(), "app.log")
+	older := filepath.Join(filepath.Dir(filename), "app.log.20240101-000000")
+	suite.Require().Nil(os.WriteFile(older, []byte("gzip era\n"), 0600))
+	suite.Require().Nil(compressFile(older, gzipCodec{}, DefaultCompressionLevel))
+
+	now := time.Date(2024, 3, 6, 10, 0, 0, 0, time.UTC)
+	writer, err := newRotatingWriter(filename, 10, 2, true)
+	suite.Require().Nil(err)
+	writer.clock = func() time.Time { return now }
+	writer.SetCompression(zstdCodec{})
+	suite.Require().Nil(writer.SetCompressionLevel(19))
+	suite.Assert().NotNil(writer.SetCompressionLevel(23))
+
+	for _, line := range []string{"first file\n", "second file\n", "third file\n"} {
+		_, err = writer.Write([]byte(line))
+		suite.Require().Nil(err)
+		now = now.Add(time.Second)
+	}
+	suite.Require().Nil(writer.Close())
+
+	var names []string
+	for _, path := range backupFiles(filename) {
+		names = append(names, filepath.Base(path))
+	}
+	// the gzip backup is pruned with the others, never recompressed
+	suite.Assert().Equal([]string{"app.log.20240306-100001.000.zst", "app.log.20240306-100002.000"}, names)
+}
+
+func (suite *CompressionTestSuite) TestNoCompression() {
+	filename := filepath.Join(suite.T().TempDir(), "app.log")
+	now := time.Date(2024, 3, 6, 10, 0, 0, 0, time.UTC)
+	writer, err := newRotatingWriter(filename, 10, 0, true)
+	suite.Require().Nil(err)
+	writer.clock = func() time.Time { return now }
+	writer.SetCompression(noCompressionCodec{})
+	for _, line := range []string{"first file\n", "second file\n", "third file\n"} {
+		_, err = writer.Write([]byte(line))
+		suite.Require().Nil(err)
+		now = now.Add(time.Second)
+	}
+	suite.Require().Nil(writer.Close())
+	suite.Assert().Len(writer.findBackups(false), 2)
+	suite.Assert().Empty(writer.findBackups(true))
+}
+
+func (suite *CompressionTestSuite) TestLevelZero() {
+	directory := suite.T().TempDir()
+	var configuration Configuration
+	suite.Require().Nil(yaml.Unmarshal([]byte(`
+settings:
+  level: Information
+  trigger_level: Fatal
+  backlog: 10
+formatters:
+  - id: text
+    filename: `+filepath.Join(directory, "app.log")+`
+    max_size_mb: 1
+    compress: true
+    compression_level: 0
+`), &configuration))
+	suite.Require().Nil(configuration.LoadConfiguration())
+	writer := configuration.GetLogger().outputTargets[0].(*RotatingWriter)
+	suite.Assert().Equal(0, writer.level)
+
+	// gzip level 0 stores the data
+	contents := strings.Repeat("a line of log output\n", 1000)
+	stored := filepath.Join(directory, "app.log.20240101")
+	suite.Require().Nil(os.WriteFile(stored, []byte(contents), 0600))
+	suite.Require().Nil(compressFile(stored, gzipCodec{}, writer.level))
+	info, err := os.Stat(stored + ".gz")
+	suite.Require().Nil(err)
+	suite.Assert().Greater(info.Size(), int64(len(contents)))
+	suite.Require().Nil(writer.Close())
+
+	configuration.Formatters[0].CompressionLevel = nil
+	suite.Require().Nil(configuration.LoadConfiguration())
+	writer = configuration.GetLogger().outputTargets[0].(*RotatingWriter)
+	suite.Assert().Equal(DefaultCompressionLevel, writer.level)
+	suite.Require().Nil(writer.Close())
+}
+
+func TestCompressionTestSuite(t *testing.T) {
+	suite.Run(t, new(CompressionTestSuite))
+}
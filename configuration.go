@@ -2,6 +2,10 @@
 package pflog
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -12,18 +16,208 @@ import (
 )
 
 type Settings struct {
-	Level        string `yaml:"level"`
-	TriggerLevel string `yaml:"trigger_level"`
-	Backlog      int    `yaml:"backlog"`
+	Level        string           `yaml:"level"`
+	TriggerLevel string           `yaml:"trigger_level"`
+	Backlog      int              `yaml:"backlog"`
+	Redaction    []RedactionEntry `yaml:"redaction,omitempty"` // applied to every entry before buffering
+	Async        *AsyncEntry      `yaml:"async,omitempty"`     // queue output and write it from a goroutine per target
+}
+
+// AsyncEntry is the configuration of asynchronous output
+type AsyncEntry struct {
+	QueueSize  int    `yaml:"queue_size,omitempty"`  // entries queued per target; defaults to 1024
+	BatchBytes int    `yaml:"batch_bytes,omitempty"` // most bytes written at once; defaults to 64KiB
+	Overflow   string `yaml:"overflow,omitempty"`    // block, drop_newest, drop_oldest or drop_below_level; defaults to block
+	Level      string `yaml:"level,omitempty"`       // for drop_below_level, entries below it are dropped
+}
+
+// asyncOptions returns the options of the configured asynchronous output
+func (entry *AsyncEntry) asyncOptions() (AsyncOptions, error) {
+	overflow, err := convertStringToOverflowPolicy(entry.Overflow)
+	if err != nil {
+		return AsyncOptions{}, err
+	}
+	options := AsyncOptions{QueueSize: entry.QueueSize, BatchBytes: entry.BatchBytes, Overflow: overflow}
+	if entry.Level != "" {
+		options.Level = convertStringToLevel(entry.Level)
+	}
+	return options, nil
+}
+
+// RedactionEntry is the configuration of a RedactionRule
+type RedactionEntry struct {
+	Tags        []string `yaml:"tags,omitempty"`        // tag names whose values are redacted
+	Builtin     string   `yaml:"builtin,omitempty"`     // credit_card, email or bearer
+	Pattern     string   `yaml:"pattern,omitempty"`     // regular expression applied to messages and string tags
+	Strategy    string   `yaml:"strategy,omitempty"`    // replace, hash or partial; defaults to replace
+	Replacement string   `yaml:"replacement,omitempty"` // for replace; defaults to [REDACTED]
+	Keep        int      `yaml:"keep,omitempty"`        // for partial, trailing characters left visible; defaults to 4
+	KeyEnv      string   `yaml:"key_env,omitempty"`     // for hash, environment variable holding an HMAC key
+}
+
+// createRedactor returns a redactor for the configured rules, nil if there are none
+func (settings *Settings) createRedactor() (*Redactor, error) {
+	if len(settings.Redaction) == 0 {
+		return nil, nil
+	}
+	rules := make([]*RedactionRule, 0, len(settings.Redaction))
+	for _, v := range settings.Redaction {
+		strategy, err := convertStringToRedactionStrategy(v.Strategy)
+		if err != nil {
+			return nil, err
+		}
+		pattern, err := redactionPattern(v.Builtin, v.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		rule := &RedactionRule{Tags: v.Tags, Pattern: pattern, Strategy: strategy, Replacement: v.Replacement, Keep: v.Keep}
+		if v.KeyEnv != "" {
+			rule.Key = []byte(os.Getenv(v.KeyEnv))
+			if len(rule.Key) == 0 {
+				return nil, fmt.Errorf("redaction key environment variable %s is unset or empty", v.KeyEnv)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return NewRedactor(rules...), nil
 }
 
 type FormatterEntry struct {
-	ID              string `yaml:"id"`
-	Filename        string `yaml:"filename,omitempty"`
-	TimestampFormat string `yaml:"timestamp_format,omitempty"` // Go time layout; defaults to RFC3339
-	MaxSizeMB       int    `yaml:"max_size_mb,omitempty"`      // rotate when file exceeds this size; 0 = disabled
-	MaxBackups      int    `yaml:"max_backups,omitempty"`      // number of rotated files to keep; 0 = keep all
-	Compress        bool   `yaml:"compress,omitempty"`         // gzip older backups; newest backup stays plain
+	ID                string       `yaml:"id"`
+	Filename          string       `yaml:"filename,omitempty"`
+	TimestampFormat   string       `yaml:"timestamp_format,omitempty"`    // Go time layout; defaults to RFC3339
+	MaxSizeMB         int          `yaml:"max_size_mb,omitempty"`         // rotate when file exceeds this size; 0 = disabled
+	MaxBackups        int          `yaml:"max_backups,omitempty"`         // number of rotated files to keep; 0 = keep all
+	Compress          bool         `yaml:"compress,omitempty"`            // gzip older backups; newest backup stays plain
+	BackupNaming      string       `yaml:"backup_naming,omitempty"`       // timestamp (default) or numbered like logrotate
+	BackupLocalTime   bool         `yaml:"backup_local_time,omitempty"`   // timestamp backups in local time instead of UTC
+	Compression       string       `yaml:"compression,omitempty"`         // gzip, zstd or none; implies compress unless none
+	CompressionLevel  *int         `yaml:"compression_level,omitempty"`   // codec specific, gzip 0-9 (default 9), zstd 1-22 (default 3)
+	MaxAge            string       `yaml:"max_age,omitempty"`             // remove backups older than this, e.g. 14d, 2w or 36h
+	MaxTotalSizeMB    int          `yaml:"max_total_size_mb,omitempty"`   // remove the oldest backups beyond this total size
+	RotateEvery       string       `yaml:"rotate_every,omitempty"`        // hourly, daily or weekly; alone or with max_size_mb
+	RotateAt          string       `yaml:"rotate_at,omitempty"`           // local time in the period: "MM", "HH:MM" or "monday HH:MM"
+	CurrentLink       string       `yaml:"current_link,omitempty"`        // symlink kept pointing at the live file, e.g. app-current.log
+	Sync              string       `yaml:"sync,omitempty"`                // fsync policy: never, write, trigger, a level or an interval like 100ms
+	Retry             *RetryEntry  `yaml:"retry,omitempty"`               // retry failed writes and disable a persistently failing target
+	Template          string       `yaml:"template,omitempty"`            // text/template layout for the template formatter
+	Columns           []string     `yaml:"columns,omitempty"`             // csv/tsv columns: timestamp, level, message or a tag name
+	Header            bool         `yaml:"header,omitempty"`              // csv/tsv header row at the start of every file
+	Multiline         string       `yaml:"multiline,omitempty"`           // raw, escape, indent or split; defaults to raw
+	Sanitize          string       `yaml:"sanitize,omitempty"`            // none, escape or strip control characters; defaults to none
+	Indent            string       `yaml:"indent,omitempty"`              // continuation indent for multiline indent
+	Level             string       `yaml:"level,omitempty"`               // minimum level for this target; defaults to all
+	Filter            *FilterEntry `yaml:"filter,omitempty"`              // optional area, tag and message filter
+	Chain             bool         `yaml:"chain,omitempty"`               // tamper evident hash chained records
+	ChainKeyFile      string       `yaml:"chain_key_file,omitempty"`      // file holding an HMAC key for the chain
+	ChainKeyEnv       string       `yaml:"chain_key_env,omitempty"`       // environment variable holding an HMAC key for the chain
+	Encrypt           bool         `yaml:"encrypt,omitempty"`             // AES-256-GCM encrypt rotated backups
+	EncryptLive       bool         `yaml:"encrypt_live,omitempty"`        // also encrypt the live file, implies encrypt
+	EncryptionKeyFile string       `yaml:"encryption_key_file,omitempty"` // file holding the 32 byte key, raw, hex or base64
+	EncryptionKeyEnv  string       `yaml:"encryption_key_env,omitempty"`  // environment variable holding the key, hex or base64
+	// Options are passed to the formatter factory, e.g. pretty_print for json
+	Options map[string]interface{} `yaml:"options,omitempty"`
+	// Failover, Tee, Network or HTTP replace filename, the targets of a
+	// failover or tee use the target keys of a formatter entry such as filename
+	Failover *FailoverEntry   `yaml:"failover,omitempty"` // a primary target falling back to a local spool
+	Tee      []FormatterEntry `yaml:"tee,omitempty"`      // targets each receiving every record
+	Network  *NetworkEntry    `yaml:"network,omitempty"`  // a collector records are shipped to over TCP
+	HTTP     *HTTPEntry       `yaml:"http,omitempty"`     // a collector records are posted to in batches
+}
+
+// FailoverEntry is the configuration of a FailoverWriter
+type FailoverEntry struct {
+	Primary       FormatterEntry `yaml:"primary"`
+	Spool         string         `yaml:"spool"`                    // file records are spooled to while the primary fails
+	RetryInterval string         `yaml:"retry_interval,omitempty"` // how often a failed primary is tried; defaults to 5s
+}
+
+// NetworkEntry is the configuration of a NetworkWriter
+type NetworkEntry struct {
+	Address        string `yaml:"address"`                   // host:port of the collector
+	Framing        string `yaml:"framing,omitempty"`         // newline (default) or octet_counting
+	SpoolDirectory string `yaml:"spool_directory,omitempty"` // spool records here while the collector is unreachable
+	MaxBackoff     string `yaml:"max_backoff,omitempty"`     // longest wait between reconnects; defaults to 30s
+	TLS            bool   `yaml:"tls,omitempty"`             // connect with TLS
+	CAFile         string `yaml:"ca_file,omitempty"`         // PEM certificates to trust instead of the system roots
+	CertFile       string `yaml:"cert_file,omitempty"`       // PEM client certificate
+	KeyFile        string `yaml:"key_file,omitempty"`        // PEM client key
+	ServerName     string `yaml:"server_name,omitempty"`     // name to verify instead of the address' host
+}
+
+// HTTPEntry is the configuration of an HTTPWriter
+type HTTPEntry struct {
+	URL           string            `yaml:"url"`
+	Encoder       string            `yaml:"encoder,omitempty"`        // ndjson (default), loki or elasticsearch
+	Labels        map[string]string `yaml:"labels,omitempty"`         // loki stream labels
+	Index         string            `yaml:"index,omitempty"`          // elasticsearch index
+	Headers       map[string]string `yaml:"headers,omitempty"`        // added to every request
+	Gzip          bool              `yaml:"gzip,omitempty"`           // gzip request bodies
+	MaxRecords    int               `yaml:"max_records,omitempty"`    // records per batch; defaults to 500
+	MaxBytes      int               `yaml:"max_bytes,omitempty"`      // bytes of records per batch; defaults to 1 MiB
+	FlushInterval string            `yaml:"flush_interval,omitempty"` // longest a record waits in a batch; defaults to 1s
+	Timeout       string            `yaml:"timeout,omitempty"`        // of every request; defaults to 10s
+	Retries       int               `yaml:"retries,omitempty"`        // retries on 5xx, 429 or network errors; defaults to 3, negative for none
+	MaxBackoff    string            `yaml:"max_backoff,omitempty"`    // longest wait between retries; defaults to 30s
+}
+
+// formatterOptions returns the formatter specific options including
+// those given as dedicated fields of the entry
+func (entry *FormatterEntry) formatterOptions() map[string]interface{} {
+	options := make(map[string]interface{}, len(entry.Options)+3)
+	for k, v := range entry.Options {
+		options[k] = v
+	}
+	if entry.Template != "" {
+		options["template"] = entry.Template
+	}
+	if len(entry.Columns) > 0 {
+		options["columns"] = entry.Columns
+	}
+	if entry.Header {
+		options["header"] = entry.Header
+	}
+	return options
+}
+
+// RetryEntry is the configuration of a target RetryPolicy
+type RetryEntry struct {
+	Attempts      int    `yaml:"attempts,omitempty"`       // retries after a failed write
+	Backoff       string `yaml:"backoff,omitempty"`        // wait before the first retry, doubling, e.g. 10ms
+	MaxBackoff    string `yaml:"max_backoff,omitempty"`    // longest wait between retries
+	DisableAfter  int    `yaml:"disable_after,omitempty"`  // consecutive failed entries before the target is disabled
+	ReenableAfter string `yaml:"reenable_after,omitempty"` // how long a disabled target is skipped; defaults to 1m
+}
+
+// retryPolicy returns the configured policy, the zero policy if entry is nil
+func (entry *RetryEntry) retryPolicy() (RetryPolicy, error) {
+	if entry == nil {
+		return RetryPolicy{}, nil
+	}
+	policy := RetryPolicy{Attempts: entry.Attempts, DisableAfter: entry.DisableAfter}
+	var err error
+	for _, duration := range []struct {
+		value  string
+		target *time.Duration
+	}{
+		{entry.Backoff, &policy.Backoff},
+		{entry.MaxBackoff, &policy.MaxBackoff},
+		{entry.ReenableAfter, &policy.ReenableAfter},
+	} {
+		*duration.target, err = convertStringToDuration(duration.value)
+		if err != nil {
+			return RetryPolicy{}, err
+		}
+	}
+	return policy, policy.validate()
+}
+
+// FilterEntry is the configuration of a target Filter
+type FilterEntry struct {
+	Areas   []string          `yaml:"areas,omitempty"`   // values of the area tag to accept
+	Tags    map[string]string `yaml:"tags,omitempty"`    // tags that must be present with the given value
+	Message string            `yaml:"message,omitempty"` // regular expression the message must match
+	Exclude string            `yaml:"exclude,omitempty"` // regular expression of messages to drop
 }
 
 type Configuration struct {
@@ -32,6 +226,286 @@ type Configuration struct {
 	UserLog    *Log             // will be non-nil if specified
 }
 
+// applyMessagePolicy wraps the formatter if a multi-line or
+// sanitize policy other than the default is configured
+func (entry *FormatterEntry) applyMessagePolicy(formatter LogFormatter) (LogFormatter, error) {
+	multiline, err := convertStringToMultilinePolicy(entry.Multiline)
+	if err != nil {
+		return nil, err
+	}
+	sanitize, err := convertStringToSanitizePolicy(entry.Sanitize)
+	if err != nil {
+		return nil, err
+	}
+	if multiline == MultilineRaw && sanitize == SanitizeNone {
+		return formatter, nil
+	}
+	return NewMessagePolicyFormatter(formatter, MessagePolicy{Multiline: multiline, Sanitize: sanitize, Indent: entry.Indent}), nil
+}
+
+// createFilter returns the configured filter, nil if there is none
+func (entry *FormatterEntry) createFilter() (*Filter, error) {
+	if entry.Filter == nil {
+		return nil, nil
+	}
+	return NewFilter(entry.Filter.Areas, entry.Filter.Tags, entry.Filter.Message, entry.Filter.Exclude)
+}
+
+// chainKey returns the configured chain HMAC key, nil if none
+func (entry *FormatterEntry) chainKey() ([]byte, error) {
+	if entry.ChainKeyFile != "" {
+		key, err := ioutil.ReadFile(filepath.Clean(entry.ChainKeyFile))
+		if err != nil {
+			return nil, err
+		}
+		key = bytes.TrimSpace(key)
+		if len(key) == 0 {
+			return nil, fmt.Errorf("chain key file %s is empty", entry.ChainKeyFile)
+		}
+		return key, nil
+	}
+	if entry.ChainKeyEnv != "" {
+		key := []byte(os.Getenv(entry.ChainKeyEnv))
+		if len(key) == 0 {
+			return nil, fmt.Errorf("chain key environment variable %s is unset or empty", entry.ChainKeyEnv)
+		}
+		return key, nil
+	}
+	return nil, nil
+}
+
+// encryptionKey returns the configured encryption key, nil if
+// encryption is not enabled
+func (entry *FormatterEntry) encryptionKey() ([]byte, error) {
+	if !entry.Encrypt && !entry.EncryptLive {
+		return nil, nil
+	}
+	var contents []byte
+	if entry.EncryptionKeyFile != "" {
+		var err error
+		contents, err = ioutil.ReadFile(filepath.Clean(entry.EncryptionKeyFile))
+		if err != nil {
+			return nil, err
+		}
+	} else if entry.EncryptionKeyEnv != "" {
+		contents = []byte(os.Getenv(entry.EncryptionKeyEnv))
+	} else {
+		return nil, fmt.Errorf("encryption of %s needs encryption_key_file or encryption_key_env", entry.Filename)
+	}
+	return ParseEncryptionKey(contents)
+}
+
+// errTargetOpen wraps the error of an output target that could not be
+// opened, a formatter entry with such a target is skipped
+var errTargetOpen = errors.New("unable to open target")
+
+// createWriter returns the writer of the configured target, the formatter
+// keys of the entry are ignored
+func (entry *FormatterEntry) createWriter() (io.Writer, error) {
+	switch {
+	case entry.Failover != nil:
+		return entry.Failover.createWriter()
+	case len(entry.Tee) > 0:
+		writers := make([]io.Writer, 0, len(entry.Tee))
+		for i := range entry.Tee {
+			writer, err := entry.Tee[i].createWriter()
+			if err != nil {
+				NewTeeWriter(writers...).Close() //nolint:errcheck
+				return nil, err
+			}
+			writers = append(writers, writer)
+		}
+		return NewTeeWriter(writers...), nil
+	case entry.Network != nil:
+		return entry.Network.createWriter()
+	case entry.HTTP != nil:
+		return entry.HTTP.createWriter()
+	case entry.Filename == "stdout":
+		return os.Stdout, nil
+	}
+
+	encryptionKey, err := entry.encryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	var key []byte
+	if entry.Chain {
+		key, err = entry.chainKey()
+		if err != nil {
+			return nil, err
+		}
+	}
+	writer, err := entry.createFileWriter(encryptionKey)
+	if err != nil || !entry.Chain {
+		return writer, err
+	}
+	chain, err := newFileChainWriter(writer, filepath.Clean(entry.Filename), key, encryptionKey)
+	if err != nil {
+		if closer := closerOf(writer); closer != nil {
+			closer.Close() //nolint:errcheck
+		}
+		return nil, err
+	}
+	return chain, nil
+}
+
+// createFileWriter returns a rotating writer if rotation, encryption or a
+// current link is configured and a plain file writer otherwise
+func (entry *FormatterEntry) createFileWriter(encryptionKey []byte) (io.Writer, error) {
+	schedule, err := ParseRotationSchedule(entry.RotateEvery, entry.RotateAt)
+	if err != nil {
+		return nil, err
+	}
+	maxAge, err := parseRetentionAge(entry.MaxAge)
+	if err != nil {
+		return nil, err
+	}
+	naming, err := convertStringToBackupNaming(entry.BackupNaming)
+	if err != nil {
+		return nil, err
+	}
+	if entry.MaxSizeMB <= 0 && encryptionKey == nil && schedule.Every == RotateNever && entry.CurrentLink == "" {
+		writer, err := NewFileWriter(filepath.Clean(entry.Filename))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errTargetOpen, err)
+		}
+		return writer, nil
+	}
+
+	rw, err := newRotatingWriter(filepath.Clean(entry.Filename), int64(entry.MaxSizeMB)*1024*1024, entry.MaxBackups, entry.Compress)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errTargetOpen, err)
+	}
+	err = entry.configureRotatingWriter(rw, schedule, naming, maxAge, encryptionKey)
+	if err != nil {
+		rw.Close() //nolint:errcheck
+		return nil, err
+	}
+	return rw, nil
+}
+
+// configureRotatingWriter applies the rotation keys of the entry to rw
+func (entry *FormatterEntry) configureRotatingWriter(rw *RotatingWriter, schedule RotationSchedule, naming BackupNaming, maxAge time.Duration, encryptionKey []byte) error {
+	err := rw.SetSchedule(schedule)
+	if err != nil {
+		return err
+	}
+	err = rw.SetBackupNaming(naming, entry.BackupLocalTime)
+	if err != nil {
+		return err
+	}
+	if entry.Compression != "" {
+		codec, codecErr := LookupCompressionCodec(entry.Compression)
+		if codecErr != nil {
+			return codecErr
+		}
+		rw.SetCompression(codec)
+	}
+	if entry.CompressionLevel != nil {
+		err = rw.SetCompressionLevel(*entry.CompressionLevel)
+		if err != nil {
+			return err
+		}
+	}
+	rw.SetRetention(maxAge, int64(entry.MaxTotalSizeMB)*1024*1024)
+	if encryptionKey != nil {
+		err = rw.SetEncryption(encryptionKey, entry.EncryptLive)
+		if err != nil {
+			return err
+		}
+	}
+	if entry.CurrentLink != "" {
+		err = rw.SetCurrentLink(filepath.Clean(entry.CurrentLink))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createWriter returns the configured FailoverWriter
+func (entry *FailoverEntry) createWriter() (io.Writer, error) {
+	interval, err := convertStringToDuration(entry.RetryInterval)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Spool == "" {
+		return nil, fmt.Errorf("failover needs a spool file")
+	}
+	primary, err := entry.Primary.createWriter()
+	if err != nil {
+		return nil, err
+	}
+	writer, err := NewFailoverWriter(primary, entry.Spool)
+	if err != nil {
+		if closer := closerOf(primary); closer != nil {
+			closer.Close() //nolint:errcheck
+		}
+		return nil, fmt.Errorf("%w: %w", errTargetOpen, err)
+	}
+	if interval > 0 {
+		err = writer.SetRetryInterval(interval)
+		if err != nil {
+			writer.Close() //nolint:errcheck
+			return nil, err
+		}
+	}
+	return writer, nil
+}
+
+// createWriter returns the configured NetworkWriter
+func (entry *NetworkEntry) createWriter() (io.Writer, error) {
+	framing, err := convertStringToNetworkFraming(entry.Framing)
+	if err != nil {
+		return nil, err
+	}
+	maxBackoff, err := convertStringToDuration(entry.MaxBackoff)
+	if err != nil {
+		return nil, err
+	}
+	options := NetworkOptions{Framing: framing, SpoolDirectory: entry.SpoolDirectory, MaxBackoff: maxBackoff}
+	if entry.TLS || entry.CAFile != "" || entry.CertFile != "" {
+		options.TLS, err = NewTLSConfig(entry.CAFile, entry.CertFile, entry.KeyFile, entry.ServerName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	writer, err := NewNetworkWriter(entry.Address, options)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errTargetOpen, err)
+	}
+	return writer, nil
+}
+
+// createWriter returns the configured HTTPWriter
+func (entry *HTTPEntry) createWriter() (io.Writer, error) {
+	encoder, err := convertStringToHTTPEncoder(entry.Encoder, entry.Labels, entry.Index)
+	if err != nil {
+		return nil, err
+	}
+	options := HTTPOptions{
+		Encoder:    encoder,
+		Headers:    entry.Headers,
+		Gzip:       entry.Gzip,
+		MaxRecords: entry.MaxRecords,
+		MaxBytes:   entry.MaxBytes,
+		Retries:    entry.Retries,
+	}
+	for _, duration := range []struct {
+		value  string
+		option *time.Duration
+	}{
+		{entry.FlushInterval, &options.FlushInterval},
+		{entry.Timeout, &options.Timeout},
+		{entry.MaxBackoff, &options.MaxBackoff},
+	} {
+		if *duration.option, err = convertStringToDuration(duration.value); err != nil {
+			return nil, err
+		}
+	}
+	return NewHTTPWriter(entry.URL, options)
+}
+
 func (configuration *Configuration) LoadConfigurationFile(filename string) error {
 	configuration.UserLog = nil
 	fileContents, err := ioutil.ReadFile(filepath.Clean(filename))
@@ -50,6 +524,20 @@ func (configuration *Configuration) LoadConfigurationFile(filename string) error
 
 func (configuration *Configuration) LoadConfiguration() error {
 	log := New()
+	err := configuration.configure(log)
+	if err != nil {
+		// close the targets and queues of the entries before the bad one
+		log.Close(context.Background()) //nolint:errcheck
+		return err
+	}
+
+	configuration.UserLog = log
+
+	return nil
+}
+
+// configure sets up log from the configuration
+func (configuration *Configuration) configure(log *Log) error {
 	err := log.SetLevel(convertStringToLevel(configuration.Settings.Level))
 	if err != nil {
 		return err
@@ -62,38 +550,80 @@ func (configuration *Configuration) LoadConfiguration() error {
 	if err != nil {
 		return err
 	}
+	redactor, err := configuration.Settings.createRedactor()
+	if err != nil {
+		return err
+	}
+	log.SetRedactor(redactor)
+	if configuration.Settings.Async != nil {
+		options, asyncErr := configuration.Settings.Async.asyncOptions()
+		if asyncErr != nil {
+			return asyncErr
+		}
+		err = log.SetAsync(options)
+		if err != nil {
+			return err
+		}
+	}
 
-	for _, v := range configuration.Formatters {
-		formatter, createErr := CreateFormatter(v.ID)
-		if createErr != nil {
+	for i := range configuration.Formatters {
+		v := &configuration.Formatters[i]
+		formatter, createErr := CreateFormatterWithOptions(v.ID, v.formatterOptions())
+		if errors.Is(createErr, ErrUnknownFormatter) {
+			reportError(fmt.Errorf("formatter %d skipped: %w", i, createErr))
 			continue
 		}
+		if createErr != nil {
+			return createErr
+		}
 		tsFormat := v.TimestampFormat
 		if tsFormat == "" {
 			tsFormat = time.RFC3339
 		}
 		formatter.SetTimestampFormat(tsFormat)
-		var outWriter io.Writer
-		if v.Filename == "stdout" {
-			outWriter = os.Stdout
-		} else if v.MaxSizeMB > 0 {
-			rw, rwErr := newRotatingWriter(filepath.Clean(v.Filename), int64(v.MaxSizeMB)*1024*1024, v.MaxBackups, v.Compress)
-			if rwErr != nil {
-				continue
-			}
-			outWriter = rw
-		} else {
-			outFile, fileErr := os.OpenFile(filepath.Clean(v.Filename), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-			if fileErr != nil {
-				continue
+		filter, err := v.createFilter()
+		if err != nil {
+			return err
+		}
+		syncPolicy, err := convertStringToSyncPolicy(v.Sync)
+		if err != nil {
+			return err
+		}
+		retryPolicy, err := v.Retry.retryPolicy()
+		if err != nil {
+			return err
+		}
+		var outputLevel LogLevel
+		if v.Level != "" {
+			outputLevel, err = parseLevel(v.Level)
+			if err != nil {
+				return err
 			}
-			outWriter = outFile
 		}
-		_ = log.AddOutputTargetAndFormatter(outWriter, formatter)
+		// the target is opened last so that no error leaves it open
+		policyFormatter, err := v.applyMessagePolicy(formatter)
+		if err != nil {
+			return err
+		}
+		outWriter, err := v.createWriter()
+		if errors.Is(err, errTargetOpen) {
+			reportError(fmt.Errorf("formatter %d skipped: %w", i, err))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if consoleFormatter, ok := formatter.(*ConsoleFormatter); ok {
+			consoleFormatter.DetectColor(outWriter)
+		}
+		index := log.AddOutputTargetAndFormatter(outWriter, policyFormatter)
+		if v.Level != "" {
+			_ = log.SetOutputLevel(index, outputLevel)
+		}
+		_ = log.SetOutputFilter(index, filter)
+		_ = log.SetOutputSyncPolicy(index, syncPolicy)
+		_ = log.SetOutputRetryPolicy(index, retryPolicy)
 	}
-
-	configuration.UserLog = log
-
 	return nil
 }
 
@@ -2,7 +2,11 @@
 package pflog
 
 import (
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 )
@@ -24,6 +28,48 @@ func (suite *ConfigurationTestSuite) TestSettingsLoad() {
 	suite.Assert().Equal(testBacklog, configuration.Settings.Backlog)
 }
 
+func (suite *ConfigurationTestSuite) TestErrorClosesTargets() {
+	directory := suite.T().TempDir()
+	level := 99
+	goroutines := runtime.NumGoroutine()
+	files := openFiles()
+
+	// a bad entry after an open rotating file with an async queue
+	configuration := Configuration{
+		Settings: Settings{Level: "Information", TriggerLevel: "Fatal", Backlog: 10, Async: &AsyncEntry{}},
+		Formatters: []FormatterEntry{
+			{ID: "text", Filename: filepath.Join(directory, "first.log"), MaxSizeMB: 1, MaxAge: "1h"},
+			{ID: "text", Filename: filepath.Join(directory, "second.log"), Sync: "sometimes"},
+		},
+	}
+	suite.Assert().NotNil(configuration.LoadConfiguration())
+	suite.Assert().Nil(configuration.UserLog)
+
+	// a rotating file failing after it was opened
+	configuration.Formatters = []FormatterEntry{
+		{ID: "text", Filename: filepath.Join(directory, "third.log"), MaxSizeMB: 1, CompressionLevel: &level},
+	}
+	suite.Assert().NotNil(configuration.LoadConfiguration())
+
+	// the rotating writer workers and the async queue have stopped
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	suite.Assert().LessOrEqual(runtime.NumGoroutine(), goroutines)
+	// and the files are closed
+	suite.Assert().Equal(files, openFiles())
+}
+
+// openFiles returns the number of open file descriptors, -1 if unknown
+func openFiles() int {
+	descriptors, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(descriptors)
+}
+
 func TestConfigurationTestSuite(t *testing.T) {
 	suite.Run(t, new(ConfigurationTestSuite))
 }
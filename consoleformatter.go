@@ -0,0 +1,159 @@
+// Package pflog defines all of the pflog package
+package pflog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var consoleformatterTypeID = "console"
+
+// ANSI escape sequences used by the console formatter
+const (
+	ansiReset   = "\x1b[0m"
+	ansiBold    = "\x1b[1m"
+	ansiDim     = "\x1b[2m"
+	ansiRed     = "\x1b[31m"
+	ansiGreen   = "\x1b[32m"
+	ansiYellow  = "\x1b[33m"
+	ansiBlue    = "\x1b[34m"
+	ansiMagenta = "\x1b[35m"
+	ansiCyan    = "\x1b[36m"
+	ansiWhite   = "\x1b[37m"
+)
+
+// consoleLevelWidth is the width of the level column, wide
+// enough for the longest level name (INFORMATION)
+const consoleLevelWidth = len("INFORMATION")
+
+// ConsoleFormatter formats entries for human consumption on a
+// terminal, with a fixed width level column, colors per level,
+// dimmed timestamps and highlighted tag keys.  Continuation lines
+// of multi-line messages are indented to line up with the message.
+type ConsoleFormatter struct {
+	timeFormat string
+	colorize   bool
+}
+
+// NewConsoleFormatter returns a console formatter with colors
+// enabled only if the given writer is a terminal and NO_COLOR
+// is not set in the environment
+func NewConsoleFormatter(writer io.Writer) *ConsoleFormatter {
+	return &ConsoleFormatter{colorize: shouldColorize(writer)}
+}
+
+// ID returns the specified ID of this formatter
+func (cf *ConsoleFormatter) ID() string {
+	return consoleformatterTypeID
+}
+
+// SetTimestampFormat sets the time stamp format
+// to the provided string representation for outputing
+// time/date information
+func (cf *ConsoleFormatter) SetTimestampFormat(format string) {
+	cf.timeFormat = format
+}
+
+// SetColor forces colors on or off regardless of the output target
+func (cf *ConsoleFormatter) SetColor(colorize bool) {
+	cf.colorize = colorize
+}
+
+// DetectColor enables colors if the writer is a terminal and
+// NO_COLOR is not set
+func (cf *ConsoleFormatter) DetectColor(writer io.Writer) {
+	cf.colorize = shouldColorize(writer)
+}
+
+// Format formats a log entry into an aligned, optionally colored line
+// such as:
+// 2006-01-02T15:04:05Z07:00 WARNING     area=disk low space
+func (cf *ConsoleFormatter) Format(entry *Entry) []byte {
+	var builder strings.Builder
+
+	prefixWidth := 0
+	if cf.timeFormat != "" {
+		dateString := entry.timestamp.Local().Format(cf.timeFormat)
+		builder.WriteString(cf.paint(ansiDim, dateString))
+		builder.WriteByte(' ')
+		prefixWidth += len(dateString) + 1
+	}
+
+	levelString, err := convertLevelToString(entry.level, true)
+	if err != nil {
+		levelString = err.Error()
+	}
+	padding := ""
+	if len(levelString) < consoleLevelWidth {
+		padding = strings.Repeat(" ", consoleLevelWidth-len(levelString))
+	}
+	builder.WriteString(cf.paint(consoleLevelColor(entry.level), levelString))
+	builder.WriteString(padding)
+	builder.WriteByte(' ')
+	prefixWidth += len(levelString) + len(padding) + 1
+
+	for _, v := range entry.tags {
+		builder.WriteString(cf.paint(ansiCyan, v.name))
+		builder.WriteByte('=')
+		builder.WriteString(fmt.Sprintf("%v", v.value))
+		builder.WriteByte(' ')
+	}
+
+	lines := strings.Split(strings.TrimRight(entry.message, "\n"), "\n")
+	builder.WriteString(lines[0])
+	builder.WriteByte('\n')
+	indent := strings.Repeat(" ", prefixWidth)
+	for _, line := range lines[1:] {
+		builder.WriteString(indent)
+		builder.WriteString(line)
+		builder.WriteByte('\n')
+	}
+
+	return []byte(builder.String())
+}
+
+// paint wraps text in the given escape sequence when colors are enabled
+func (cf *ConsoleFormatter) paint(color string, text string) string {
+	if !cf.colorize {
+		return text
+	}
+	return color + text + ansiReset
+}
+
+// consoleLevelColor returns the escape sequence for a given level
+func consoleLevelColor(level LogLevel) string {
+	switch level {
+	case Trace:
+		return ansiWhite
+	case Debug:
+		return ansiBlue
+	case Information:
+		return ansiGreen
+	case Warning:
+		return ansiYellow
+	case Error:
+		return ansiRed
+	case Fatal:
+		return ansiBold + ansiMagenta
+	}
+	return ansiReset
+}
+
+// shouldColorize returns true if the writer is a character device
+// and the NO_COLOR convention (https://no-color.org) is not in effect
+func shouldColorize(writer io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	file, ok := writer.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
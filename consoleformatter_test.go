@@ -0,0 +1,60 @@
+package pflog
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ConsoleFormatterTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ConsoleFormatterTestSuite) TestPlainAlignment() {
+	formatter := NewConsoleFormatter(&bytes.Buffer{})
+
+	output := string(formatter.Format(NewEntry(Error, time.Now(), "first\nsecond", []*Tag{CreateTag("area", "disk")})))
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+
+	suite.Assert().Equal(2, len(lines))
+	suite.Assert().Equal("ERROR       area=disk first", lines[0])
+	suite.Assert().Equal(strings.Repeat(" ", consoleLevelWidth+1)+"second", lines[1])
+	suite.Assert().False(strings.Contains(output, "\x1b["))
+}
+
+func (suite *ConsoleFormatterTestSuite) TestColor() {
+	formatter := &ConsoleFormatter{}
+	formatter.SetColor(true)
+	formatter.SetTimestampFormat(time.RFC3339)
+
+	output := string(formatter.Format(NewEntry(Warning, time.Now(), "colored", []*Tag{CreateTag("area", "disk")})))
+
+	suite.Assert().True(strings.Contains(output, ansiYellow+"WARNING"+ansiReset))
+	suite.Assert().True(strings.Contains(output, ansiCyan+"area"+ansiReset))
+	suite.Assert().True(strings.HasPrefix(output, ansiDim))
+}
+
+func (suite *ConsoleFormatterTestSuite) TestNoColorEnvironment() {
+	suite.T().Setenv("NO_COLOR", "1")
+
+	suite.Assert().False(shouldColorize(&bytes.Buffer{}))
+	suite.Assert().False(shouldColorize(os.Stdout))
+
+	formatter := NewConsoleFormatter(os.Stdout)
+	formatter.SetTimestampFormat(time.RFC3339)
+	output := string(formatter.Format(NewEntry(Error, time.Now(), "plain\ntext", []*Tag{CreateTag("area", "disk")})))
+	suite.Assert().NotContains(output, "\x1b")
+
+	formatter.SetColor(true)
+	formatter.DetectColor(os.Stdout)
+	output = string(formatter.Format(NewEntry(Warning, time.Now(), "plain", nil)))
+	suite.Assert().NotContains(output, "\x1b")
+}
+
+func TestConsoleFormatterTestSuite(t *testing.T) {
+	suite.Run(t, new(ConsoleFormatterTestSuite))
+}
@@ -0,0 +1,209 @@
+// Package pflog defines all of the pflog package
+package pflog
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var (
+	csvformatterTypeID = "csv"
+	tsvformatterTypeID = "tsv"
+)
+
+// Column names with special meaning to the CSV formatter, any other
+// column name is looked up in the entry tags
+const (
+	CSVColumnTimestamp = "timestamp"
+	CSVColumnLevel     = "level"
+	CSVColumnMessage   = "message"
+)
+
+// HeaderFormatter is implemented by formatters that need a header
+// written once at the start of every output file
+type HeaderFormatter interface {
+	Header() []byte
+}
+
+// CSVFormatter formats entries as RFC 4180 rows with a fixed
+// column schema.  Fields containing the delimiter, quotes or
+// newlines are quoted.  Tags not present in an entry produce
+// an empty field.
+type CSVFormatter struct {
+	timeFormat string
+	comma      rune
+	columns    []string
+	header     bool
+}
+
+// NewCSVFormatter returns a comma separated formatter with
+// the default columns
+func NewCSVFormatter() *CSVFormatter {
+	return &CSVFormatter{comma: ','}
+}
+
+// NewTSVFormatter returns a tab separated formatter with
+// the default columns
+func NewTSVFormatter() *CSVFormatter {
+	return &CSVFormatter{comma: '\t'}
+}
+
+// newCSVFormatter is the factory for csv formatters
+func newCSVFormatter(options map[string]interface{}) (LogFormatter, error) {
+	return NewCSVFormatter().applyOptions(options)
+}
+
+// newTSVFormatter is the factory for tsv formatters
+func newTSVFormatter(options map[string]interface{}) (LogFormatter, error) {
+	return NewTSVFormatter().applyOptions(options)
+}
+
+// applyOptions applies the columns and header options
+func (cf *CSVFormatter) applyOptions(options map[string]interface{}) (LogFormatter, error) {
+	columns, err := optionStrings(options, "columns")
+	if err != nil {
+		return nil, err
+	}
+	header, err := optionBool(options, "header")
+	if err != nil {
+		return nil, err
+	}
+	cf.SetColumns(columns)
+	cf.SetHeader(header)
+	return cf, nil
+}
+
+// ID returns the specified ID of this formatter
+func (cf *CSVFormatter) ID() string {
+	if cf.comma == '\t' {
+		return tsvformatterTypeID
+	}
+	return csvformatterTypeID
+}
+
+// SetTimestampFormat sets the time stamp format
+// to the provided string representation for outputing
+// time/date information
+func (cf *CSVFormatter) SetTimestampFormat(format string) {
+	cf.timeFormat = format
+}
+
+// SetColumns sets the columns to output in order, an empty
+// list restores the default of timestamp, level and message
+func (cf *CSVFormatter) SetColumns(columns []string) {
+	cf.columns = append([]string(nil), columns...)
+}
+
+// SetHeader indicates that a header row should be written
+// at the start of every output file
+func (cf *CSVFormatter) SetHeader(header bool) {
+	cf.header = header
+}
+
+// Header returns the header row or nil if disabled
+func (cf *CSVFormatter) Header() []byte {
+	if !cf.header {
+		return nil
+	}
+	return cf.writeRecord(cf.getColumns())
+}
+
+// Format formats a log entry into a single row
+func (cf *CSVFormatter) Format(entry *Entry) []byte {
+	columns := cf.getColumns()
+	record := make([]string, len(columns))
+	for index, column := range columns {
+		switch column {
+		case CSVColumnTimestamp:
+			record[index] = entry.timestamp.Local().Format(cf.timeFormat)
+		case CSVColumnLevel:
+			levelString, err := convertLevelToString(entry.level, true)
+			if err != nil {
+				levelString = err.Error()
+			}
+			record[index] = levelString
+		case CSVColumnMessage:
+			record[index] = entry.message
+		default:
+			for _, v := range entry.tags {
+				if v.name == column {
+					record[index] = fmt.Sprintf("%v", v.value)
+				}
+			}
+		}
+	}
+	return cf.writeRecord(record)
+}
+
+func (cf *CSVFormatter) getColumns() []string {
+	if len(cf.columns) == 0 {
+		return []string{CSVColumnTimestamp, CSVColumnLevel, CSVColumnMessage}
+	}
+	return cf.columns
+}
+
+func (cf *CSVFormatter) writeRecord(record []string) []byte {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	if cf.comma != 0 {
+		writer.Comma = cf.comma
+	}
+	err := writer.Write(record)
+	if err == nil {
+		writer.Flush()
+		err = writer.Error()
+	}
+	if err != nil {
+		return []byte(err.Error() + "\n")
+	}
+	return buffer.Bytes()
+}
+
+// writeHeader writes the formatter header to a new output target.
+// Rotating writers, also when wrapped such as by a ChainWriter, repeat
+// the header in every new file, file writers whenever the file is empty
+// such as after logrotate and regular files only receive it when empty.
+func writeHeader(writer io.Writer, formatter LogFormatter) error {
+	headerFormatter, ok := formatter.(HeaderFormatter)
+	if !ok {
+		return nil
+	}
+	header := headerFormatter.Header()
+	if len(header) == 0 {
+		return nil
+	}
+
+	switch target := writer.(type) {
+	case *ChainWriter:
+		return target.SetHeader(header)
+	case interface{ Unwrap() []io.Writer }:
+		var errs []error
+		for _, wrapped := range target.Unwrap() {
+			errs = append(errs, writeHeader(wrapped, formatter))
+		}
+		return errors.Join(errs...)
+	case interface{ Unwrap() io.Writer }:
+		if rotating := rotatingWritersOf(writer); len(rotating) > 0 {
+			var errs []error
+			for _, rw := range rotating {
+				errs = append(errs, rw.SetHeader(header))
+			}
+			return errors.Join(errs...)
+		}
+	case *RotatingWriter:
+		return target.SetHeader(header)
+	case *FileWriter:
+		return target.SetHeader(header)
+	case *os.File:
+		info, err := target.Stat()
+		if err == nil && info.Mode().IsRegular() && info.Size() > 0 {
+			return nil
+		}
+	}
+	_, err := writer.Write(header)
+	return err
+}
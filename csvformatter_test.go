@@ -0,0 +1,98 @@
+package pflog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CSVFormatterTestSuite struct {
+	suite.Suite
+}
+
+func (suite *CSVFormatterTestSuite) TestQuoting() {
+	formatter := NewCSVFormatter()
+	formatter.SetColumns([]string{CSVColumnLevel, "user", CSVColumnMessage, "missing"})
+
+	output := string(formatter.Format(NewEntry(Error, time.Now(), "a, \"b\"\nc", []*Tag{CreateTag("user", "bob")})))
+
+	suite.Assert().Equal("ERROR,bob,\"a, \"\"b\"\"\nc\",\n", output)
+}
+
+func (suite *CSVFormatterTestSuite) TestTSV() {
+	formatter := NewTSVFormatter()
+	formatter.SetColumns([]string{CSVColumnLevel, CSVColumnMessage})
+	formatter.SetHeader(true)
+
+	suite.Assert().Equal("tsv", formatter.ID())
+	suite.Assert().Equal("level\tmessage\n", string(formatter.Header()))
+	suite.Assert().Equal("TRACE\thello\n", string(formatter.Format(NewEntry(Trace, time.Now(), "hello", nil))))
+}
+
+func (suite *CSVFormatterTestSuite) TestHeaderAfterRotation() {
+	filename := filepath.Join(suite.T().TempDir(), "app.csv")
+	writer, err := newRotatingWriter(filename, 64, 0, false)
+	suite.Require().Nil(err)
+
+	formatter := NewCSVFormatter()
+	formatter.SetColumns([]string{CSVColumnMessage})
+	formatter.SetHeader(true)
+
+	log := New()
+	suite.Require().Nil(log.SetLevel(Trace))
+	log.SetCompactDuplicates(false)
+	_ = log.AddOutputTargetAndFormatter(writer, formatter)
+	for count := 0; count < 10; count++ {
+		log.Logf(Information, "entry number %d", count)
+	}
+
+	backups := writer.findBackups(false)
+	suite.Require().NotEmpty(backups)
+	for _, path := range append(backups, filename) {
+		contents, readErr := os.ReadFile(path)
+		suite.Require().Nil(readErr)
+		suite.Assert().True(strings.HasPrefix(string(contents), "message\n"), path)
+		suite.Assert().Equal(1, strings.Count(string(contents), "message\n"), path)
+	}
+}
+
+func (suite *CSVFormatterTestSuite) TestHeaderAfterChainedRotation() {
+	filename := filepath.Join(suite.T().TempDir(), "app.csv")
+	rotating, err := newRotatingWriter(filename, 200, 0, false)
+	suite.Require().Nil(err)
+	chain, err := newFileChainWriter(rotating, filename, nil, nil)
+	suite.Require().Nil(err)
+
+	formatter := NewCSVFormatter()
+	formatter.SetColumns([]string{CSVColumnMessage})
+	formatter.SetHeader(true)
+
+	log := New()
+	suite.Require().Nil(log.SetLevel(Trace))
+	log.SetCompactDuplicates(false)
+	_ = log.AddOutputTargetAndFormatter(chain, formatter)
+	for count := 0; count < 10; count++ {
+		log.Logf(Information, "entry number %d", count)
+	}
+	suite.Require().Nil(rotating.Close())
+
+	backups := backupFiles(filename)
+	suite.Require().NotEmpty(backups)
+	for _, path := range append(backups, filename) {
+		contents, readErr := os.ReadFile(path)
+		suite.Require().Nil(readErr)
+		suite.Assert().True(strings.HasPrefix(string(contents), "#chain-header 8 "), path)
+		suite.Assert().Contains(string(contents), "\nmessage\n#chain ", path)
+	}
+	verification, err := VerifyChainFiles(filename, nil)
+	suite.Require().Nil(err)
+	suite.Assert().Equal(uint64(10), verification.Records)
+}
+
+func TestCSVFormatterTestSuite(t *testing.T) {
+	suite.Run(t, new(CSVFormatterTestSuite))
+}
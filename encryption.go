@@ -0,0 +1,307 @@
+// Package pflog defines all of the pflog package
+package pflog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Encrypted files start with encryptionMagic followed by frames of:
+//
+//	4 byte big endian length of nonce and ciphertext
+//	12 byte random nonce
+//	AES-256-GCM ciphertext and tag
+//
+// The big endian frame number within the file is the additional
+// authenticated data so frames cannot be reordered undetected.
+const (
+	encryptionMagic     = "PFLOGENC"
+	encryptedSuffix     = ".enc"
+	encryptionKeySize   = 32
+	encryptionChunkSize = 64 * 1024
+	encryptionMaxFrame  = 16 * 1024 * 1024
+)
+
+// ErrEncryptionKeyRequired is returned when reading an encrypted file without a key
+var ErrEncryptionKeyRequired = errors.New("file is encrypted and no key was given")
+
+// errTornFrame is returned by countFrames for a file ending within a frame
+var errTornFrame = errors.New("torn frame")
+
+// ParseEncryptionKey accepts a 32 byte AES-256 key given raw, as 64
+// hex digits or as base64, surrounding white space is ignored
+func ParseEncryptionKey(data []byte) ([]byte, error) {
+	if len(data) == encryptionKeySize {
+		return data, nil
+	}
+	text := bytes.TrimSpace(data)
+	if key, err := hex.DecodeString(string(text)); err == nil && len(key) == encryptionKeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(string(text)); err == nil && len(key) == encryptionKeySize {
+		return key, nil
+	}
+	if len(text) == encryptionKeySize {
+		return text, nil
+	}
+	return nil, fmt.Errorf("encryption key must be %d bytes, raw, hex or base64", encryptionKeySize)
+}
+
+// frameEncrypter seals data into frames for one file
+type frameEncrypter struct {
+	aead  cipher.AEAD
+	frame uint64
+}
+
+func newFrameEncrypter(key []byte) (*frameEncrypter, error) {
+	aead, err := newEncryptionAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &frameEncrypter{aead: aead}, nil
+}
+
+func newEncryptionAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns p as a single frame and advances the frame number
+func (fe *frameEncrypter) seal(p []byte) ([]byte, error) {
+	nonceSize := fe.aead.NonceSize()
+	frame := make([]byte, 4+nonceSize, 4+nonceSize+len(p)+fe.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, frame[4:]); err != nil {
+		return nil, err
+	}
+	frame = fe.aead.Seal(frame, frame[4:4+nonceSize], p, frameNumber(fe.frame))
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+	fe.frame++
+	return frame, nil
+}
+
+func frameNumber(frame uint64) []byte {
+	var number [8]byte
+	binary.BigEndian.PutUint64(number[:], frame)
+	return number[:]
+}
+
+// countFrames returns the number of frames in an encrypted file after
+// its magic and the offset of their end, used to continue appending to
+// it.  A file ending within a frame, as left by a crash, returns
+// errTornFrame with the whole frames before it.
+func countFrames(reader io.Reader) (uint64, int64, error) {
+	buffered := bufio.NewReader(reader)
+	magic := make([]byte, len(encryptionMagic))
+	if _, err := io.ReadFull(buffered, magic); err != nil || string(magic) != encryptionMagic {
+		return 0, 0, fmt.Errorf("not an encrypted log file")
+	}
+	var frames uint64
+	end := int64(len(encryptionMagic))
+	var length [4]byte
+	for {
+		_, err := io.ReadFull(buffered, length[:])
+		if err == io.EOF {
+			return frames, end, nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return frames, end, fmt.Errorf("truncated frame %d: %w", frames, errTornFrame)
+		}
+		if err != nil {
+			return frames, end, err
+		}
+		size := int(binary.BigEndian.Uint32(length[:]))
+		skipped, err := buffered.Discard(size)
+		if err == io.EOF {
+			return frames, end, fmt.Errorf("truncated frame %d after %d bytes: %w", frames, skipped, errTornFrame)
+		}
+		if err != nil {
+			return frames, end, err
+		}
+		end += int64(len(length) + size)
+		frames++
+	}
+}
+
+// encryptFile encrypts src to src+".enc" and removes src on success
+func encryptFile(src string, key []byte) error {
+	in, err := os.Open(filepath.Clean(src))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	encrypter, err := newFrameEncrypter(key)
+	if err != nil {
+		return err
+	}
+
+	encPath := src + encryptedSuffix
+	out, err := os.OpenFile(encPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	writeErr := func() error {
+		writer := bufio.NewWriter(out)
+		if _, err := writer.WriteString(encryptionMagic); err != nil {
+			return err
+		}
+		chunk := make([]byte, encryptionChunkSize)
+		for {
+			n, readErr := io.ReadFull(in, chunk)
+			if n > 0 {
+				frame, err := encrypter.seal(chunk[:n])
+				if err != nil {
+					return err
+				}
+				if _, err := writer.Write(frame); err != nil {
+					return err
+				}
+			}
+			if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+				return writer.Flush()
+			}
+			if readErr != nil {
+				return readErr
+			}
+		}
+	}()
+	closeErr := out.Close()
+
+	if writeErr != nil {
+		os.Remove(encPath) //nolint:errcheck
+		return writeErr
+	}
+	if closeErr != nil {
+		os.Remove(encPath) //nolint:errcheck
+		return closeErr
+	}
+
+	keepModTime(in, encPath)
+	return os.Remove(src)
+}
+
+// decryptingReader reads the plain text of an encrypted stream
+type decryptingReader struct {
+	reader  *bufio.Reader
+	aead    cipher.AEAD
+	frame   uint64
+	pending []byte
+	err     error
+}
+
+func (dr *decryptingReader) Read(p []byte) (int, error) {
+	for len(dr.pending) == 0 {
+		if dr.err != nil {
+			return 0, dr.err
+		}
+		dr.err = dr.next()
+	}
+	n := copy(p, dr.pending)
+	dr.pending = dr.pending[n:]
+	return n, nil
+}
+
+// next decrypts the next frame into pending
+func (dr *decryptingReader) next() error {
+	var length [4]byte
+	_, err := io.ReadFull(dr.reader, length[:])
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("truncated frame %d", dr.frame)
+		}
+		return err
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	nonceSize := uint32(dr.aead.NonceSize())
+	if size < nonceSize+uint32(dr.aead.Overhead()) || size > encryptionMaxFrame {
+		return fmt.Errorf("bad frame %d length: %d", dr.frame, size)
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(dr.reader, frame); err != nil {
+		return fmt.Errorf("truncated frame %d", dr.frame)
+	}
+	dr.pending, err = dr.aead.Open(frame[nonceSize:nonceSize], frame[:nonceSize], frame[nonceSize:], frameNumber(dr.frame))
+	if err != nil {
+		return fmt.Errorf("unable to decrypt frame %d: %w", dr.frame, err)
+	}
+	dr.frame++
+	return nil
+}
+
+// logFileReader closes the file and any layers read through
+type logFileReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (lr *logFileReader) Close() error {
+	var err error
+	for index := len(lr.closers) - 1; index >= 0; index-- {
+		if closeErr := lr.closers[index].Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// OpenLogFile opens a log file or backup for reading, transparently
+// decrypting encrypted files (live or backup) with key and
+// decompressing files of any registered codec, the format is detected
+// from the contents
+func OpenLogFile(filename string, key []byte) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Clean(filename))
+	if err != nil {
+		return nil, err
+	}
+	result := &logFileReader{closers: []io.Closer{file}}
+	buffered := bufio.NewReader(file)
+
+	magic, _ := buffered.Peek(len(encryptionMagic))
+	if string(magic) == encryptionMagic {
+		if len(key) == 0 {
+			result.Close() //nolint:errcheck
+			return nil, fmt.Errorf("%s: %w", filename, ErrEncryptionKeyRequired)
+		}
+		aead, aeadErr := newEncryptionAEAD(key)
+		if aeadErr != nil {
+			result.Close() //nolint:errcheck
+			return nil, aeadErr
+		}
+		_, _ = buffered.Discard(len(encryptionMagic))
+		buffered = bufio.NewReader(&decryptingReader{reader: buffered, aead: aead})
+	}
+
+	magic, err = buffered.Peek(maxCompressionMagic)
+	if err != nil && err != io.EOF {
+		result.Close() //nolint:errcheck
+		return nil, err
+	}
+	if codec := codecForData(magic); codec != nil {
+		decompressor, codecErr := codec.NewReader(buffered)
+		if codecErr != nil {
+			result.Close() //nolint:errcheck
+			return nil, codecErr
+		}
+		result.closers = append(result.closers, decompressor)
+		result.Reader = decompressor
+		return result, nil
+	}
+
+	result.Reader = buffered
+	return result, nil
+}
@@ -0,0 +1,184 @@
+package pflog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type EncryptionTestSuite struct {
+	suite.Suite
+}
+
+var testEncryptionKey = []byte("0123456789abcdef0123456789abcdef")
+
+func (suite *EncryptionTestSuite) readLogFile(filename string, key []byte) string {
+	reader, err := OpenLogFile(filename, key)
+	suite.Require().Nil(err)
+	defer reader.Close()
+	contents, err := io.ReadAll(reader)
+	suite.Require().Nil(err)
+	return string(contents)
+}
+
+func (suite *EncryptionTestSuite) TestParseKey() {
+	key, err := ParseEncryptionKey([]byte(hex.EncodeToString(testEncryptionKey) + "\n"))
+	suite.Require().Nil(err)
+	suite.Assert().Equal(testEncryptionKey, key)
+
+	key, err = ParseEncryptionKey([]byte(base64.StdEncoding.EncodeToString(testEncryptionKey)))
+	suite.Require().Nil(err)
+	suite.Assert().Equal(testEncryptionKey, key)
+
+	key, err = ParseEncryptionKey(testEncryptionKey)
+	suite.Require().Nil(err)
+	suite.Assert().Equal(testEncryptionKey, key)
+
+	_, err = ParseEncryptionKey([]byte("too short"))
+	suite.Assert().NotNil(err)
+}
+
+func (suite *EncryptionTestSuite) TestCompressedEncryptedBackup() {
+	filename := filepath.Join(suite.T().TempDir(), "app.log.20240101-000000")
+	contents := strings.Repeat("a line of log output\n", 10000)
+	suite.Require().Nil(os.WriteFile(filename, []byte(contents), 0600))
+	suite.Require().Nil(compressFile(filename, gzipCodec{}, gzip.BestCompression))
+	suite.Require().Nil(encryptFile(filename+".gz", testEncryptionKey))
+
+	encrypted := filename + ".gz" + encryptedSuffix
+	suite.Assert().NoFileExists(filename + ".gz")
+	suite.Assert().Equal(contents, suite.readLogFile(encrypted, testEncryptionKey))
+
+	_, err := OpenLogFile(encrypted, nil)
+	suite.Assert().True(errors.Is(err, ErrEncryptionKeyRequired))
+
+	reader, err := OpenLogFile(encrypted, []byte("fedcba9876543210fedcba9876543210"))
+	if err == nil {
+		_, err = io.ReadAll(reader)
+		reader.Close()
+	}
+	suite.Assert().NotNil(err)
+}
+
+func (suite *EncryptionTestSuite) TestPlainFile() {
+	filename := filepath.Join(suite.T().TempDir(), "app.log")
+	suite.Require().Nil(os.WriteFile(filename, []byte("plain\n"), 0600))
+	suite.Assert().Equal("plain\n", suite.readLogFile(filename, testEncryptionKey))
+}
+
+func (suite *EncryptionTestSuite) TestEncryptedBackups() {
+	filename := filepath.Join(suite.T().TempDir(), "app.log")
+	writer, err := newRotatingWriter(filename, 100, 0, true)
+	suite.Require().Nil(err)
+	suite.Require().Nil(writer.SetEncryption(testEncryptionKey, false))
+
+	for index := 0; index < 8; index++ {
+		_, err = writer.Write([]byte(fmt.Sprintf("secret line %d\n", index)))
+		suite.Require().Nil(err)
+	}
+
+	writer.waitForBackups()
+	suite.Assert().Empty(writer.findBackups(false))
+	suite.Assert().Empty(writer.findBackups(true))
+	backups := writer.findEncryptedBackups()
+	suite.Require().Len(backups, 1)
+	suite.Assert().True(strings.HasSuffix(backups[0], ".gz"+encryptedSuffix))
+	suite.Assert().True(strings.HasPrefix(suite.readLogFile(backups[0], testEncryptionKey), "secret line 0\n"))
+}
+
+func (suite *EncryptionTestSuite) TestLiveEncryption() {
+	filename := filepath.Join(suite.T().TempDir(), "app.log")
+	suite.Require().Nil(os.WriteFile(filename, []byte("written before encryption\n"), 0600))
+
+	writer, err := newRotatingWriter(filename, 0, 0, false)
+	suite.Require().Nil(err)
+	suite.Require().Nil(writer.SetEncryption(testEncryptionKey, true))
+	suite.Require().Nil(writer.SetHeader([]byte("header\n")))
+	_, err = writer.Write([]byte("first\n"))
+	suite.Require().Nil(err)
+
+	// the plain contents were rotated away and encrypted
+	writer.waitForBackups()
+	backups := writer.findEncryptedBackups()
+	suite.Require().Len(backups, 1)
+	suite.Assert().Equal("written before encryption\n", suite.readLogFile(backups[0], testEncryptionKey))
+
+	// a restart appends frames to the same file
+	writer, err = newRotatingWriter(filename, 0, 0, false)
+	suite.Require().Nil(err)
+	suite.Require().Nil(writer.SetEncryption(testEncryptionKey, true))
+	suite.Require().Nil(writer.SetHeader([]byte("header\n")))
+	_, err = writer.Write([]byte("second\n"))
+	suite.Require().Nil(err)
+
+	raw, err := os.ReadFile(filename)
+	suite.Require().Nil(err)
+	suite.Assert().False(bytes.Contains(raw, []byte("first")))
+	suite.Assert().Equal("header\nfirst\nsecond\n", suite.readLogFile(filename, testEncryptionKey))
+}
+
+func (suite *EncryptionTestSuite) TestTornFrameResumed() {
+	filename := filepath.Join(suite.T().TempDir(), "app.log")
+	writer, err := newRotatingWriter(filename, 0, 0, false)
+	suite.Require().Nil(err)
+	suite.Require().Nil(writer.SetEncryption(testEncryptionKey, true))
+	_, err = writer.Write([]byte("first\n"))
+	suite.Require().Nil(err)
+	suite.Require().Nil(writer.Close())
+
+	// a crash tore the next frame
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0600)
+	suite.Require().Nil(err)
+	_, err = f.Write([]byte{0, 0, 0, 100, 1, 2, 3})
+	suite.Require().Nil(err)
+	suite.Require().Nil(f.Close())
+
+	var reported []error
+	SetErrorHandler(func(err error) { reported = append(reported, err) })
+	defer SetErrorHandler(nil)
+	writer, err = newRotatingWriter(filename, 0, 0, false)
+	suite.Require().Nil(err)
+	suite.Require().Nil(writer.SetEncryption(testEncryptionKey, true))
+	_, err = writer.Write([]byte("second\n"))
+	suite.Require().Nil(err)
+	suite.Require().Nil(writer.Close())
+
+	suite.Require().Len(reported, 1)
+	suite.Assert().True(errors.Is(reported[0], errTornFrame))
+	suite.Assert().Equal("first\nsecond\n", suite.readLogFile(filename, testEncryptionKey))
+}
+
+func (suite *EncryptionTestSuite) TestEncryptedChain() {
+	filename := filepath.Join(suite.T().TempDir(), "audit.log")
+	rotating, err := newRotatingWriter(filename, 0, 0, false)
+	suite.Require().Nil(err)
+	suite.Require().Nil(rotating.SetEncryption(testEncryptionKey, true))
+	writer, err := newFileChainWriter(rotating, filename, testChainKey, testEncryptionKey)
+	suite.Require().Nil(err)
+	for index := 0; index < 3; index++ {
+		_, err = writer.Write([]byte(fmt.Sprintf("record %d\n", index)))
+		suite.Require().Nil(err)
+	}
+
+	writer, err = newFileChainWriter(rotating, filename, testChainKey, testEncryptionKey)
+	suite.Require().Nil(err)
+	suite.Assert().Equal(uint64(3), writer.State().Sequence)
+
+	result, err := VerifyEncryptedChainFiles(filename, testChainKey, testEncryptionKey)
+	suite.Require().Nil(err)
+	suite.Assert().Equal(uint64(3), result.Records)
+}
+
+func TestEncryptionTestSuite(t *testing.T) {
+	suite.Run(t, new(EncryptionTestSuite))
+}
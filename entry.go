@@ -18,3 +18,23 @@ func NewEntry(level LogLevel, timestamp time.Time, message string, tagset []*Tag
 		tags:      tagset,
 	}
 }
+
+// Level returns the level the entry was logged at
+func (e *Entry) Level() LogLevel {
+	return e.level
+}
+
+// Timestamp returns the time the entry was logged
+func (e *Entry) Timestamp() time.Time {
+	return e.timestamp
+}
+
+// Message returns the message of the entry
+func (e *Entry) Message() string {
+	return e.message
+}
+
+// Tags returns the tags attached to the entry
+func (e *Entry) Tags() []*Tag {
+	return e.tags
+}
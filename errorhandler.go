@@ -0,0 +1,60 @@
+// Package pflog defines all of the pflog package
+package pflog
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// ErrorHandler receives errors pflog cannot return to a caller, such as a
+// failed write to an output target, an error on a background worker or in a
+// rotation callback.  It may be called with the log's lock held so it must
+// not log synchronously to the same Log.
+type ErrorHandler func(err error)
+
+// TargetError is an error of the output target at Index
+type TargetError struct {
+	Index int
+	Err   error
+}
+
+func (e *TargetError) Error() string {
+	return fmt.Sprintf("output %d: %v", e.Index, e.Err)
+}
+
+func (e *TargetError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	errorHandler     ErrorHandler = printError
+	errorHandlerLock sync.RWMutex
+)
+
+// SetErrorHandler sets the handler of internal errors, nil restores the
+// default of printing them to standard error
+func SetErrorHandler(handler ErrorHandler) {
+	errorHandlerLock.Lock()
+	defer errorHandlerLock.Unlock()
+
+	if handler == nil {
+		handler = printError
+	}
+	errorHandler = handler
+}
+
+// printError is the default ErrorHandler
+func printError(err error) {
+	fmt.Fprintf(os.Stderr, "pflog: %v\n", err)
+}
+
+// reportError passes an error that cannot be returned to a caller to the
+// error handler
+func reportError(err error) {
+	errorHandlerLock.RLock()
+	handler := errorHandler
+	errorHandlerLock.RUnlock()
+
+	handler(err)
+}
@@ -0,0 +1,218 @@
+// Package pflog defines all of the pflog package
+package pflog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultFailoverRetryInterval is how long a FailoverWriter waits before
+// trying a failed primary again
+const DefaultFailoverRetryInterval = 5 * time.Second
+
+// spoolFrameHeader is the size of the length preceding each spooled record
+const spoolFrameHeader = 4
+
+// FailoverWriter writes to a primary target, such as one on the network,
+// and falls back to spooling records to a local file while the primary
+// fails.  The primary is tried again every retry interval; once it accepts
+// writes the spooled records are replayed to it in order before any new
+// ones.  A spool left by an earlier run is replayed too, so records are
+// delivered at least once.
+type FailoverWriter struct {
+	primary       io.Writer
+	filename      string
+	spool         *os.File
+	offset        int64 // start of the first spooled record not yet replayed
+	size          int64 // end of the spooled records
+	retryInterval time.Duration
+	retry         time.Time // when the primary is tried again
+	clock         func() time.Time
+	mu            sync.Mutex
+}
+
+// NewFailoverWriter returns a writer to primary spooling to the file spool
+// while primary fails.  A record of the spool cut short by a crash is
+// dropped so later records are framed correctly.
+func NewFailoverWriter(primary io.Writer, spool string) (*FailoverWriter, error) {
+	f, err := os.OpenFile(filepath.Clean(spool), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	size, err := spoolEnd(f)
+	if err != nil {
+		f.Close() //nolint:errcheck
+		return nil, err
+	}
+	return &FailoverWriter{
+		primary:       primary,
+		filename:      spool,
+		spool:         f,
+		size:          size,
+		retryInterval: DefaultFailoverRetryInterval,
+		clock:         time.Now,
+	}, nil
+}
+
+// spoolEnd returns the end of the last complete record in the spool,
+// truncating a torn record after it
+func spoolEnd(f *os.File) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	header := make([]byte, spoolFrameHeader)
+	var end int64
+	for end < info.Size() {
+		if _, err := f.ReadAt(header, end); err != nil {
+			break
+		}
+		next := end + spoolFrameHeader + int64(binary.BigEndian.Uint32(header))
+		if next > info.Size() {
+			break
+		}
+		end = next
+	}
+	if end < info.Size() {
+		reportError(fmt.Errorf("failover: %s: truncated record dropped", f.Name()))
+		if err := f.Truncate(end); err != nil {
+			return 0, err
+		}
+	}
+	return end, nil
+}
+
+// SetRetryInterval sets how long to wait before trying a failed primary again
+func (fw *FailoverWriter) SetRetryInterval(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("failover retry interval must be positive: %v", interval)
+	}
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	fw.retryInterval = interval
+	return nil
+}
+
+// Spooled returns the number of bytes of records waiting to be replayed
+func (fw *FailoverWriter) Spooled() int64 {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	return fw.size - fw.offset
+}
+
+// Write implements io.Writer, a record is only lost if both the primary
+// and the spool fail
+func (fw *FailoverWriter) Write(p []byte) (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	now := fw.clock()
+	if fw.offset < fw.size && (now.Before(fw.retry) || !fw.replay(now)) {
+		return fw.spoolRecord(p)
+	}
+	if _, err := fw.primary.Write(p); err != nil {
+		reportError(fmt.Errorf("failover: spooling to %s: %w", fw.filename, err))
+		fw.retry = now.Add(fw.retryInterval)
+		return fw.spoolRecord(p)
+	}
+	return len(p), nil
+}
+
+// replay writes the spooled records to the primary, false if it failed
+func (fw *FailoverWriter) replay(now time.Time) bool {
+	header := make([]byte, spoolFrameHeader)
+	for fw.offset < fw.size {
+		length := int64(-1)
+		if _, err := fw.spool.ReadAt(header, fw.offset); err == nil {
+			length = int64(binary.BigEndian.Uint32(header))
+		}
+		if length < 0 || fw.offset+spoolFrameHeader+length > fw.size {
+			// a record cut short by a crash is dropped
+			reportError(fmt.Errorf("failover: %s: truncated record dropped", fw.filename))
+			break
+		}
+		record := make([]byte, length)
+		if _, err := fw.spool.ReadAt(record, fw.offset+spoolFrameHeader); err != nil {
+			reportError(fmt.Errorf("failover: %s: %w", fw.filename, err))
+			fw.retry = now.Add(fw.retryInterval)
+			return false
+		}
+		if _, err := fw.primary.Write(record); err != nil {
+			fw.retry = now.Add(fw.retryInterval)
+			return false
+		}
+		fw.offset += spoolFrameHeader + length
+	}
+	if err := fw.spool.Truncate(0); err != nil {
+		reportError(fmt.Errorf("failover: %s: %w", fw.filename, err))
+	}
+	fw.offset = 0
+	fw.size = 0
+	return true
+}
+
+// spoolRecord appends p to the spool as one record
+func (fw *FailoverWriter) spoolRecord(p []byte) (int, error) {
+	frame := make([]byte, spoolFrameHeader+len(p))
+	binary.BigEndian.PutUint32(frame, uint32(len(p)))
+	copy(frame[spoolFrameHeader:], p)
+	if _, err := fw.spool.WriteAt(frame, fw.size); err != nil {
+		return 0, fmt.Errorf("failover: %s: %w", fw.filename, err)
+	}
+	fw.size += int64(len(frame))
+	return len(p), nil
+}
+
+// Unwrap returns the primary writer
+func (fw *FailoverWriter) Unwrap() io.Writer {
+	return fw.primary
+}
+
+// writesRecords returns true if the primary treats every Write as one record
+func (fw *FailoverWriter) writesRecords() bool {
+	return writesRecords(fw.primary)
+}
+
+// Reopen reopens the primary if it supports reopening
+func (fw *FailoverWriter) Reopen() error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	if reopener, ok := fw.primary.(Reopener); ok {
+		return reopener.Reopen()
+	}
+	return nil
+}
+
+// Sync syncs the spool and the primary if it supports syncing
+func (fw *FailoverWriter) Sync() error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	err := fw.spool.Sync()
+	if syncer, ok := fw.primary.(Syncer); ok {
+		err = errors.Join(err, syncer.Sync())
+	}
+	return err
+}
+
+// Close closes the spool and the primary if it supports closing, spooled
+// records are kept for the next run
+func (fw *FailoverWriter) Close() error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	err := fw.spool.Close()
+	if closer, ok := fw.primary.(io.Closer); ok {
+		err = errors.Join(err, closer.Close())
+	}
+	return err
+}
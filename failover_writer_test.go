@@ -0,0 +1,156 @@
+package pflog
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/yaml.v3"
+)
+
+type FailoverWriterTestSuite struct {
+	suite.Suite
+	now time.Time
+}
+
+func (suite *FailoverWriterTestSuite) SetupTest() {
+	suite.now = time.Date(2024, 3, 6, 10, 0, 0, 0, time.UTC)
+	SetErrorHandler(func(error) {})
+}
+
+func (suite *FailoverWriterTestSuite) TearDownTest() {
+	SetErrorHandler(nil)
+}
+
+func (suite *FailoverWriterTestSuite) newWriter(primary *failingWriter, spool string) *FailoverWriter {
+	writer, err := NewFailoverWriter(primary, spool)
+	suite.Require().Nil(err)
+	writer.clock = func() time.Time { return suite.now }
+	suite.Require().Nil(writer.SetRetryInterval(time.Second))
+	return writer
+}
+
+func (suite *FailoverWriterTestSuite) write(writer *FailoverWriter, records ...string) {
+	for _, record := range records {
+		n, err := writer.Write([]byte(record))
+		suite.Require().Nil(err)
+		suite.Require().Equal(len(record), n)
+	}
+}
+
+func (suite *FailoverWriterTestSuite) TestSpoolAndReplay() {
+	spool := filepath.Join(suite.T().TempDir(), "app.spool")
+	primary := &failingWriter{failing: true}
+	writer := suite.newWriter(primary, spool)
+	suite.write(writer, "one\n", "two\n")
+	suite.Assert().Equal(1, primary.attempts)
+	suite.Assert().Equal(int64(2*spoolFrameHeader+8), writer.Spooled())
+
+	// still failing at the retry
+	suite.now = suite.now.Add(time.Second)
+	suite.write(writer, "three\n")
+	suite.Assert().Equal(2, primary.attempts)
+
+	suite.now = suite.now.Add(time.Second)
+	primary.failing = false
+	suite.write(writer, "four\n")
+	suite.Assert().Equal([]string{"one\n", "two\n", "three\n", "four\n"}, primary.written)
+	suite.Assert().Equal(int64(0), writer.Spooled())
+	suite.Require().Nil(writer.Close())
+
+	info, err := os.Stat(spool)
+	suite.Require().Nil(err)
+	suite.Assert().Equal(int64(0), info.Size())
+}
+
+func (suite *FailoverWriterTestSuite) TestReplayAfterRestart() {
+	spool := filepath.Join(suite.T().TempDir(), "app.spool")
+	writer := suite.newWriter(&failingWriter{failing: true}, spool)
+	suite.write(writer, "before restart\n")
+	suite.Require().Nil(writer.Close())
+
+	// a record cut short by a crash
+	f, err := os.OpenFile(spool, os.O_WRONLY|os.O_APPEND, 0600)
+	suite.Require().Nil(err)
+	_, err = f.Write([]byte{0, 0, 1})
+	suite.Require().Nil(err)
+	suite.Require().Nil(f.Close())
+
+	primary := &failingWriter{}
+	writer = suite.newWriter(primary, spool)
+	suite.write(writer, "after restart\n")
+	suite.Assert().Equal([]string{"before restart\n", "after restart\n"}, primary.written)
+	suite.Assert().Equal(int64(0), writer.Spooled())
+	suite.Assert().NotNil(writer.SetRetryInterval(0))
+}
+
+func (suite *FailoverWriterTestSuite) TestTornFrameTruncatedOnOpen() {
+	spool := filepath.Join(suite.T().TempDir(), "app.spool")
+	writer := suite.newWriter(&failingWriter{failing: true}, spool)
+	suite.write(writer, "complete\n")
+	suite.Require().Nil(writer.Close())
+
+	// a crash tore the next record after its length
+	f, err := os.OpenFile(spool, os.O_WRONLY|os.O_APPEND, 0600)
+	suite.Require().Nil(err)
+	_, err = f.Write([]byte{0, 0, 0, 20, 't', 'o', 'r', 'n'})
+	suite.Require().Nil(err)
+	suite.Require().Nil(f.Close())
+
+	primary := &failingWriter{failing: true}
+	writer = suite.newWriter(primary, spool)
+	suite.Assert().Equal(int64(spoolFrameHeader+9), writer.Spooled())
+	suite.write(writer, "spooled after restart\n")
+
+	suite.now = suite.now.Add(time.Second)
+	primary.failing = false
+	suite.write(writer, "delivered\n")
+	suite.Assert().Equal([]string{"complete\n", "spooled after restart\n", "delivered\n"}, primary.written)
+	suite.Assert().Equal(int64(0), writer.Spooled())
+	suite.Require().Nil(writer.Close())
+}
+
+func (suite *FailoverWriterTestSuite) TestConfiguration() {
+	directory := suite.T().TempDir()
+	var configuration Configuration
+	suite.Require().Nil(yaml.Unmarshal([]byte(`
+settings:
+  level: Information
+  trigger_level: Fatal
+formatters:
+  - id: text
+    failover:
+      primary:
+        filename: `+filepath.Join(directory, "primary.log")+`
+      spool: `+filepath.Join(directory, "app.spool")+`
+      retry_interval: 10s
+  - id: text
+    tee:
+      - filename: `+filepath.Join(directory, "one.log")+`
+      - filename: `+filepath.Join(directory, "two.log")+`
+        max_size_mb: 1
+`), &configuration))
+	suite.Require().Nil(configuration.LoadConfiguration())
+	log := configuration.GetLogger()
+	suite.Assert().IsType(&FailoverWriter{}, log.outputTargets[0])
+	suite.Assert().IsType(&TeeWriter{}, log.outputTargets[1])
+	suite.Assert().Len(rotatingWritersOf(log.outputTargets[1]), 1)
+
+	log.Error("everywhere")
+	suite.Require().Nil(log.Close(context.Background()))
+	for _, name := range []string{"primary.log", "one.log", "two.log"} {
+		contents, err := os.ReadFile(filepath.Join(directory, name))
+		suite.Require().Nil(err)
+		suite.Assert().Contains(string(contents), "everywhere", name)
+	}
+
+	configuration.Formatters[0].Failover.Spool = ""
+	suite.Assert().NotNil(configuration.LoadConfiguration())
+}
+
+func TestFailoverWriterTestSuite(t *testing.T) {
+	suite.Run(t, new(FailoverWriterTestSuite))
+}
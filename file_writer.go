@@ -0,0 +1,137 @@
+package pflog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// reopenCheckInterval is how often a file writer checks whether its file
+// was moved or deleted, bounding the stat calls made on the write path
+const reopenCheckInterval = time.Second
+
+// Reopener is implemented by writers that can close and reopen their file,
+// for instance after an external logrotate moved it away
+type Reopener interface {
+	Reopen() error
+}
+
+// FileWriter is an io.Writer appending to a named file.  It works with
+// external rotation such as logrotate in create mode: Reopen opens the file
+// by name again, and a Write notices within a second that the file was
+// renamed or deleted (its inode changed) and reopens it automatically.
+// A header set with SetHeader is written again to a reopened empty file.
+type FileWriter struct {
+	filename string
+	file     *os.File
+	header   []byte
+	checked  time.Time // when the file was last checked for being moved
+	clock    func() time.Time
+	mu       sync.Mutex
+}
+
+// NewFileWriter opens (or creates) filename in append mode
+func NewFileWriter(filename string) (*FileWriter, error) {
+	f, err := openAppend(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &FileWriter{filename: filename, file: f, clock: time.Now}, nil
+}
+
+// Write implements io.Writer, reopening the file first if it was moved
+func (fw *FileWriter) Write(p []byte) (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	if now := fw.clock(); now.Sub(fw.checked) >= reopenCheckInterval {
+		fw.checked = now
+		if fileMoved(fw.file, fw.filename) {
+			if err := fw.reopen(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return fw.file.Write(p)
+}
+
+// Reopen closes the file and opens filename again
+func (fw *FileWriter) Reopen() error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	return fw.reopen()
+}
+
+func (fw *FileWriter) reopen() error {
+	f, err := openAppend(fw.filename)
+	if err != nil {
+		return fmt.Errorf("reopen: %w", err)
+	}
+	fw.file.Close() //nolint:errcheck
+	fw.file = f
+	return fw.writeHeader()
+}
+
+// SetHeader sets a header that is written at the start of the file
+// whenever it is empty, now or after it was reopened
+func (fw *FileWriter) SetHeader(header []byte) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	fw.header = append([]byte(nil), header...)
+	return fw.writeHeader()
+}
+
+// writeHeader writes the header if the file is empty
+func (fw *FileWriter) writeHeader() error {
+	if len(fw.header) == 0 || !fw.empty() {
+		return nil
+	}
+	if _, err := fw.file.Write(fw.header); err != nil {
+		return fmt.Errorf("header: %w", err)
+	}
+	return nil
+}
+
+// Sync commits the file to stable storage
+func (fw *FileWriter) Sync() error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	return fw.file.Sync()
+}
+
+// empty returns true if nothing was written to the file yet
+func (fw *FileWriter) empty() bool {
+	info, err := fw.file.Stat()
+	return err != nil || info.Size() == 0
+}
+
+// Close closes the file
+func (fw *FileWriter) Close() error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	return fw.file.Close()
+}
+
+func openAppend(filename string) (*os.File, error) {
+	return os.OpenFile(filepath.Clean(filename), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// fileMoved returns true if filename no longer names the open file, because
+// it was renamed or deleted
+func fileMoved(file *os.File, filename string) bool {
+	current, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	named, err := os.Stat(filename)
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	return !os.SameFile(current, named)
+}
@@ -0,0 +1,160 @@
+package pflog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type FileWriterTestSuite struct {
+	suite.Suite
+	now time.Time
+}
+
+func (suite *FileWriterTestSuite) clock() time.Time {
+	return suite.now
+}
+
+func (suite *FileWriterTestSuite) SetupTest() {
+	suite.now = time.Date(2024, 3, 6, 10, 0, 0, 0, time.UTC)
+}
+
+func (suite *FileWriterTestSuite) write(writer interface{ Write([]byte) (int, error) }, text string) {
+	_, err := writer.Write([]byte(text))
+	suite.Require().Nil(err)
+}
+
+func (suite *FileWriterTestSuite) contents(filename string) string {
+	contents, err := os.ReadFile(filename)
+	suite.Require().Nil(err)
+	return string(contents)
+}
+
+func (suite *FileWriterTestSuite) TestReopen() {
+	filename := filepath.Join(suite.T().TempDir(), "app.log")
+	writer, err := NewFileWriter(filename)
+	suite.Require().Nil(err)
+	writer.clock = suite.clock
+	suite.write(writer, "before\n")
+
+	// logrotate in create mode: move the file and create a new one
+	suite.Require().Nil(os.Rename(filename, filename+".1"))
+	suite.Require().Nil(os.WriteFile(filename, nil, 0600))
+	suite.write(writer, "still old\n")
+	suite.Require().Nil(writer.Reopen())
+	suite.write(writer, "after\n")
+	suite.Require().Nil(writer.Close())
+
+	suite.Assert().Equal("before\nstill old\n", suite.contents(filename+".1"))
+	suite.Assert().Equal("after\n", suite.contents(filename))
+}
+
+func (suite *FileWriterTestSuite) TestAutomaticReopen() {
+	filename := filepath.Join(suite.T().TempDir(), "app.log")
+	writer, err := NewFileWriter(filename)
+	suite.Require().Nil(err)
+	writer.clock = suite.clock
+	suite.write(writer, "before\n")
+
+	suite.Require().Nil(os.Rename(filename, filename+".1"))
+	suite.write(writer, "within a second\n")
+	suite.now = suite.now.Add(reopenCheckInterval)
+	suite.write(writer, "renamed\n")
+
+	suite.Require().Nil(os.Remove(filename))
+	suite.now = suite.now.Add(reopenCheckInterval)
+	suite.write(writer, "deleted\n")
+	suite.Require().Nil(writer.Close())
+
+	suite.Assert().Equal("before\nwithin a second\n", suite.contents(filename+".1"))
+	suite.Assert().Equal("deleted\n", suite.contents(filename))
+}
+
+func (suite *FileWriterTestSuite) TestHeaderAfterReopen() {
+	filename := filepath.Join(suite.T().TempDir(), "app.csv")
+	writer, err := NewFileWriter(filename)
+	suite.Require().Nil(err)
+	writer.clock = suite.clock
+	formatter := NewCSVFormatter()
+	formatter.SetColumns([]string{CSVColumnMessage})
+	formatter.SetHeader(true)
+	suite.Require().Nil(writeHeader(writer, formatter))
+	suite.Require().Nil(writeHeader(writer, formatter))
+	suite.write(writer, "before\n")
+
+	// moved away as by logrotate
+	suite.Require().Nil(os.Rename(filename, filename+".1"))
+	suite.now = suite.now.Add(reopenCheckInterval)
+	suite.write(writer, "after\n")
+
+	// moved away and created empty as by logrotate create mode, then reopened
+	suite.Require().Nil(os.Rename(filename, filename+".2"))
+	suite.Require().Nil(os.WriteFile(filename, nil, 0600))
+	suite.Require().Nil(writer.Reopen())
+	suite.write(writer, "reopened\n")
+	suite.Require().Nil(writer.Close())
+
+	suite.Assert().Equal("message\nbefore\n", suite.contents(filename+".1"))
+	suite.Assert().Equal("message\nafter\n", suite.contents(filename+".2"))
+	suite.Assert().Equal("message\nreopened\n", suite.contents(filename))
+}
+
+func (suite *FileWriterTestSuite) TestRotatingWriterReopen() {
+	filename := filepath.Join(suite.T().TempDir(), "app.log")
+	writer, err := newRotatingWriter(filename, 1024, 0, false)
+	suite.Require().Nil(err)
+	writer.clock = suite.clock
+	suite.Require().Nil(writer.SetHeader([]byte("header\n")))
+	suite.write(writer, "before\n")
+
+	suite.Require().Nil(os.Rename(filename, filename+".1"))
+	suite.now = suite.now.Add(reopenCheckInterval)
+	suite.write(writer, "after\n")
+	suite.Assert().Equal(int64(len("header\nafter\n")), writer.size)
+
+	suite.Require().Nil(os.Rename(filename, filename+".2"))
+	suite.Require().Nil(writer.Reopen())
+	suite.write(writer, "reopened\n")
+	suite.Require().Nil(writer.Close())
+	suite.Assert().Equal(os.ErrClosed, writer.Reopen())
+
+	suite.Assert().Equal("header\nbefore\n", suite.contents(filename+".1"))
+	suite.Assert().Equal("header\nafter\n", suite.contents(filename+".2"))
+	suite.Assert().Equal("header\nreopened\n", suite.contents(filename))
+}
+
+func (suite *FileWriterTestSuite) TestReopenAll() {
+	directory := suite.T().TempDir()
+	plain := filepath.Join(directory, "plain.log")
+	audit := filepath.Join(directory, "audit.log")
+	plainWriter, err := NewFileWriter(plain)
+	suite.Require().Nil(err)
+	auditFile, err := NewFileWriter(audit)
+	suite.Require().Nil(err)
+	auditWriter, err := newFileChainWriter(auditFile, audit, nil, nil)
+	suite.Require().Nil(err)
+
+	log := New()
+	suite.Require().Nil(log.SetLevel(Trace))
+	log.AddOutputTargetAndFormatter(plainWriter, &TextFormatter{})
+	log.AddOutputTargetAndFormatter(auditWriter, &TextFormatter{})
+	log.Information("first")
+
+	suite.Require().Nil(os.Rename(plain, plain+".1"))
+	suite.Require().Nil(os.Rename(audit, audit+".1"))
+	suite.Require().Nil(log.ReopenAll())
+	log.Information("second")
+
+	suite.Assert().Contains(suite.contents(plain), "second")
+	suite.Assert().NotContains(suite.contents(plain), "first")
+	result, err := VerifyChain([]string{audit + ".1", audit}, nil)
+	suite.Require().Nil(err)
+	suite.Assert().Equal(uint64(2), result.Records)
+}
+
+func TestFileWriterTestSuite(t *testing.T) {
+	suite.Run(t, new(FileWriterTestSuite))
+}
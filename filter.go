@@ -0,0 +1,86 @@
+// Package pflog defines all of the pflog package
+package pflog
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// AreaTagName is the tag used to identify the area of code an entry came from
+const AreaTagName = "area"
+
+// Filter decides which entries an output target receives, an entry
+// must satisfy every condition that is set
+type Filter struct {
+	// Areas the entry's area tag must be one of, empty allows all
+	Areas []string
+	// Tags that must be present with the given value, compared
+	// against the tag value formatted with %v
+	Tags map[string]string
+	// Message the message must match, nil allows all
+	Message *regexp.Regexp
+	// Exclude drops entries whose message matches, nil drops none
+	Exclude *regexp.Regexp
+}
+
+// NewFilter returns a filter compiling the given message include
+// and exclude patterns, empty patterns are ignored
+func NewFilter(areas []string, tags map[string]string, message string, exclude string) (*Filter, error) {
+	filter := &Filter{Areas: areas, Tags: tags}
+	var err error
+	if message != "" {
+		filter.Message, err = regexp.Compile(message)
+		if err != nil {
+			return nil, fmt.Errorf("bad message filter: %w", err)
+		}
+	}
+	if exclude != "" {
+		filter.Exclude, err = regexp.Compile(exclude)
+		if err != nil {
+			return nil, fmt.Errorf("bad exclude filter: %w", err)
+		}
+	}
+	return filter, nil
+}
+
+// Match returns true if the entry passes the filter
+func (f *Filter) Match(entry *Entry) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.Areas) > 0 && !f.matchArea(entry) {
+		return false
+	}
+	for name, value := range f.Tags {
+		if !entryHasTag(entry, name, value) {
+			return false
+		}
+	}
+	if f.Message != nil && !f.Message.MatchString(entry.message) {
+		return false
+	}
+	if f.Exclude != nil && f.Exclude.MatchString(entry.message) {
+		return false
+	}
+	return true
+}
+
+func (f *Filter) matchArea(entry *Entry) bool {
+	for _, area := range f.Areas {
+		if entryHasTag(entry, AreaTagName, area) {
+			return true
+		}
+	}
+	return false
+}
+
+// entryHasTag returns true if the entry has the named tag with the
+// given value, the last tag of a repeated name is the one compared
+func entryHasTag(entry *Entry, name string, value string) bool {
+	for index := len(entry.tags) - 1; index >= 0; index-- {
+		if entry.tags[index].name == name {
+			return fmt.Sprintf("%v", entry.tags[index].value) == value
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package pflog
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type FilterTestSuite struct {
+	suite.Suite
+}
+
+func (suite *FilterTestSuite) TestMatch() {
+	filter, err := NewFilter([]string{"db", "http"}, map[string]string{"user": "bob"}, "^request", "health")
+	suite.Require().Nil(err)
+
+	tags := []*Tag{CreateTag(AreaTagName, "http"), CreateTag("user", "bob")}
+	suite.Assert().True(filter.Match(NewEntry(Error, time.Now(), "request done", tags)))
+	suite.Assert().False(filter.Match(NewEntry(Error, time.Now(), "request healthcheck", tags)))
+	suite.Assert().False(filter.Match(NewEntry(Error, time.Now(), "response", tags)))
+	suite.Assert().False(filter.Match(NewEntry(Error, time.Now(), "request done", tags[:1])))
+	suite.Assert().False(filter.Match(NewEntry(Error, time.Now(), "request done", []*Tag{CreateTag(AreaTagName, "ui"), tags[1]})))
+
+	var nilFilter *Filter
+	suite.Assert().True(nilFilter.Match(NewEntry(Error, time.Now(), "anything", nil)))
+
+	_, err = NewFilter(nil, nil, "(", "")
+	suite.Assert().NotNil(err)
+}
+
+func (suite *FilterTestSuite) TestTargetLevels() {
+	log := New()
+	suite.Require().Nil(log.SetLevel(Trace))
+
+	var all, warnings bytes.Buffer
+	_ = log.AddOutputTarget(&all)
+	index := log.AddOutputTarget(&warnings)
+	suite.Require().Nil(log.SetOutputLevel(index, Warning))
+	suite.Assert().NotNil(log.SetOutputLevel(index+1, Warning))
+
+	log.Trace("trace entry")
+	log.Warning("warning entry")
+
+	suite.Assert().Contains(all.String(), "trace entry")
+	suite.Assert().Contains(all.String(), "warning entry")
+	suite.Assert().NotContains(warnings.String(), "trace entry")
+	suite.Assert().Contains(warnings.String(), "warning entry")
+
+	// backlog dumps honour the target level too
+	log.Fatal("fatal entry")
+	suite.Assert().NotContains(warnings.String(), "trace entry")
+	suite.Assert().Contains(warnings.String(), "fatal entry")
+}
+
+func (suite *FilterTestSuite) TestConfiguration() {
+	directory := suite.T().TempDir()
+	configuration := Configuration{
+		Settings: Settings{Level: "Trace", TriggerLevel: "Fatal", Backlog: 10},
+		Formatters: []FormatterEntry{
+			{ID: "text", Filename: filepath.Join(directory, "out.log"), Level: "Warning", Filter: &FilterEntry{Areas: []string{"db"}}},
+		},
+	}
+	suite.Require().Nil(configuration.LoadConfiguration())
+
+	log := configuration.GetLogger()
+	suite.Assert().Equal(LogLevel(Warning), log.outputLevels[0])
+	suite.Assert().Equal([]string{"db"}, log.outputFilters[0].Areas)
+
+	configuration.Formatters[0].Filter.Message = "["
+	suite.Assert().NotNil(configuration.LoadConfiguration())
+
+	// an unknown level must not silently become error
+	configuration.Formatters[0].Filter.Message = ""
+	configuration.Formatters[0].Level = "Warn"
+	suite.Assert().NotNil(configuration.LoadConfiguration())
+}
+
+func TestFilterTestSuite(t *testing.T) {
+	suite.Run(t, new(FilterTestSuite))
+}
@@ -3,6 +3,7 @@ module github.com/PageFaultCode/pflog
 go 1.20
 
 require (
+	github.com/klauspost/compress v1.17.9
 	github.com/stretchr/testify v1.7.1
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
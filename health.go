@@ -0,0 +1,175 @@
+// Package pflog defines all of the pflog package
+package pflog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTargetDisabled is reported when an output target is disabled after
+// failing persistently
+var ErrTargetDisabled = errors.New("target disabled")
+
+// ErrTargetReenabled is reported when a disabled output target was written
+// to successfully again
+var ErrTargetReenabled = errors.New("target re-enabled")
+
+// RetryPolicy is how writes to an output target are retried and when a
+// persistently failing target is disabled.  Retries sleep on the write path,
+// or on the target's goroutine when output is asynchronous.
+type RetryPolicy struct {
+	Attempts      int           // retries after a failed write, 0 for none
+	Backoff       time.Duration // wait before the first retry, doubling for each further one
+	MaxBackoff    time.Duration // longest wait between retries, 0 for no limit
+	DisableAfter  int           // disable the target after this many consecutive failed entries, 0 never
+	ReenableAfter time.Duration // try a disabled target again after this long, defaults to a minute
+}
+
+// DefaultReenableAfter is how long a disabled target is skipped by default
+const DefaultReenableAfter = time.Minute
+
+// TargetHealth is the state of an output target
+type TargetHealth struct {
+	Enabled       bool      // false while the target is disabled
+	Failures      int       // consecutive entries that failed to be written
+	TotalFailures uint64    // entries that failed to be written
+	Lost          uint64    // entries not written as every attempt failed or the target was disabled
+	LastError     error     // the most recent write error, nil if none
+	LastFailure   time.Time // when the most recent write failed
+	DisabledUntil time.Time // when a disabled target is tried again
+}
+
+// targetHealth writes to an output target applying its RetryPolicy and
+// tracks its health
+type targetHealth struct {
+	index  int
+	writer io.Writer
+	policy RetryPolicy
+	health TargetHealth
+	clock  func() time.Time
+	sleep  func(time.Duration)
+	mu     sync.Mutex
+}
+
+func newTargetHealth(index int, writer io.Writer) *targetHealth {
+	return &targetHealth{
+		index:  index,
+		writer: writer,
+		health: TargetHealth{Enabled: true},
+		clock:  time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+// convertStringToDuration converts an optional configuration duration
+func convertStringToDuration(duration string) (time.Duration, error) {
+	if duration == "" {
+		return 0, nil
+	}
+	converted, err := time.ParseDuration(strings.TrimSpace(duration))
+	if err != nil || converted < 0 {
+		return 0, fmt.Errorf("invalid duration: %v", duration)
+	}
+	return converted, nil
+}
+
+// validate returns an error if the policy is not usable
+func (policy RetryPolicy) validate() error {
+	if policy.Attempts < 0 || policy.DisableAfter < 0 {
+		return fmt.Errorf("retry attempts and disable after must not be negative")
+	}
+	if policy.Backoff < 0 || policy.MaxBackoff < 0 || policy.ReenableAfter < 0 {
+		return fmt.Errorf("retry durations must not be negative")
+	}
+	return nil
+}
+
+func (th *targetHealth) setPolicy(policy RetryPolicy) {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+
+	th.policy = policy
+}
+
+func (th *targetHealth) state() TargetHealth {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+
+	return th.health
+}
+
+// write writes p holding entries log entries, retrying with backoff,
+// skipping the write while the target is disabled.  Failures are counted
+// per entry and reported to the error handler.
+func (th *targetHealth) write(p []byte, entries int) {
+	if err := th.writeLocked(p, entries); err != nil {
+		reportError(&TargetError{Index: th.index, Err: err})
+	}
+}
+
+// writeLocked writes p under the lock, returning the error to report
+func (th *targetHealth) writeLocked(p []byte, entries int) error {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+
+	now := th.clock()
+	probing := false
+	if !th.health.Enabled {
+		if now.Before(th.health.DisabledUntil) {
+			th.health.Lost += uint64(entries)
+			return nil
+		}
+		// a single attempt decides whether the target is back
+		probing = true
+	}
+
+	err := th.attempt(p, probing)
+	if err == nil {
+		th.health.Enabled = true
+		th.health.Failures = 0
+		th.health.DisabledUntil = time.Time{}
+		if probing {
+			return ErrTargetReenabled
+		}
+		return nil
+	}
+
+	th.health.Failures += entries
+	th.health.TotalFailures += uint64(entries)
+	th.health.Lost += uint64(entries)
+	th.health.LastError = err
+	th.health.LastFailure = th.clock()
+	if probing || (th.policy.DisableAfter > 0 && th.health.Failures >= th.policy.DisableAfter) {
+		reenableAfter := th.policy.ReenableAfter
+		if reenableAfter == 0 {
+			reenableAfter = DefaultReenableAfter
+		}
+		th.health.DisabledUntil = th.health.LastFailure.Add(reenableAfter)
+		if !th.health.Enabled {
+			return nil
+		}
+		th.health.Enabled = false
+		return fmt.Errorf("%w after %d failures: %w", ErrTargetDisabled, th.health.Failures, err)
+	}
+	return err
+}
+
+// attempt writes p, retrying unless probing a disabled target
+func (th *targetHealth) attempt(p []byte, probing bool) error {
+	backoff := th.policy.Backoff
+	for attempt := 0; ; attempt++ {
+		_, err := th.writer.Write(p)
+		if err == nil || probing || attempt >= th.policy.Attempts {
+			return err
+		}
+		th.sleep(backoff)
+		backoff *= 2
+		if th.policy.MaxBackoff > 0 && backoff > th.policy.MaxBackoff {
+			backoff = th.policy.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package pflog
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// failingWriter fails the given number of writes, or every write while failing
+type failingWriter struct {
+	fail     int
+	failing  bool
+	attempts int
+	written  []string
+}
+
+func (fw *failingWriter) Write(p []byte) (int, error) {
+	fw.attempts++
+	if fw.failing || fw.fail > 0 {
+		fw.fail--
+		return 0, errors.New("disk full")
+	}
+	fw.written = append(fw.written, string(p))
+	return len(p), nil
+}
+
+type HealthTestSuite struct {
+	suite.Suite
+	now    time.Time
+	sleeps []time.Duration
+	errors []error
+	mu     sync.Mutex
+}
+
+func (suite *HealthTestSuite) SetupTest() {
+	suite.now = time.Date(2024, 3, 6, 10, 0, 0, 0, time.UTC)
+	suite.sleeps = nil
+	suite.errors = nil
+	SetErrorHandler(func(err error) {
+		suite.mu.Lock()
+		defer suite.mu.Unlock()
+		suite.errors = append(suite.errors, err)
+	})
+}
+
+func (suite *HealthTestSuite) TearDownTest() {
+	SetErrorHandler(nil)
+}
+
+// newLog returns a log writing to writer under policy with the suite's
+// clock and sleep
+func (suite *HealthTestSuite) newLog(writer *failingWriter, policy RetryPolicy) *Log {
+	log := New()
+	suite.Require().Nil(log.SetLevel(Trace))
+	log.SetCompactDuplicates(false)
+	index := log.AddOutputTargetAndFormatter(writer, &TextFormatter{})
+	suite.Require().Nil(log.SetOutputRetryPolicy(index, policy))
+	log.outputHealth[index].clock = func() time.Time { return suite.now }
+	log.outputHealth[index].sleep = func(duration time.Duration) { suite.sleeps = append(suite.sleeps, duration) }
+	return log
+}
+
+func (suite *HealthTestSuite) health(log *Log) TargetHealth {
+	health, err := log.GetOutputHealth(0)
+	suite.Require().Nil(err)
+	return health
+}
+
+func (suite *HealthTestSuite) TestReportedWithIndex() {
+	log := suite.newLog(&failingWriter{fail: 1}, RetryPolicy{})
+	log.Information("lost")
+	log.Information("written")
+
+	suite.Require().Len(suite.errors, 1)
+	var targetError *TargetError
+	suite.Require().True(errors.As(suite.errors[0], &targetError))
+	suite.Assert().Equal(0, targetError.Index)
+	suite.Assert().EqualError(targetError, "output 0: disk full")
+
+	health := suite.health(log)
+	suite.Assert().True(health.Enabled)
+	suite.Assert().Equal(0, health.Failures)
+	suite.Assert().Equal(uint64(1), health.TotalFailures)
+	suite.Assert().Equal(uint64(1), health.Lost)
+	suite.Assert().Equal(suite.now, health.LastFailure)
+}
+
+func (suite *HealthTestSuite) TestRetryWithBackoff() {
+	writer := &failingWriter{fail: 3}
+	log := suite.newLog(writer, RetryPolicy{Attempts: 3, Backoff: 10 * time.Millisecond, MaxBackoff: 15 * time.Millisecond})
+	log.Information("eventually")
+
+	suite.Assert().Equal([]time.Duration{10 * time.Millisecond, 15 * time.Millisecond, 15 * time.Millisecond}, suite.sleeps)
+	suite.Assert().Len(writer.written, 1)
+	suite.Assert().Empty(suite.errors)
+	suite.Assert().Equal(uint64(0), suite.health(log).TotalFailures)
+}
+
+func (suite *HealthTestSuite) TestDisableAndReenable() {
+	writer := &failingWriter{failing: true}
+	log := suite.newLog(writer, RetryPolicy{DisableAfter: 2, ReenableAfter: time.Minute})
+	log.Information("one")
+	log.Information("two")
+	log.Information("three")
+
+	suite.Assert().Equal(2, writer.attempts)
+	suite.Require().Len(suite.errors, 2)
+	suite.Assert().True(errors.Is(suite.errors[1], ErrTargetDisabled))
+	health := suite.health(log)
+	suite.Assert().False(health.Enabled)
+	suite.Assert().Equal(uint64(3), health.Lost)
+	suite.Assert().Equal(suite.now.Add(time.Minute), health.DisabledUntil)
+
+	// a failed probe keeps it disabled without reporting again
+	suite.now = suite.now.Add(time.Minute)
+	log.Information("four")
+	suite.Assert().Equal(3, writer.attempts)
+	suite.Assert().Len(suite.errors, 2)
+
+	suite.now = suite.now.Add(time.Minute)
+	writer.failing = false
+	log.Information("five")
+	log.Information("six")
+	suite.Assert().Len(writer.written, 2)
+	suite.Require().Len(suite.errors, 3)
+	suite.Assert().True(errors.Is(suite.errors[2], ErrTargetReenabled))
+	health = suite.health(log)
+	suite.Assert().True(health.Enabled)
+	suite.Assert().Equal(uint64(3), health.TotalFailures)
+}
+
+func (suite *HealthTestSuite) TestBatchCountedPerEntry() {
+	writer := &failingWriter{failing: true}
+	health := newTargetHealth(0, writer)
+	health.setPolicy(RetryPolicy{DisableAfter: 3})
+	queue := &asyncQueue{target: health}
+	queue.write(nil, []asyncItem{{data: []byte("one\n")}, {data: []byte("two\n")}, {data: []byte("three\n")}})
+
+	suite.Assert().Equal(1, writer.attempts)
+	suite.Require().Len(suite.errors, 1)
+	suite.Assert().True(errors.Is(suite.errors[0], ErrTargetDisabled))
+	state := health.state()
+	suite.Assert().False(state.Enabled)
+	suite.Assert().Equal(3, state.Failures)
+	suite.Assert().Equal(uint64(3), state.TotalFailures)
+	suite.Assert().Equal(uint64(3), state.Lost)
+
+	queue.write(nil, []asyncItem{{data: []byte("four\n")}, {data: []byte("five\n")}})
+	suite.Assert().Equal(uint64(5), health.state().Lost)
+}
+
+func (suite *HealthTestSuite) TestInvalidPolicy() {
+	log := New()
+	log.AddOutputTarget(&failingWriter{})
+	suite.Assert().NotNil(log.SetOutputRetryPolicy(0, RetryPolicy{Attempts: -1}))
+	suite.Assert().NotNil(log.SetOutputRetryPolicy(0, RetryPolicy{Backoff: -time.Second}))
+	suite.Assert().NotNil(log.SetOutputRetryPolicy(1, RetryPolicy{}))
+	_, err := log.GetOutputHealth(1)
+	suite.Assert().NotNil(err)
+
+	_, err = (&RetryEntry{Backoff: "soon"}).retryPolicy()
+	suite.Assert().NotNil(err)
+	policy, err := (&RetryEntry{Attempts: 2, Backoff: "10ms", ReenableAfter: "30s"}).retryPolicy()
+	suite.Require().Nil(err)
+	suite.Assert().Equal(RetryPolicy{Attempts: 2, Backoff: 10 * time.Millisecond, ReenableAfter: 30 * time.Second}, policy)
+}
+
+func (suite *HealthTestSuite) TestSkippedFormatterReported() {
+	configuration := Configuration{
+		Settings:   Settings{Level: "trace", TriggerLevel: "fatal"},
+		Formatters: []FormatterEntry{{ID: "unknown", Filename: "stdout"}},
+	}
+	suite.Require().Nil(configuration.LoadConfiguration())
+	suite.Require().Len(suite.errors, 1)
+	suite.Assert().True(errors.Is(suite.errors[0], ErrUnknownFormatter))
+}
+
+func TestHealthTestSuite(t *testing.T) {
+	suite.Run(t, new(HealthTestSuite))
+}
@@ -0,0 +1,149 @@
+// Package pflog defines all of the pflog package
+package pflog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPRecord is a formatted record waiting to be posted by an HTTPWriter
+type HTTPRecord struct {
+	Time time.Time // when the record was written
+	Data []byte    // the formatted record without its trailing newline
+}
+
+// HTTPEncoder encodes a batch of records as the body of one request
+type HTTPEncoder interface {
+	ContentType() string
+	Encode(records []HTTPRecord) ([]byte, error)
+}
+
+// NDJSONEncoder posts one JSON document per line, records that are not JSON,
+// such as ones from the text formatter, are posted as {"message": record}
+type NDJSONEncoder struct{}
+
+// LokiEncoder posts to the Grafana Loki push API, every record is a line of
+// one stream with the given labels
+type LokiEncoder struct {
+	Labels map[string]string // stream labels, defaults to job=pflog
+}
+
+// ElasticsearchBulkEncoder posts to the Elasticsearch bulk API, indexing
+// every record as a document.  Records that are not JSON are indexed as
+// {"@timestamp": time, "message": record}.
+type ElasticsearchBulkEncoder struct {
+	Index string // the index, empty if given by the URL
+}
+
+// ContentType implements HTTPEncoder
+func (NDJSONEncoder) ContentType() string {
+	return "application/x-ndjson"
+}
+
+// Encode implements HTTPEncoder
+func (NDJSONEncoder) Encode(records []HTTPRecord) ([]byte, error) {
+	var body bytes.Buffer
+	for _, record := range records {
+		document, err := jsonDocument(record, "")
+		if err != nil {
+			return nil, err
+		}
+		body.Write(document)
+		body.WriteByte('\n')
+	}
+	return body.Bytes(), nil
+}
+
+// ContentType implements HTTPEncoder
+func (LokiEncoder) ContentType() string {
+	return "application/json"
+}
+
+// Encode implements HTTPEncoder
+func (encoder LokiEncoder) Encode(records []HTTPRecord) ([]byte, error) {
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	labels := encoder.Labels
+	if len(labels) == 0 {
+		labels = map[string]string{"job": "pflog"}
+	}
+	push := struct {
+		Streams []stream `json:"streams"`
+	}{Streams: []stream{{Stream: labels, Values: make([][2]string, 0, len(records))}}}
+	for _, record := range records {
+		timestamp := strconv.FormatInt(record.Time.UnixNano(), 10)
+		push.Streams[0].Values = append(push.Streams[0].Values, [2]string{timestamp, string(record.Data)})
+	}
+	return json.Marshal(push)
+}
+
+// ContentType implements HTTPEncoder
+func (ElasticsearchBulkEncoder) ContentType() string {
+	return "application/x-ndjson"
+}
+
+// Encode implements HTTPEncoder
+func (encoder ElasticsearchBulkEncoder) Encode(records []HTTPRecord) ([]byte, error) {
+	action := []byte(`{"index":{}}`)
+	if encoder.Index != "" {
+		index, err := json.Marshal(encoder.Index)
+		if err != nil {
+			return nil, err
+		}
+		action = []byte(`{"index":{"_index":` + string(index) + `}}`)
+	}
+	var body bytes.Buffer
+	for _, record := range records {
+		document, err := jsonDocument(record, "@timestamp")
+		if err != nil {
+			return nil, err
+		}
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(document)
+		body.WriteByte('\n')
+	}
+	return body.Bytes(), nil
+}
+
+// jsonDocument returns the record if it is a single line JSON object and
+// otherwise wraps it in one, with its time under timeKey if given
+func jsonDocument(record HTTPRecord, timeKey string) ([]byte, error) {
+	data := bytes.TrimSpace(record.Data)
+	if bytes.HasPrefix(data, []byte("{")) && json.Valid(data) {
+		if !bytes.ContainsAny(data, "\r\n") {
+			return data, nil
+		}
+		// pretty printed, compact it onto one line
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, data); err != nil {
+			return nil, err
+		}
+		return compacted.Bytes(), nil
+	}
+	document := map[string]string{"message": string(record.Data)}
+	if timeKey != "" {
+		document[timeKey] = record.Time.Format(time.RFC3339Nano)
+	}
+	return json.Marshal(document)
+}
+
+// convertStringToHTTPEncoder converts a configuration value to an encoder
+// with the given Loki labels or Elasticsearch index
+func convertStringToHTTPEncoder(encoder string, labels map[string]string, index string) (HTTPEncoder, error) {
+	switch strings.ToLower(encoder) {
+	case "", "ndjson":
+		return NDJSONEncoder{}, nil
+	case "loki":
+		return LokiEncoder{Labels: labels}, nil
+	case "elasticsearch", "es_bulk":
+		return ElasticsearchBulkEncoder{Index: index}, nil
+	}
+	return nil, fmt.Errorf("unknown http encoder: %v", encoder)
+}
@@ -0,0 +1,86 @@
+package pflog
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type HTTPEncodersTestSuite struct {
+	suite.Suite
+	records []HTTPRecord
+}
+
+func (suite *HTTPEncodersTestSuite) SetupTest() {
+	now := time.Date(2024, 3, 6, 10, 0, 0, 0, time.UTC)
+	suite.records = []HTTPRecord{
+		{Time: now, Data: []byte(`{"level":"Error","message":"json"}`)},
+		{Time: now.Add(time.Millisecond), Data: []byte("10:00:00 Information text")},
+		{Time: now.Add(2 * time.Millisecond), Data: []byte("{\n  \"message\": \"pretty\"\n}")},
+	}
+}
+
+func (suite *HTTPEncodersTestSuite) TestNDJSON() {
+	body, err := NDJSONEncoder{}.Encode(suite.records)
+	suite.Require().Nil(err)
+	suite.Assert().Equal(`{"level":"Error","message":"json"}
+{"message":"10:00:00 Information text"}
+{"message":"pretty"}
+`, string(body))
+	suite.Assert().Equal("application/x-ndjson", NDJSONEncoder{}.ContentType())
+}
+
+func (suite *HTTPEncodersTestSuite) TestLoki() {
+	body, err := LokiEncoder{}.Encode(suite.records[:2])
+	suite.Require().Nil(err)
+	suite.Assert().JSONEq(`{"streams":[{"stream":{"job":"pflog"},"values":[
+		["1709719200000000000","{\"level\":\"Error\",\"message\":\"json\"}"],
+		["1709719200001000000","10:00:00 Information text"]]}]}`, string(body))
+
+	body, err = LokiEncoder{Labels: map[string]string{"app": "api"}}.Encode(suite.records[:1])
+	suite.Require().Nil(err)
+	var push struct {
+		Streams []struct {
+			Stream map[string]string `json:"stream"`
+		} `json:"streams"`
+	}
+	suite.Require().Nil(json.Unmarshal(body, &push))
+	suite.Assert().Equal(map[string]string{"app": "api"}, push.Streams[0].Stream)
+	suite.Assert().Equal("application/json", LokiEncoder{}.ContentType())
+}
+
+func (suite *HTTPEncodersTestSuite) TestElasticsearchBulk() {
+	body, err := ElasticsearchBulkEncoder{Index: "logs"}.Encode(suite.records[:2])
+	suite.Require().Nil(err)
+	suite.Assert().Equal(`{"index":{"_index":"logs"}}
+{"level":"Error","message":"json"}
+{"index":{"_index":"logs"}}
+{"@timestamp":"2024-03-06T10:00:00.001Z","message":"10:00:00 Information text"}
+`, string(body))
+
+	body, err = ElasticsearchBulkEncoder{}.Encode(suite.records[:1])
+	suite.Require().Nil(err)
+	suite.Assert().Equal("{\"index\":{}}\n{\"level\":\"Error\",\"message\":\"json\"}\n", string(body))
+}
+
+func (suite *HTTPEncodersTestSuite) TestConvert() {
+	for value, expected := range map[string]HTTPEncoder{
+		"":              NDJSONEncoder{},
+		"NDJSON":        NDJSONEncoder{},
+		"loki":          LokiEncoder{Labels: map[string]string{"job": "app"}},
+		"elasticsearch": ElasticsearchBulkEncoder{Index: "logs"},
+		"es_bulk":       ElasticsearchBulkEncoder{Index: "logs"},
+	} {
+		encoder, err := convertStringToHTTPEncoder(value, map[string]string{"job": "app"}, "logs")
+		suite.Require().Nil(err, value)
+		suite.Assert().Equal(expected, encoder, value)
+	}
+	_, err := convertStringToHTTPEncoder("splunk", nil, "")
+	suite.Assert().NotNil(err)
+}
+
+func TestHTTPEncodersTestSuite(t *testing.T) {
+	suite.Run(t, new(HTTPEncodersTestSuite))
+}
@@ -0,0 +1,330 @@
+// Package pflog defines all of the pflog package
+package pflog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Defaults of HTTPOptions
+const (
+	DefaultHTTPMaxRecords    = 500
+	DefaultHTTPMaxBytes      = 1024 * 1024
+	DefaultHTTPFlushInterval = time.Second
+	DefaultHTTPTimeout       = 10 * time.Second
+	DefaultHTTPRetries       = 3
+	DefaultHTTPMinBackoff    = 500 * time.Millisecond
+	DefaultHTTPMaxBackoff    = 30 * time.Second
+)
+
+// HTTPOptions configure an HTTPWriter, zero values are defaulted
+type HTTPOptions struct {
+	Encoder       HTTPEncoder       // body encoding, defaults to NDJSONEncoder
+	Headers       map[string]string // added to every request, e.g. Authorization
+	Gzip          bool              // gzip request bodies
+	MaxRecords    int               // post once a batch holds this many records
+	MaxBytes      int               // post once a batch holds this many bytes of records
+	FlushInterval time.Duration     // post a batch at the latest this long after its first record
+	Timeout       time.Duration     // of every request
+	Retries       int               // retries of a request failing with 5xx, 429 or a network error, negative for none
+	MinBackoff    time.Duration     // wait before the first retry, doubling up to MaxBackoff
+	MaxBackoff    time.Duration     // longest wait between retries, also for Retry-After
+	Client        *http.Client      // defaults to a client with Timeout
+}
+
+// HTTPWriter posts records in batches to a collector such as Loki,
+// Elasticsearch or a webhook.  Every Write is one record; a batch is posted
+// once it is full by count or bytes, by a timer after FlushInterval, or on
+// Sync and Close.  Requests failing with a 5xx status, 429 or a network
+// error are retried, waiting as long as a Retry-After header asks.
+// Batches are posted in order, one at a time, without holding up writes
+// that only add to the next batch.  A Write filling a batch, Sync and
+// Close block until their batch and all earlier ones are posted, retries
+// and backoff included, use SetAsync to keep that off the logging
+// goroutines.
+type HTTPWriter struct {
+	url        string
+	options    HTTPOptions
+	batch      []HTTPRecord
+	batchBytes int
+	generation uint64 // of the batch, so a late timer does not post the next one
+	timer      *time.Timer
+	closed     bool
+	clock      func() time.Time
+	sleep      func(time.Duration)
+	mu         sync.Mutex
+	queued     uint64     // batches taken for posting, guarded by mu
+	posted     uint64     // batches posted, guarded by postMu
+	postMu     sync.Mutex // orders the posting of batches
+	postDone   *sync.Cond // signalled on postMu after every post
+}
+
+// NewHTTPWriter returns a writer posting batches of records to url
+func NewHTTPWriter(url string, options HTTPOptions) (*HTTPWriter, error) {
+	request, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if request.URL.Scheme != "http" && request.URL.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported url: %v", url)
+	}
+	if options.Encoder == nil {
+		options.Encoder = NDJSONEncoder{}
+	}
+	if options.MaxRecords == 0 {
+		options.MaxRecords = DefaultHTTPMaxRecords
+	}
+	if options.MaxBytes == 0 {
+		options.MaxBytes = DefaultHTTPMaxBytes
+	}
+	if options.FlushInterval == 0 {
+		options.FlushInterval = DefaultHTTPFlushInterval
+	}
+	if options.Timeout == 0 {
+		options.Timeout = DefaultHTTPTimeout
+	}
+	if options.Retries == 0 {
+		options.Retries = DefaultHTTPRetries
+	}
+	if options.MinBackoff == 0 {
+		options.MinBackoff = DefaultHTTPMinBackoff
+	}
+	if options.MaxBackoff == 0 {
+		options.MaxBackoff = DefaultHTTPMaxBackoff
+	}
+	if options.MaxRecords < 0 || options.MaxBytes < 0 || options.FlushInterval < 0 || options.Timeout < 0 {
+		return nil, fmt.Errorf("http batch limits must be positive")
+	}
+	if options.MaxBackoff < options.MinBackoff {
+		return nil, fmt.Errorf("maximum backoff %v is less than the minimum %v", options.MaxBackoff, options.MinBackoff)
+	}
+	if options.Client == nil {
+		options.Client = &http.Client{Timeout: options.Timeout}
+	}
+	hw := &HTTPWriter{url: url, options: options, clock: time.Now, sleep: time.Sleep}
+	hw.postDone = sync.NewCond(&hw.postMu)
+	return hw, nil
+}
+
+// Write implements io.Writer, p is one record.  If the batch p completed
+// could not be posted its records are lost and reported to the error
+// handler; p was accepted, so no error is returned and retries by the
+// caller cannot duplicate or misreport records.
+func (hw *HTTPWriter) Write(p []byte) (int, error) {
+	hw.mu.Lock()
+	if hw.closed {
+		hw.mu.Unlock()
+		return 0, os.ErrClosed
+	}
+	data := bytes.TrimSuffix(p, []byte("\n"))
+	hw.batch = append(hw.batch, HTTPRecord{Time: hw.clock(), Data: append([]byte(nil), data...)})
+	hw.batchBytes += len(data)
+	if len(hw.batch) >= hw.options.MaxRecords || hw.batchBytes >= hw.options.MaxBytes {
+		records, ticket := hw.take()
+		hw.mu.Unlock()
+		if err := hw.post(records, ticket); err != nil {
+			reportError(err)
+		}
+		return len(p), nil
+	}
+	if len(hw.batch) == 1 {
+		generation := hw.generation
+		hw.timer = time.AfterFunc(hw.options.FlushInterval, func() { hw.timedPost(generation) })
+	}
+	hw.mu.Unlock()
+	return len(p), nil
+}
+
+// writesRecords returns true as every Write is one record
+func (hw *HTTPWriter) writesRecords() bool {
+	return true
+}
+
+// timedPost posts the batch of generation once its flush interval passed
+func (hw *HTTPWriter) timedPost(generation uint64) {
+	hw.mu.Lock()
+	if generation != hw.generation || len(hw.batch) == 0 {
+		hw.mu.Unlock()
+		return
+	}
+	records, ticket := hw.take()
+	hw.mu.Unlock()
+	if err := hw.post(records, ticket); err != nil {
+		reportError(err)
+	}
+}
+
+// take clears the batch and returns it with its ticket, the number of
+// batches to post before it, with mu held
+func (hw *HTTPWriter) take() ([]HTTPRecord, uint64) {
+	records := hw.batch
+	hw.batch = nil
+	hw.batchBytes = 0
+	hw.generation++
+	if hw.timer != nil {
+		hw.timer.Stop()
+		hw.timer = nil
+	}
+	ticket := hw.queued
+	hw.queued++
+	return records, ticket
+}
+
+// awaitPosted waits until the first count batches taken are posted
+func (hw *HTTPWriter) awaitPosted(count uint64) {
+	hw.postMu.Lock()
+	defer hw.postMu.Unlock()
+
+	for hw.posted < count {
+		hw.postDone.Wait()
+	}
+}
+
+// post posts the batch records once the batches before ticket are posted,
+// retrying as configured, without mu held
+func (hw *HTTPWriter) post(records []HTTPRecord, ticket uint64) error {
+	hw.awaitPosted(ticket)
+	defer func() {
+		hw.postMu.Lock()
+		hw.posted++
+		hw.postDone.Broadcast()
+		hw.postMu.Unlock()
+	}()
+
+	body, err := hw.options.Encoder.Encode(records)
+	if err == nil && hw.options.Gzip {
+		body, err = gzipBody(body)
+	}
+	if err != nil {
+		return fmt.Errorf("http: %d records lost: %w", len(records), err)
+	}
+	backoff := hw.options.MinBackoff
+	for retry := 0; ; retry++ {
+		retryAfter, retryable, err := hw.request(body)
+		if err == nil {
+			return nil
+		}
+		if !retryable || retry >= hw.options.Retries {
+			return fmt.Errorf("http: %d records lost: %w", len(records), err)
+		}
+		wait := backoff
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		if wait > hw.options.MaxBackoff {
+			wait = hw.options.MaxBackoff
+		}
+		hw.sleep(wait)
+		backoff *= 2
+		if backoff > hw.options.MaxBackoff {
+			backoff = hw.options.MaxBackoff
+		}
+	}
+}
+
+// request posts body once, returning whether a failure may be retried and
+// how long the collector asked to wait before doing so
+func (hw *HTTPWriter) request(body []byte) (time.Duration, bool, error) {
+	request, err := http.NewRequest(http.MethodPost, hw.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err
+	}
+	request.Header.Set("Content-Type", hw.options.Encoder.ContentType())
+	if hw.options.Gzip {
+		request.Header.Set("Content-Encoding", "gzip")
+	}
+	for key, value := range hw.options.Headers {
+		request.Header.Set(key, value)
+	}
+	response, err := hw.options.Client.Do(request)
+	if err != nil {
+		return 0, true, err
+	}
+	defer response.Body.Close()                                 //nolint:errcheck
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024)) //nolint:errcheck
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return 0, false, nil
+	}
+	err = fmt.Errorf("%s: %s", hw.url, response.Status)
+	if response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500 {
+		return hw.retryAfter(response.Header.Get("Retry-After")), true, err
+	}
+	return 0, false, err
+}
+
+// retryAfter converts a Retry-After header, in seconds or an HTTP date, to
+// a wait, 0 if absent or invalid
+func (hw *HTTPWriter) retryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		if wait := date.Sub(hw.clock()); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// gzipBody returns body compressed with gzip
+func gzipBody(body []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(body); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+// Sync posts the pending batch and waits for batches being posted
+func (hw *HTTPWriter) Sync() error {
+	hw.mu.Lock()
+	if hw.closed {
+		hw.mu.Unlock()
+		return nil
+	}
+	return hw.postPending()
+}
+
+// Close posts the pending batch and waits for batches being posted, later
+// writes fail
+func (hw *HTTPWriter) Close() error {
+	hw.mu.Lock()
+	if hw.closed {
+		hw.mu.Unlock()
+		return nil
+	}
+	hw.closed = true
+	return hw.postPending()
+}
+
+// postPending posts the pending batch, if any, after the batches being
+// posted, with mu held which it releases
+func (hw *HTTPWriter) postPending() error {
+	if len(hw.batch) == 0 {
+		queued := hw.queued
+		hw.mu.Unlock()
+		hw.awaitPosted(queued)
+		return nil
+	}
+	records, ticket := hw.take()
+	hw.mu.Unlock()
+	return hw.post(records, ticket)
+}
@@ -0,0 +1,311 @@
+package pflog
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/yaml.v3"
+)
+
+// collectorRequest is a request received by the test collector
+type collectorRequest struct {
+	header http.Header
+	body   string
+}
+
+// collectorResponse is a response the test collector gives
+type collectorResponse struct {
+	status     int
+	retryAfter string
+}
+
+type HTTPWriterTestSuite struct {
+	suite.Suite
+	server    *httptest.Server
+	requests  []collectorRequest
+	responses []collectorResponse // given in order, then 204
+	sleeps    []time.Duration
+	errors    []error
+	mu        sync.Mutex
+}
+
+func (suite *HTTPWriterTestSuite) SetupTest() {
+	suite.requests = nil
+	suite.responses = nil
+	suite.sleeps = nil
+	suite.errors = nil
+	suite.server = httptest.NewServer(http.HandlerFunc(suite.collect))
+	SetErrorHandler(func(err error) {
+		suite.mu.Lock()
+		defer suite.mu.Unlock()
+		suite.errors = append(suite.errors, err)
+	})
+}
+
+func (suite *HTTPWriterTestSuite) TearDownTest() {
+	suite.server.Close()
+	SetErrorHandler(nil)
+}
+
+func (suite *HTTPWriterTestSuite) collect(w http.ResponseWriter, r *http.Request) {
+	var reader io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gzipReader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		reader = gzipReader
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	suite.mu.Lock()
+	defer suite.mu.Unlock()
+	suite.requests = append(suite.requests, collectorRequest{header: r.Header, body: string(body)})
+	if len(suite.responses) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	response := suite.responses[0]
+	suite.responses = suite.responses[1:]
+	if response.retryAfter != "" {
+		w.Header().Set("Retry-After", response.retryAfter)
+	}
+	w.WriteHeader(response.status)
+}
+
+func (suite *HTTPWriterTestSuite) received() []collectorRequest {
+	suite.mu.Lock()
+	defer suite.mu.Unlock()
+
+	return append([]collectorRequest(nil), suite.requests...)
+}
+
+// newWriter returns a writer to the suite's server recording its sleeps
+func (suite *HTTPWriterTestSuite) newWriter(options HTTPOptions) *HTTPWriter {
+	if options.FlushInterval == 0 {
+		options.FlushInterval = time.Hour
+	}
+	writer, err := NewHTTPWriter(suite.server.URL+"/push", options)
+	suite.Require().Nil(err)
+	writer.sleep = func(duration time.Duration) { suite.sleeps = append(suite.sleeps, duration) }
+	return writer
+}
+
+func (suite *HTTPWriterTestSuite) write(writer *HTTPWriter, records ...string) {
+	for _, record := range records {
+		n, err := writer.Write([]byte(record))
+		suite.Require().Nil(err)
+		suite.Require().Equal(len(record), n)
+	}
+}
+
+func (suite *HTTPWriterTestSuite) TestBatchByCount() {
+	writer := suite.newWriter(HTTPOptions{MaxRecords: 2})
+	suite.write(writer, `{"n":1}`+"\n", `{"n":2}`+"\n", `{"n":3}`+"\n")
+	requests := suite.received()
+	suite.Require().Len(requests, 1)
+	suite.Assert().Equal("{\"n\":1}\n{\"n\":2}\n", requests[0].body)
+	suite.Assert().Equal("application/x-ndjson", requests[0].header.Get("Content-Type"))
+
+	suite.Require().Nil(writer.Close())
+	requests = suite.received()
+	suite.Require().Len(requests, 2)
+	suite.Assert().Equal("{\"n\":3}\n", requests[1].body)
+	_, err := writer.Write([]byte("closed"))
+	suite.Assert().True(errors.Is(err, os.ErrClosed))
+}
+
+func (suite *HTTPWriterTestSuite) TestBatchByBytes() {
+	writer := suite.newWriter(HTTPOptions{MaxBytes: 10})
+	suite.write(writer, "12345\n", "67890\n", "abc\n")
+	suite.Assert().Len(suite.received(), 1)
+	suite.Require().Nil(writer.Sync())
+	suite.Require().Len(suite.received(), 2)
+	suite.Assert().Equal("{\"message\":\"abc\"}\n", suite.received()[1].body)
+	suite.Require().Nil(writer.Sync())
+	suite.Assert().Len(suite.received(), 2)
+}
+
+func (suite *HTTPWriterTestSuite) TestBatchByTime() {
+	writer := suite.newWriter(HTTPOptions{FlushInterval: 20 * time.Millisecond})
+	suite.write(writer, `{"n":1}`, `{"n":2}`)
+	suite.Require().Eventually(func() bool {
+		return len(suite.received()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	suite.Assert().Equal("{\"n\":1}\n{\"n\":2}\n", suite.received()[0].body)
+	suite.Require().Nil(writer.Close())
+	suite.Assert().Len(suite.received(), 1)
+}
+
+func (suite *HTTPWriterTestSuite) TestSlowPostDoesNotBlockWrites() {
+	suite.responses = []collectorResponse{{status: http.StatusServiceUnavailable}}
+	writer := suite.newWriter(HTTPOptions{FlushInterval: 20 * time.Millisecond})
+	sleeping := make(chan struct{})
+	release := make(chan struct{})
+	writer.sleep = func(time.Duration) {
+		close(sleeping)
+		<-release
+	}
+	suite.write(writer, `{"n":1}`)
+	<-sleeping
+
+	// the timed batch waits to be retried, writes carry on
+	written := make(chan struct{})
+	go func() {
+		suite.write(writer, `{"n":2}`)
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(5 * time.Second):
+		suite.FailNow("write blocked by a batch being posted")
+	}
+	closed := make(chan error)
+	go func() { closed <- writer.Close() }()
+	close(release)
+	suite.Require().Nil(<-closed)
+
+	requests := suite.received()
+	suite.Require().Len(requests, 3)
+	suite.Assert().Equal("{\"n\":1}\n", requests[1].body)
+	suite.Assert().Equal("{\"n\":2}\n", requests[2].body)
+}
+
+func (suite *HTTPWriterTestSuite) TestGzipAndHeaders() {
+	writer := suite.newWriter(HTTPOptions{
+		Encoder: LokiEncoder{},
+		Gzip:    true,
+		Headers: map[string]string{"Authorization": "Bearer token", "X-Scope-OrgID": "tenant"},
+	})
+	suite.write(writer, "compressed\n")
+	suite.Require().Nil(writer.Close())
+
+	requests := suite.received()
+	suite.Require().Len(requests, 1)
+	suite.Assert().Equal("gzip", requests[0].header.Get("Content-Encoding"))
+	suite.Assert().Equal("application/json", requests[0].header.Get("Content-Type"))
+	suite.Assert().Equal("Bearer token", requests[0].header.Get("Authorization"))
+	suite.Assert().Equal("tenant", requests[0].header.Get("X-Scope-OrgID"))
+	suite.Assert().Contains(requests[0].body, `"compressed"`)
+}
+
+func (suite *HTTPWriterTestSuite) TestRetry() {
+	suite.responses = []collectorResponse{
+		{status: http.StatusServiceUnavailable, retryAfter: "2"},
+		{status: http.StatusTooManyRequests},
+		{status: http.StatusBadGateway, retryAfter: "120"},
+	}
+	writer := suite.newWriter(HTTPOptions{MaxRecords: 1, MinBackoff: time.Second, MaxBackoff: time.Minute})
+	suite.write(writer, "eventually\n")
+	suite.Assert().Len(suite.received(), 4)
+	suite.Assert().Equal([]time.Duration{2 * time.Second, 2 * time.Second, time.Minute}, suite.sleeps)
+
+	date := time.Now().Add(30 * time.Second).UTC().Format(http.TimeFormat)
+	wait := writer.retryAfter(date)
+	suite.Assert().True(wait > 25*time.Second && wait <= 30*time.Second, wait)
+	suite.Assert().Equal(time.Duration(0), writer.retryAfter("soon"))
+}
+
+func (suite *HTTPWriterTestSuite) TestNoRetry() {
+	suite.responses = []collectorResponse{{status: http.StatusBadRequest}, {status: http.StatusInternalServerError}}
+	writer := suite.newWriter(HTTPOptions{MaxRecords: 1})
+	suite.write(writer, "rejected\n")
+	suite.Require().Len(suite.errors, 1)
+	suite.Assert().Contains(suite.errors[0].Error(), "1 records lost")
+	suite.Assert().Contains(suite.errors[0].Error(), "400 Bad Request")
+	suite.Assert().Empty(suite.sleeps)
+
+	writer = suite.newWriter(HTTPOptions{MaxRecords: 1, Retries: -1})
+	suite.write(writer, "failed\n")
+	suite.Assert().Len(suite.errors, 2)
+	suite.Assert().Len(suite.received(), 2)
+	suite.Assert().Empty(suite.sleeps)
+
+	// a record is never posted twice nor reported lost twice
+	suite.write(writer, "accepted\n")
+	suite.Assert().Len(suite.received(), 3)
+	suite.Assert().Len(suite.errors, 2)
+}
+
+func (suite *HTTPWriterTestSuite) TestRetriesExhausted() {
+	suite.responses = []collectorResponse{{status: 500}, {status: 500}, {status: 500}}
+	writer := suite.newWriter(HTTPOptions{FlushInterval: 10 * time.Millisecond, Retries: 2})
+	suite.write(writer, "lost\n")
+	suite.Require().Eventually(func() bool {
+		suite.mu.Lock()
+		defer suite.mu.Unlock()
+		return len(suite.errors) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	suite.Assert().Len(suite.received(), 3)
+	suite.Assert().Contains(suite.errors[0].Error(), "500 Internal Server Error")
+
+	_, err := NewHTTPWriter("ftp://collector", HTTPOptions{})
+	suite.Assert().NotNil(err)
+	_, err = NewHTTPWriter(suite.server.URL, HTTPOptions{MaxRecords: -1})
+	suite.Assert().NotNil(err)
+}
+
+func (suite *HTTPWriterTestSuite) TestConfiguration() {
+	var configuration Configuration
+	suite.Require().Nil(yaml.Unmarshal([]byte(`
+settings:
+  level: Information
+  trigger_level: Fatal
+  backlog: 10
+formatters:
+  - id: json
+    http:
+      url: `+suite.server.URL+`/_bulk
+      encoder: elasticsearch
+      index: logs
+      headers: { Authorization: "ApiKey secret" }
+      gzip: true
+      max_records: 100
+      flush_interval: 1h
+      max_backoff: 1s
+`), &configuration))
+	suite.Require().Nil(configuration.LoadConfiguration())
+	log := configuration.GetLogger()
+	suite.Require().IsType(&HTTPWriter{}, log.outputTargets[0])
+	writer := log.outputTargets[0].(*HTTPWriter)
+	suite.Assert().Equal(ElasticsearchBulkEncoder{Index: "logs"}, writer.options.Encoder)
+	suite.Assert().Equal(time.Hour, writer.options.FlushInterval)
+
+	log.Error("posted")
+	log.Warning("batched")
+	suite.Assert().Empty(suite.received())
+	suite.Require().Nil(log.Close(context.Background()))
+	requests := suite.received()
+	suite.Require().Len(requests, 1)
+	suite.Assert().Equal("ApiKey secret", requests[0].header.Get("Authorization"))
+	lines := strings.Split(strings.TrimSuffix(requests[0].body, "\n"), "\n")
+	suite.Require().Len(lines, 4)
+	suite.Assert().Equal(`{"index":{"_index":"logs"}}`, lines[0])
+	suite.Assert().Contains(lines[1], `"posted"`)
+	suite.Assert().Contains(lines[3], `"batched"`)
+
+	configuration.Formatters[0].HTTP.Encoder = "splunk"
+	suite.Assert().NotNil(configuration.LoadConfiguration())
+	configuration.Formatters[0].HTTP.Encoder = ""
+	configuration.Formatters[0].HTTP.Timeout = "soon"
+	suite.Assert().NotNil(configuration.LoadConfiguration())
+}
+
+func TestHTTPWriterTestSuite(t *testing.T) {
+	suite.Run(t, new(HTTPWriterTestSuite))
+}
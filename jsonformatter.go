@@ -1,15 +1,40 @@
 // Package pflog defines all of the pflog package
 package pflog
 
-import "encoding/json"
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
 
 var jsonformatterTypeID = "json"
 
+// jsonInitialBufferSize is the starting capacity of pooled buffers
+const jsonInitialBufferSize = 512
+
+// jsonMaxPooledBufferSize keeps unusually large buffers out of the pool
+const jsonMaxPooledBufferSize = 64 * 1024
+
+var jsonBufferPool = sync.Pool{
+	New: func() interface{} {
+		buffer := make([]byte, 0, jsonInitialBufferSize)
+		return &buffer
+	},
+}
+
 type JSONFormatter struct {
 	timeFormat  string
 	prettyPrint bool
+	layout      *compiledLayout
 }
 
+// JSONOutputFormat describes the default layout of a formatted entry
 type JSONOutputFormat struct {
 	TimeStamp string                 `json:"timestamp"`
 	Level     string                 `json:"level"`
@@ -17,6 +42,26 @@ type JSONOutputFormat struct {
 	Tags      map[string]interface{} `json:"tags"`
 }
 
+// AppendFormatter is implemented by formatters that can append a
+// formatted entry to a caller supplied buffer without allocating
+type AppendFormatter interface {
+	AppendFormat(buffer []byte, entry *Entry) []byte
+}
+
+// newJSONFormatter is the factory for json formatters, supporting
+// the pretty_print option and the structured layout options
+func newJSONFormatter(options map[string]interface{}) (LogFormatter, error) {
+	prettyPrint, err := optionBool(options, "pretty_print")
+	if err != nil {
+		return nil, err
+	}
+	layout, err := layoutFromOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONFormatter{prettyPrint: prettyPrint, layout: layout.compile()}, nil
+}
+
 // ID returns the specified ID of this formatter
 func (jf *JSONFormatter) ID() string {
 	return jsonformatterTypeID
@@ -35,36 +80,289 @@ func (jf *JSONFormatter) SetPrettyPrint(prettyPrint bool) {
 	jf.prettyPrint = prettyPrint
 }
 
-// Format formats a log entry into a json
+// SetLayout sets the keys, their order and the tag placement
+func (jf *JSONFormatter) SetLayout(layout StructuredLayout) error {
+	err := layout.Validate()
+	if err != nil {
+		return err
+	}
+	jf.layout = layout.compile()
+	return nil
+}
+
+// Format formats a log entry into a newline terminated json
 // entry such as:
 // "message": "whatever"
 // "level": "information"
 // etc
 func (jf *JSONFormatter) Format(entry *Entry) []byte {
-	var err error
+	pooled := jsonBufferPool.Get().(*[]byte)
+	buffer := jf.AppendFormat((*pooled)[:0], entry)
 
-	jsonOutput := JSONOutputFormat{}
-	jsonOutput.TimeStamp = entry.timestamp.Local().Format(jf.timeFormat)
-	jsonOutput.Level, err = convertLevelToString(entry.level, true)
-	if err != nil {
-		jsonOutput.Level = err.Error()
+	formattedMessage := make([]byte, len(buffer))
+	copy(formattedMessage, buffer)
+
+	if cap(buffer) <= jsonMaxPooledBufferSize {
+		*pooled = buffer
+		jsonBufferPool.Put(pooled)
 	}
-	for _, v := range entry.tags {
-		jsonOutput.Tags[v.name] = v.value
+	return formattedMessage
+}
+
+// AppendFormat appends the newline terminated json form of the
+// entry to buffer and returns the extended buffer
+func (jf *JSONFormatter) AppendFormat(buffer []byte, entry *Entry) []byte {
+	start := len(buffer)
+
+	layout := jf.layout
+	if layout == nil {
+		layout = defaultCompiledLayout
 	}
-	jsonOutput.Message = entry.message
 
-	var formattedMessage []byte
-	var marshallErr error
+	buffer = append(buffer, '{')
+	first := true
+	for _, v := range layout.fields {
+		if !first {
+			buffer = append(buffer, ',')
+		}
+		first = false
+		buffer = appendJSONString(buffer, v.key)
+		buffer = append(buffer, ':')
+		switch v.field {
+		case FieldTimestamp:
+			buffer = appendJSONTime(buffer, entry.timestamp.Local(), jf.timeFormat)
+		case FieldLevel:
+			buffer = appendJSONString(buffer, layout.level(entry.level))
+		case FieldMessage:
+			buffer = appendJSONString(buffer, entry.message)
+		case FieldTags:
+			buffer = append(buffer, '{')
+			buffer = appendJSONTags(buffer, entry.tags, nil, true)
+			buffer = append(buffer, '}')
+		}
+	}
+	if layout.flattenTags {
+		buffer = appendJSONTags(buffer, entry.tags, layout, first)
+	}
+	buffer = append(buffer, '}')
 
 	if jf.prettyPrint {
-		formattedMessage, marshallErr = json.MarshalIndent(jsonOutput, "", "	")
-	} else {
-		formattedMessage, marshallErr = json.Marshal(jsonOutput)
+		var indented bytes.Buffer
+		err := json.Indent(&indented, buffer[start:], "", "	")
+		if err == nil {
+			buffer = append(buffer[:start], indented.Bytes()...)
+		}
 	}
 
-	if marshallErr != nil {
-		formattedMessage = []byte(marshallErr.Error())
+	return append(buffer, '\n')
+}
+
+// appendJSONTags appends the tags as object members, flattened tags
+// are renamed by the layout if they collide with a standard key
+func appendJSONTags(buffer []byte, tags []*Tag, layout *compiledLayout, first bool) []byte {
+	var keys []string
+	if layout != nil {
+		keys = layout.flattenedKeys(tags)
 	}
-	return formattedMessage
+	for index, v := range tags {
+		if tagOverridden(tags, index) {
+			continue
+		}
+		if !first {
+			buffer = append(buffer, ',')
+		}
+		first = false
+		if keys != nil {
+			buffer = appendJSONString(buffer, keys[index])
+		} else {
+			buffer = appendJSONString(buffer, v.name)
+		}
+		buffer = append(buffer, ':')
+		buffer = appendJSONValue(buffer, v.value)
+	}
+	return buffer
+}
+
+// tagOverridden returns true if a later tag has the same name, the
+// last value of a repeated tag name wins as it would in a map
+func tagOverridden(tags []*Tag, index int) bool {
+	for _, v := range tags[index+1:] {
+		if v.name == tags[index].name {
+			return true
+		}
+	}
+	return false
+}
+
+// levelName returns the upper case name of a level without allocating
+// for the known levels
+func levelName(level LogLevel) string {
+	if level >= Trace && level <= Fatal {
+		return levelNames[level]
+	}
+	levelString, err := convertLevelToString(level, true)
+	if err != nil {
+		return err.Error()
+	}
+	return levelString
+}
+
+var levelNames = func() [Fatal + 1]string {
+	var names [Fatal + 1]string
+	for level := range names {
+		names[level], _ = convertLevelToString(LogLevel(level), true)
+	}
+	return names
+}()
+
+// appendJSONTime appends a quoted timestamp in the given layout
+func appendJSONTime(buffer []byte, timestamp time.Time, layout string) []byte {
+	buffer = append(buffer, '"')
+	start := len(buffer)
+	buffer = timestamp.AppendFormat(buffer, layout)
+	for _, b := range buffer[start:] {
+		if b < utf8.RuneSelf && !jsonSafe[b] {
+			// rare layouts containing quotes or control characters
+			formatted := string(buffer[start:])
+			return appendJSONString(buffer[:start-1], formatted)
+		}
+	}
+	return append(buffer, '"')
+}
+
+// appendJSONValue appends a tag value, common types are handled
+// directly and anything else falls back to encoding/json
+func appendJSONValue(buffer []byte, value interface{}) []byte {
+	switch v := value.(type) {
+	case nil:
+		return append(buffer, "null"...)
+	case string:
+		return appendJSONString(buffer, v)
+	case bool:
+		return strconv.AppendBool(buffer, v)
+	case int:
+		return strconv.AppendInt(buffer, int64(v), 10)
+	case int8:
+		return strconv.AppendInt(buffer, int64(v), 10)
+	case int16:
+		return strconv.AppendInt(buffer, int64(v), 10)
+	case int32:
+		return strconv.AppendInt(buffer, int64(v), 10)
+	case int64:
+		return strconv.AppendInt(buffer, v, 10)
+	case uint:
+		return strconv.AppendUint(buffer, uint64(v), 10)
+	case uint8:
+		return strconv.AppendUint(buffer, uint64(v), 10)
+	case uint16:
+		return strconv.AppendUint(buffer, uint64(v), 10)
+	case uint32:
+		return strconv.AppendUint(buffer, uint64(v), 10)
+	case uint64:
+		return strconv.AppendUint(buffer, v, 10)
+	case float32:
+		return appendJSONFloat(buffer, float64(v), 32)
+	case float64:
+		return appendJSONFloat(buffer, v, 64)
+	case time.Time:
+		return appendJSONTime(buffer, v, time.RFC3339Nano)
+	case time.Duration:
+		return appendJSONString(buffer, v.String())
+	case []byte:
+		buffer = append(buffer, '"')
+		encodedLength := base64.StdEncoding.EncodedLen(len(v))
+		start := len(buffer)
+		buffer = append(buffer, make([]byte, encodedLength)...)
+		base64.StdEncoding.Encode(buffer[start:], v)
+		return append(buffer, '"')
+	case json.Marshaler:
+		return appendJSONMarshal(buffer, v)
+	case error:
+		return appendJSONString(buffer, v.Error())
+	case fmt.Stringer:
+		return appendJSONString(buffer, v.String())
+	}
+	return appendJSONMarshal(buffer, value)
+}
+
+// appendJSONFloat appends a float, values json cannot represent
+// (NaN and infinities) are written as strings
+func appendJSONFloat(buffer []byte, value float64, bits int) []byte {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		buffer = append(buffer, '"')
+		buffer = strconv.AppendFloat(buffer, value, 'g', -1, bits)
+		return append(buffer, '"')
+	}
+	return strconv.AppendFloat(buffer, value, 'g', -1, bits)
+}
+
+// appendJSONMarshal appends the encoding/json form of value,
+// or the error text as a string if it cannot be marshalled
+func appendJSONMarshal(buffer []byte, value interface{}) []byte {
+	marshalled, err := json.Marshal(value)
+	if err != nil {
+		return appendJSONString(buffer, err.Error())
+	}
+	return append(buffer, marshalled...)
+}
+
+const jsonHex = "0123456789abcdef"
+
+// jsonSafe marks the ASCII characters that need no escaping
+var jsonSafe = func() [utf8.RuneSelf]bool {
+	var safe [utf8.RuneSelf]bool
+	for b := 0x20; b < utf8.RuneSelf; b++ {
+		safe[b] = b != '"' && b != '\\'
+	}
+	return safe
+}()
+
+// appendJSONString appends s as a quoted json string, invalid
+// utf-8 is replaced with U+FFFD as encoding/json does
+func appendJSONString(buffer []byte, s string) []byte {
+	buffer = append(buffer, '"')
+	start := 0
+	for index := 0; index < len(s); {
+		b := s[index]
+		if b < utf8.RuneSelf {
+			if jsonSafe[b] {
+				index++
+				continue
+			}
+			buffer = append(buffer, s[start:index]...)
+			switch b {
+			case '"', '\\':
+				buffer = append(buffer, '\\', b)
+			case '\n':
+				buffer = append(buffer, '\\', 'n')
+			case '\r':
+				buffer = append(buffer, '\\', 'r')
+			case '\t':
+				buffer = append(buffer, '\\', 't')
+			default:
+				buffer = append(buffer, '\\', 'u', '0', '0', jsonHex[b>>4], jsonHex[b&0xf])
+			}
+			index++
+			start = index
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[index:])
+		if r == utf8.RuneError && size == 1 {
+			buffer = append(buffer, s[start:index]...)
+			buffer = append(buffer, "\ufffd"...)
+			index += size
+			start = index
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			buffer = append(buffer, s[start:index]...)
+			buffer = append(buffer, '\\', 'u', '2', '0', '2', jsonHex[r&0xf])
+			index += size
+			start = index
+			continue
+		}
+		index += size
+	}
+	buffer = append(buffer, s[start:]...)
+	return append(buffer, '"')
 }
@@ -0,0 +1,110 @@
+package pflog
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type JSONFormatterTestSuite struct {
+	suite.Suite
+}
+
+func (suite *JSONFormatterTestSuite) TestTaggedEntry() {
+	formatter := &JSONFormatter{timeFormat: time.RFC3339}
+	tags := []*Tag{
+		CreateTag("int", 42),
+		CreateTag("string", "a \"quoted\"\n\x01 value  "),
+		CreateTag("float", math.Inf(1)),
+		CreateTag("bytes", []byte("hi")),
+		CreateTag("error", errors.New("boom")),
+		CreateTag("slice", []int{1, 2}),
+		CreateTag("int", 43),
+	}
+
+	output := formatter.Format(NewEntry(Warning, time.Now(), "tagged \xff", tags))
+	suite.Require().Equal(byte('\n'), output[len(output)-1])
+
+	var decoded JSONOutputFormat
+	suite.Require().Nil(json.Unmarshal(output, &decoded))
+	suite.Assert().Equal("WARNING", decoded.Level)
+	suite.Assert().Equal("tagged �", decoded.Message)
+	suite.Assert().Equal(float64(43), decoded.Tags["int"])
+	suite.Assert().Equal("a \"quoted\"\n\x01 value  ", decoded.Tags["string"])
+	suite.Assert().Equal("+Inf", decoded.Tags["float"])
+	suite.Assert().Equal("aGk=", decoded.Tags["bytes"])
+	suite.Assert().Equal("boom", decoded.Tags["error"])
+	suite.Assert().Equal([]interface{}{float64(1), float64(2)}, decoded.Tags["slice"])
+}
+
+func (suite *JSONFormatterTestSuite) TestKeyOrder() {
+	formatter := &JSONFormatter{timeFormat: "2006"}
+	timestamp := time.Date(2021, time.May, 1, 0, 0, 0, 0, time.Local)
+
+	output := string(formatter.Format(NewEntry(Error, timestamp, "ordered", []*Tag{CreateTag("b", 1), CreateTag("a", 2)})))
+
+	suite.Assert().Equal(`{"timestamp":"2021","level":"ERROR","message":"ordered","tags":{"b":1,"a":2}}`+"\n", output)
+}
+
+func (suite *JSONFormatterTestSuite) TestPrettyPrint() {
+	formatter := &JSONFormatter{timeFormat: "2006"}
+	formatter.SetPrettyPrint(true)
+
+	output := formatter.Format(NewEntry(Error, time.Now(), "pretty", nil))
+
+	var decoded JSONOutputFormat
+	suite.Require().Nil(json.Unmarshal(output, &decoded))
+	suite.Assert().Contains(string(output), "\n\t\"message\": \"pretty\",\n")
+}
+
+func TestJSONFormatterTestSuite(t *testing.T) {
+	suite.Run(t, new(JSONFormatterTestSuite))
+}
+
+func benchmarkEntry() *Entry {
+	return NewEntry(Information, time.Now(), "request handled", []*Tag{
+		CreateTag("area", "http"),
+		CreateTag("status", 200),
+		CreateTag("duration", 1.25),
+		CreateTag("cached", false),
+	})
+}
+
+// BenchmarkJSONReflect is the encoding/json approach previously used by Format
+func BenchmarkJSONReflect(b *testing.B) {
+	entry := benchmarkEntry()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		jsonOutput := JSONOutputFormat{Tags: make(map[string]interface{})}
+		jsonOutput.TimeStamp = entry.timestamp.Local().Format(time.RFC3339)
+		jsonOutput.Level, _ = convertLevelToString(entry.level, true)
+		for _, v := range entry.tags {
+			jsonOutput.Tags[v.name] = v.value
+		}
+		jsonOutput.Message = entry.message
+		_, _ = json.Marshal(jsonOutput)
+	}
+}
+
+func BenchmarkJSONFormat(b *testing.B) {
+	formatter := &JSONFormatter{timeFormat: time.RFC3339}
+	entry := benchmarkEntry()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = formatter.Format(entry)
+	}
+}
+
+func BenchmarkJSONAppendFormat(b *testing.B) {
+	formatter := &JSONFormatter{timeFormat: time.RFC3339}
+	entry := benchmarkEntry()
+	buffer := make([]byte, 0, jsonInitialBufferSize)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buffer = formatter.AppendFormat(buffer[:0], entry)
+	}
+}
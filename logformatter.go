@@ -1,7 +1,11 @@
 // Package pflog defines all of the pflog package
 package pflog
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
 
 // LogFormatter can format logs a specific way and
 // can time stamp them as required, the default is no
@@ -12,43 +16,148 @@ type LogFormatter interface {
 	Format(entry *Entry) []byte
 }
 
-var formatters map[string]LogFormatter
+// FormatterFactory creates a new formatter instance configured
+// with the given formatter specific options, options may be nil
+type FormatterFactory func(options map[string]interface{}) (LogFormatter, error)
+
+// ErrUnknownFormatter is returned when creating a formatter
+// with an id that has not been registered
+var ErrUnknownFormatter = errors.New("unknown formatter")
+
+var (
+	formatters     map[string]FormatterFactory
+	formattersLock sync.RWMutex
+)
 
 // init initialized the log package and known formatters
 func init() {
-	formatters = make(map[string]LogFormatter)
-	err := RegisterFormatter(jsonformatterTypeID, &JSONFormatter{})
+	formatters = make(map[string]FormatterFactory)
+	err := RegisterFormatter(jsonformatterTypeID, newJSONFormatter)
+	if err != nil {
+		panic(err)
+	}
+	err = RegisterFormatter(formatterTypeID, func(map[string]interface{}) (LogFormatter, error) {
+		return &TextFormatter{}, nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	err = RegisterFormatter(yamlformatterTypeID, newYAMLFormatter)
+	if err != nil {
+		panic(err)
+	}
+	err = RegisterFormatter(consoleformatterTypeID, func(map[string]interface{}) (LogFormatter, error) {
+		return &ConsoleFormatter{}, nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	err = RegisterFormatter(templateformatterTypeID, newTemplateFormatter)
+	if err != nil {
+		panic(err)
+	}
+	err = RegisterFormatter(csvformatterTypeID, newCSVFormatter)
 	if err != nil {
 		panic(err)
 	}
-	err = RegisterFormatter(formatterTypeID, &TextFormatter{})
+	err = RegisterFormatter(tsvformatterTypeID, newTSVFormatter)
 	if err != nil {
 		panic(err)
 	}
-	err = RegisterFormatter(yamlformatterTypeID, &YAMLFormatter{})
+	err = RegisterFormatter(cborformatterTypeID, func(map[string]interface{}) (LogFormatter, error) {
+		return &CBORFormatter{}, nil
+	})
 	if err != nil {
 		panic(err)
 	}
 }
 
-// RegisterFormatter registers a given formatter with the system prior
-// to configuration loading a config file
-func RegisterFormatter(id string, formatter LogFormatter) error {
+// RegisterFormatter registers a factory for the given formatter id with
+// the system prior to configuration loading a config file
+func RegisterFormatter(id string, factory FormatterFactory) error {
+	formattersLock.Lock()
+	defer formattersLock.Unlock()
+
+	if factory == nil {
+		return fmt.Errorf("formatter %v has no factory", id)
+	}
 	_, exists := formatters[id]
 	if exists {
 		return fmt.Errorf("formatter %v already exists", id)
 	}
-	formatters[id] = formatter
+	formatters[id] = factory
 	return nil
 }
 
-// CreateFormatter returns a formatter based on the selected
+// CreateFormatter returns a new formatter based on the selected
 // id such as read from a config file.
 func CreateFormatter(id string) (LogFormatter, error) {
-	formatter, exists := formatters[id]
+	return CreateFormatterWithOptions(id, nil)
+}
+
+// CreateFormatterWithOptions returns a new formatter based on the
+// selected id, passing formatter specific options to its factory
+func CreateFormatterWithOptions(id string, options map[string]interface{}) (LogFormatter, error) {
+	formattersLock.RLock()
+	factory, exists := formatters[id]
+	formattersLock.RUnlock()
+
 	if !exists {
-		return nil, fmt.Errorf("unable to create formatter: %v", id)
+		return nil, fmt.Errorf("unable to create formatter: %v: %w", id, ErrUnknownFormatter)
 	}
 
+	formatter, err := factory(options)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create formatter: %v: %w", id, err)
+	}
 	return formatter, nil
 }
+
+// optionBool returns the named boolean option, false if not present
+func optionBool(options map[string]interface{}, name string) (bool, error) {
+	value, exists := options[name]
+	if !exists {
+		return false, nil
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("option %v must be a boolean", name)
+	}
+	return result, nil
+}
+
+// optionString returns the named string option, empty if not present
+func optionString(options map[string]interface{}, name string) (string, error) {
+	value, exists := options[name]
+	if !exists {
+		return "", nil
+	}
+	result, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("option %v must be a string", name)
+	}
+	return result, nil
+}
+
+// optionStrings returns the named string list option, nil if not present
+func optionStrings(options map[string]interface{}, name string) ([]string, error) {
+	value, exists := options[name]
+	if !exists {
+		return nil, nil
+	}
+	switch list := value.(type) {
+	case []string:
+		return list, nil
+	case []interface{}:
+		result := make([]string, 0, len(list))
+		for _, item := range list {
+			text, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("option %v must be a list of strings", name)
+			}
+			result = append(result, text)
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("option %v must be a list of strings", name)
+}
@@ -0,0 +1,72 @@
+package pflog
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type LogFormatterTestSuite struct {
+	suite.Suite
+}
+
+func (suite *LogFormatterTestSuite) TestFreshInstances() {
+	first, err := CreateFormatter("json")
+	suite.Require().Nil(err)
+	second, err := CreateFormatter("json")
+	suite.Require().Nil(err)
+
+	first.SetTimestampFormat("2006")
+	second.SetTimestampFormat(time.RFC3339)
+
+	suite.Assert().NotSame(first, second)
+	suite.Assert().Equal("2006", first.(*JSONFormatter).timeFormat)
+}
+
+func (suite *LogFormatterTestSuite) TestOptions() {
+	formatter, err := CreateFormatterWithOptions("json", map[string]interface{}{"pretty_print": true})
+	suite.Require().Nil(err)
+	suite.Assert().True(formatter.(*JSONFormatter).prettyPrint)
+
+	_, err = CreateFormatterWithOptions("json", map[string]interface{}{"pretty_print": "yes"})
+	suite.Assert().NotNil(err)
+
+	_, err = CreateFormatter("no-such-formatter")
+	suite.Assert().True(errors.Is(err, ErrUnknownFormatter))
+}
+
+func (suite *LogFormatterTestSuite) TestRegister() {
+	suite.Assert().NotNil(RegisterFormatter("json", newJSONFormatter))
+	suite.Assert().NotNil(RegisterFormatter("nil-factory", nil))
+}
+
+func (suite *LogFormatterTestSuite) TestConfigurationInstances() {
+	directory := suite.T().TempDir()
+	configuration := Configuration{
+		Settings: Settings{Level: "Information", TriggerLevel: "Fatal", Backlog: 10},
+		Formatters: []FormatterEntry{
+			{ID: "json", Filename: filepath.Join(directory, "one.log"), TimestampFormat: "2006"},
+			{ID: "json", Filename: filepath.Join(directory, "two.log"), Options: map[string]interface{}{"pretty_print": true}},
+		},
+	}
+	suite.Require().Nil(configuration.LoadConfiguration())
+
+	first, err := configuration.GetLogger().GetOutputFormatter(0)
+	suite.Require().Nil(err)
+	second, err := configuration.GetLogger().GetOutputFormatter(1)
+	suite.Require().Nil(err)
+
+	suite.Assert().Equal("2006", (*first).(*JSONFormatter).timeFormat)
+	suite.Assert().False((*first).(*JSONFormatter).prettyPrint)
+	suite.Assert().Equal(time.RFC3339, (*second).(*JSONFormatter).timeFormat)
+	suite.Assert().True((*second).(*JSONFormatter).prettyPrint)
+	suite.Assert().True(strings.Contains(string((*second).Format(NewEntry(Error, time.Now(), "x", nil))), "\n"))
+}
+
+func TestLogFormatterTestSuite(t *testing.T) {
+	suite.Run(t, new(LogFormatterTestSuite))
+}
@@ -0,0 +1,271 @@
+// Package pflog defines all of the pflog package
+package pflog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// MultilinePolicy selects how messages containing newlines are written
+type MultilinePolicy int
+
+const (
+	// MultilineRaw writes the message unchanged
+	MultilineRaw MultilinePolicy = iota
+	// MultilineEscape replaces newlines with a literal \n
+	MultilineEscape
+	// MultilineIndent indents continuation lines
+	MultilineIndent
+	// MultilineSplit writes one record per line sharing a group id tag
+	MultilineSplit
+)
+
+// SanitizePolicy selects how non-printable characters and terminal
+// escape sequences in messages and string tags are written
+type SanitizePolicy int
+
+const (
+	// SanitizeNone writes control characters unchanged
+	SanitizeNone SanitizePolicy = iota
+	// SanitizeEscape writes control characters as visible escapes i.e. \x1b
+	SanitizeEscape
+	// SanitizeStrip removes control characters and escape sequences
+	SanitizeStrip
+)
+
+// Tags added to records produced by MultilineSplit
+const (
+	GroupTagName = "group_id"
+	LineTagName  = "line"
+)
+
+// DefaultContinuationIndent is used by MultilineIndent when none is given
+const DefaultContinuationIndent = "    "
+
+var messageGroupCounter uint64
+
+// MessagePolicy is the multi-line and sanitisation handling of a formatter
+type MessagePolicy struct {
+	Multiline MultilinePolicy
+	Sanitize  SanitizePolicy
+	Indent    string // continuation indent for MultilineIndent
+}
+
+// MessagePolicyFormatter applies a MessagePolicy to entries before
+// passing them to the formatter it wraps
+type MessagePolicyFormatter struct {
+	formatter LogFormatter
+	policy    MessagePolicy
+}
+
+// NewMessagePolicyFormatter wraps formatter so that every entry is
+// sanitised and has its newlines handled according to policy
+func NewMessagePolicyFormatter(formatter LogFormatter, policy MessagePolicy) *MessagePolicyFormatter {
+	return &MessagePolicyFormatter{formatter: formatter, policy: policy}
+}
+
+// ID returns the ID of the wrapped formatter
+func (mf *MessagePolicyFormatter) ID() string {
+	return mf.formatter.ID()
+}
+
+// SetTimestampFormat sets the time stamp format of the wrapped formatter
+func (mf *MessagePolicyFormatter) SetTimestampFormat(format string) {
+	mf.formatter.SetTimestampFormat(format)
+}
+
+// Unwrap returns the wrapped formatter
+func (mf *MessagePolicyFormatter) Unwrap() LogFormatter {
+	return mf.formatter
+}
+
+// Header returns the header of the wrapped formatter, if it has one
+func (mf *MessagePolicyFormatter) Header() []byte {
+	if headerFormatter, ok := mf.formatter.(HeaderFormatter); ok {
+		return headerFormatter.Header()
+	}
+	return nil
+}
+
+// Format applies the policy and formats the resulting entries
+func (mf *MessagePolicyFormatter) Format(entry *Entry) []byte {
+	// newlines are left to the multi-line policy unless it writes them raw,
+	// where a sanitised message must not be able to forge further records
+	message := sanitizeText(entry.message, mf.policy.Sanitize, mf.policy.Multiline != MultilineRaw)
+	tags := entry.tags
+	if mf.policy.Sanitize != SanitizeNone {
+		tags = make([]*Tag, len(entry.tags))
+		for index, v := range entry.tags {
+			tags[index] = v
+			if text, ok := v.value.(string); ok {
+				tags[index] = CreateTag(v.name, sanitizeText(text, mf.policy.Sanitize, false))
+			}
+		}
+	}
+
+	if !strings.ContainsAny(message, "\r\n") || mf.policy.Multiline == MultilineRaw {
+		return mf.formatter.Format(NewEntry(entry.level, entry.timestamp, message, tags))
+	}
+
+	lines := splitLines(message)
+	switch mf.policy.Multiline {
+	case MultilineEscape:
+		message = strings.Join(lines, `\n`)
+	case MultilineIndent:
+		indent := mf.policy.Indent
+		if indent == "" {
+			indent = DefaultContinuationIndent
+		}
+		message = strings.Join(lines, "\n"+indent)
+	case MultilineSplit:
+		group := strconv.FormatUint(atomic.AddUint64(&messageGroupCounter, 1), 16)
+		var records []byte
+		for index, line := range lines {
+			lineTags := make([]*Tag, 0, len(tags)+2)
+			lineTags = append(lineTags, tags...)
+			lineTags = append(lineTags, CreateTag(GroupTagName, group), CreateTag(LineTagName, index+1))
+			records = append(records, mf.formatter.Format(NewEntry(entry.level, entry.timestamp, line, lineTags))...)
+		}
+		return records
+	}
+
+	return mf.formatter.Format(NewEntry(entry.level, entry.timestamp, message, tags))
+}
+
+// splitLines splits on \n, \r\n and \r dropping a single trailing newline
+func splitLines(message string) []string {
+	message = strings.ReplaceAll(message, "\r\n", "\n")
+	message = strings.ReplaceAll(message, "\r", "\n")
+	return strings.Split(strings.TrimSuffix(message, "\n"), "\n")
+}
+
+// sanitizeText escapes or strips control characters and terminal escape
+// sequences, newlines are kept when keepNewlines is set so that the
+// multi-line policy can handle them.  When escaping a backslash is
+// escaped too, so an escape cannot be told apart from the text \n.
+func sanitizeText(text string, policy SanitizePolicy, keepNewlines bool) string {
+	if policy == SanitizeNone || !needsSanitizing(text, policy, keepNewlines) {
+		return text
+	}
+
+	var builder strings.Builder
+	builder.Grow(len(text))
+	for index := 0; index < len(text); {
+		r, size := utf8.DecodeRuneInString(text[index:])
+		switch {
+		case r == '\\' && policy == SanitizeEscape:
+			builder.WriteString(`\\`)
+		case r == '\n' && keepNewlines, r == '\t':
+			builder.WriteRune(r)
+		case r == 0x1b && policy == SanitizeStrip:
+			size += escapeSequenceLength(text[index+size:])
+		case r == utf8.RuneError && size == 1:
+			if policy == SanitizeEscape {
+				builder.WriteString(fmt.Sprintf(`\x%02x`, text[index]))
+			}
+		case isControl(r):
+			if policy == SanitizeEscape {
+				builder.WriteString(escapeControl(r))
+			}
+		default:
+			builder.WriteString(text[index : index+size])
+		}
+		index += size
+	}
+	return builder.String()
+}
+
+func needsSanitizing(text string, policy SanitizePolicy, keepNewlines bool) bool {
+	for index := 0; index < len(text); {
+		r, size := utf8.DecodeRuneInString(text[index:])
+		if (r == utf8.RuneError && size == 1) || (isControl(r) && r != '\t' && !(r == '\n' && keepNewlines)) {
+			return true
+		}
+		if r == '\\' && policy == SanitizeEscape {
+			return true
+		}
+		index += size
+	}
+	return false
+}
+
+// isControl returns true for C0 and C1 control characters and DEL
+func isControl(r rune) bool {
+	return r < 0x20 || (r >= 0x7f && r <= 0x9f)
+}
+
+func escapeControl(r rune) string {
+	switch r {
+	case '\n':
+		return `\n`
+	case '\r':
+		return `\r`
+	}
+	if r >= 0x80 {
+		return fmt.Sprintf(`\u%04x`, r)
+	}
+	return fmt.Sprintf(`\x%02x`, r)
+}
+
+// escapeSequenceLength returns the length of the remainder of an ANSI
+// escape sequence following an ESC character: CSI sequences run to
+// their final byte, OSC sequences to BEL or ST, others are one byte
+func escapeSequenceLength(text string) int {
+	if text == "" {
+		return 0
+	}
+	switch text[0] {
+	case '[':
+		for index := 1; index < len(text); index++ {
+			if text[index] >= 0x40 && text[index] <= 0x7e {
+				return index + 1
+			}
+		}
+		return len(text)
+	case ']':
+		for index := 1; index < len(text); index++ {
+			if text[index] == 0x07 {
+				return index + 1
+			}
+			if text[index] == 0x1b && index+1 < len(text) && text[index+1] == '\\' {
+				return index + 2
+			}
+		}
+		return len(text)
+	}
+	if text[0] >= 0x20 && text[0] <= 0x7e {
+		return 1
+	}
+	return 0
+}
+
+// convertStringToMultilinePolicy converts a configuration value to a policy
+func convertStringToMultilinePolicy(policy string) (MultilinePolicy, error) {
+	switch strings.ToLower(policy) {
+	case "", "raw":
+		return MultilineRaw, nil
+	case "escape":
+		return MultilineEscape, nil
+	case "indent":
+		return MultilineIndent, nil
+	case "split":
+		return MultilineSplit, nil
+	}
+	return MultilineRaw, fmt.Errorf("unknown multiline policy: %v", policy)
+}
+
+// convertStringToSanitizePolicy converts a configuration value to a policy
+func convertStringToSanitizePolicy(policy string) (SanitizePolicy, error) {
+	switch strings.ToLower(policy) {
+	case "", "none":
+		return SanitizeNone, nil
+	case "escape":
+		return SanitizeEscape, nil
+	case "strip":
+		return SanitizeStrip, nil
+	}
+	return SanitizeNone, fmt.Errorf("unknown sanitize policy: %v", policy)
+}
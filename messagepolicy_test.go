@@ -0,0 +1,79 @@
+package pflog
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MessagePolicyTestSuite struct {
+	suite.Suite
+}
+
+func (suite *MessagePolicyTestSuite) format(policy MessagePolicy, message string, tags []*Tag) string {
+	formatter := NewMessagePolicyFormatter(&TemplateFormatter{}, policy)
+	suite.Require().Nil(formatter.Unwrap().(*TemplateFormatter).SetTemplate(`{{range .Tags}}{{.Name}}={{.Value}} {{end}}{{.Message}}`))
+	return string(formatter.Format(NewEntry(Error, time.Now(), message, tags)))
+}
+
+func (suite *MessagePolicyTestSuite) TestEscape() {
+	output := suite.format(MessagePolicy{Multiline: MultilineEscape}, "one\r\ntwo\nthree\n", nil)
+
+	suite.Assert().Equal("one\\ntwo\\nthree\n", output)
+}
+
+func (suite *MessagePolicyTestSuite) TestIndent() {
+	output := suite.format(MessagePolicy{Multiline: MultilineIndent, Indent: "  "}, "one\ntwo", nil)
+
+	suite.Assert().Equal("one\n  two\n", output)
+}
+
+func (suite *MessagePolicyTestSuite) TestSplit() {
+	output := suite.format(MessagePolicy{Multiline: MultilineSplit}, "one\ntwo", []*Tag{CreateTag("area", "db")})
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+
+	suite.Require().Equal(2, len(lines))
+	group := strings.Fields(lines[0])[1]
+	suite.Assert().True(strings.HasPrefix(group, GroupTagName+"="))
+	suite.Assert().Equal("area=db "+group+" line=1 one", lines[0])
+	suite.Assert().Equal("area=db "+group+" line=2 two", lines[1])
+}
+
+func (suite *MessagePolicyTestSuite) TestSanitize() {
+	message := "\x1b[31mred\x1b[0m\x1b]0;title\x07 bell\x07 tab\t\u0085"
+
+	suite.Assert().Equal("red bell tab\t\n", suite.format(MessagePolicy{Sanitize: SanitizeStrip}, message, nil))
+	suite.Assert().Equal("\\x1b[31mred\\x1b[0m\\x1b]0;title\\x07 bell\\x07 tab\t\\u0085\n", suite.format(MessagePolicy{Sanitize: SanitizeEscape}, message, nil))
+
+	// a raw newline would forge a second record
+	output := suite.format(MessagePolicy{Sanitize: SanitizeEscape}, "forged\nERROR fake", []*Tag{CreateTag("user", "a\nb")})
+	suite.Assert().Equal("user=a\\nb forged\\nERROR fake\n", output)
+	suite.Assert().Equal("forgedERROR fake\n", suite.format(MessagePolicy{Sanitize: SanitizeStrip}, "forged\nERROR fake", nil))
+
+	// a backslash is escaped so an escape cannot be faked
+	output = suite.format(MessagePolicy{Sanitize: SanitizeEscape}, `path\new`, []*Tag{CreateTag("user", `a\x1b`)})
+	suite.Assert().Equal(`user=a\\x1b path\\new`+"\n", output)
+
+	// multi-line policies still handle the newlines
+	output = suite.format(MessagePolicy{Multiline: MultilineEscape, Sanitize: SanitizeEscape}, "one\ntwo\\n", nil)
+	suite.Assert().Equal(`one\ntwo\\n`+"\n", output)
+	output = suite.format(MessagePolicy{Multiline: MultilineIndent, Sanitize: SanitizeEscape}, "one\ntwo", nil)
+	suite.Assert().Equal("one\n    two\n", output)
+}
+
+func (suite *MessagePolicyTestSuite) TestConfiguration() {
+	entry := FormatterEntry{Multiline: "bogus"}
+	_, err := entry.applyMessagePolicy(&TextFormatter{})
+	suite.Assert().NotNil(err)
+
+	entry = FormatterEntry{Multiline: "split", Sanitize: "strip"}
+	formatter, err := entry.applyMessagePolicy(&TextFormatter{})
+	suite.Require().Nil(err)
+	suite.Assert().Equal(formatterTypeID, formatter.ID())
+}
+
+func TestMessagePolicyTestSuite(t *testing.T) {
+	suite.Run(t, new(MessagePolicyTestSuite))
+}
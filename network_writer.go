@@ -0,0 +1,298 @@
+// Package pflog defines all of the pflog package
+package pflog
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NetworkFraming is how records are delimited on a network connection
+type NetworkFraming int
+
+const (
+	// FramingNewline ends every record with a newline
+	FramingNewline NetworkFraming = iota
+	// FramingOctetCounting precedes every record with its length and a
+	// space as in RFC 6587, allowing newlines within records
+	FramingOctetCounting
+)
+
+// Defaults of NetworkOptions
+const (
+	DefaultNetworkDialTimeout  = 5 * time.Second
+	DefaultNetworkWriteTimeout = 5 * time.Second
+	DefaultNetworkMinBackoff   = 100 * time.Millisecond
+	DefaultNetworkMaxBackoff   = 30 * time.Second
+)
+
+// ErrNotConnected is returned while a NetworkWriter waits to reconnect
+var ErrNotConnected = errors.New("not connected")
+
+// NetworkOptions configure a NetworkWriter, zero values are defaulted
+type NetworkOptions struct {
+	TLS            *tls.Config // connect with TLS if set
+	Framing        NetworkFraming
+	SpoolDirectory string // spool records here while the collector is unreachable
+	DialTimeout    time.Duration
+	WriteTimeout   time.Duration
+	MinBackoff     time.Duration // wait before the first reconnect, doubling up to MaxBackoff
+	MaxBackoff     time.Duration
+}
+
+// NetworkWriter ships records to a collector over TCP, optionally with TLS.
+// A lost connection is re-established on a later write, backing off
+// exponentially while the collector is unreachable.  With a spool directory
+// records are spooled to disk meanwhile and replayed once connected, also
+// after a restart; without one records written while disconnected fail.
+type NetworkWriter struct {
+	connection *networkConnection
+	writer     io.Writer // the connection or a FailoverWriter spooling for it
+}
+
+// networkConnection is the connection of a NetworkWriter
+type networkConnection struct {
+	address string
+	options NetworkOptions
+	conn    net.Conn
+	closed  chan struct{} // closed once the collector closed conn
+	backoff time.Duration // wait before the next reconnect
+	redial  time.Time     // when to reconnect
+	clock   func() time.Time
+	mu      sync.Mutex
+}
+
+// convertStringToNetworkFraming converts a configuration value to a framing
+func convertStringToNetworkFraming(framing string) (NetworkFraming, error) {
+	switch strings.ToLower(framing) {
+	case "", "newline":
+		return FramingNewline, nil
+	case "octet_counting", "octet-counting":
+		return FramingOctetCounting, nil
+	}
+	return FramingNewline, fmt.Errorf("unknown framing: %v", framing)
+}
+
+// NewTLSConfig returns a TLS configuration trusting the PEM certificates in
+// caFile, or the system roots if empty, and presenting the client
+// certificate in certFile and keyFile if given
+func NewTLSConfig(caFile string, certFile string, keyFile string, serverName string) (*tls.Config, error) {
+	config := &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(filepath.Clean(caFile))
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(filepath.Clean(certFile), filepath.Clean(keyFile))
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// NewNetworkWriter returns a writer shipping records to address.  It does
+// not connect until the first write so an unreachable collector is not an
+// error.
+func NewNetworkWriter(address string, options NetworkOptions) (*NetworkWriter, error) {
+	if options.Framing != FramingNewline && options.Framing != FramingOctetCounting {
+		return nil, fmt.Errorf("unknown framing: %d", options.Framing)
+	}
+	if options.DialTimeout == 0 {
+		options.DialTimeout = DefaultNetworkDialTimeout
+	}
+	if options.WriteTimeout == 0 {
+		options.WriteTimeout = DefaultNetworkWriteTimeout
+	}
+	if options.MinBackoff == 0 {
+		options.MinBackoff = DefaultNetworkMinBackoff
+	}
+	if options.MaxBackoff == 0 {
+		options.MaxBackoff = DefaultNetworkMaxBackoff
+	}
+	if options.MaxBackoff < options.MinBackoff {
+		return nil, fmt.Errorf("maximum backoff %v is less than the minimum %v", options.MaxBackoff, options.MinBackoff)
+	}
+	connection := &networkConnection{address: address, options: options, clock: time.Now}
+	writer := &NetworkWriter{connection: connection, writer: connection}
+	if options.SpoolDirectory != "" {
+		if err := os.MkdirAll(filepath.Clean(options.SpoolDirectory), 0700); err != nil {
+			return nil, err
+		}
+		spool := filepath.Join(options.SpoolDirectory, spoolName(address))
+		failover, err := NewFailoverWriter(connection, spool)
+		if err != nil {
+			return nil, err
+		}
+		// the connection's backoff decides when to reconnect
+		if err := failover.SetRetryInterval(options.MinBackoff); err != nil {
+			return nil, err
+		}
+		writer.writer = failover
+	}
+	return writer, nil
+}
+
+// spoolName returns the name of the spool file of address
+func spoolName(address string) string {
+	return strings.NewReplacer(":", "_", "/", "_", "[", "", "]", "").Replace(address) + ".spool"
+}
+
+// Write implements io.Writer, p is one record
+func (nw *NetworkWriter) Write(p []byte) (int, error) {
+	return nw.writer.Write(p)
+}
+
+// writesRecords returns true as every Write is framed as one record
+func (nw *NetworkWriter) writesRecords() bool {
+	return true
+}
+
+// Spooled returns the number of bytes of records waiting to be shipped
+func (nw *NetworkWriter) Spooled() int64 {
+	if failover, ok := nw.writer.(*FailoverWriter); ok {
+		return failover.Spooled()
+	}
+	return 0
+}
+
+// Sync syncs the spool
+func (nw *NetworkWriter) Sync() error {
+	if failover, ok := nw.writer.(*FailoverWriter); ok {
+		return failover.Sync()
+	}
+	return nil
+}
+
+// Close closes the connection and the spool, spooled records are kept
+// for the next run
+func (nw *NetworkWriter) Close() error {
+	if failover, ok := nw.writer.(*FailoverWriter); ok {
+		return failover.Close()
+	}
+	return nw.connection.Close()
+}
+
+// Write frames and sends one record, connecting first if needed
+func (nc *networkConnection) Write(p []byte) (int, error) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	if nc.conn != nil && nc.collectorClosed() {
+		nc.disconnect()
+	}
+	if nc.conn == nil {
+		if err := nc.connect(); err != nil {
+			return 0, err
+		}
+	}
+	if err := nc.conn.SetWriteDeadline(time.Now().Add(nc.options.WriteTimeout)); err != nil {
+		nc.disconnect()
+		return 0, err
+	}
+	if _, err := nc.conn.Write(nc.frame(p)); err != nil {
+		nc.disconnect()
+		return 0, fmt.Errorf("%s: %w", nc.address, err)
+	}
+	return len(p), nil
+}
+
+// connect dials the collector unless waiting to reconnect, backing off
+// exponentially after every failure
+func (nc *networkConnection) connect() error {
+	now := nc.clock()
+	if now.Before(nc.redial) {
+		return fmt.Errorf("%s: %w", nc.address, ErrNotConnected)
+	}
+	dialer := &net.Dialer{Timeout: nc.options.DialTimeout}
+	var conn net.Conn
+	var err error
+	if nc.options.TLS != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", nc.address, nc.options.TLS)
+	} else {
+		conn, err = dialer.Dial("tcp", nc.address)
+	}
+	if err != nil {
+		nc.backoff *= 2
+		if nc.backoff < nc.options.MinBackoff {
+			nc.backoff = nc.options.MinBackoff
+		}
+		if nc.backoff > nc.options.MaxBackoff {
+			nc.backoff = nc.options.MaxBackoff
+		}
+		nc.redial = now.Add(nc.backoff)
+		return err
+	}
+	nc.conn = conn
+	nc.closed = make(chan struct{})
+	nc.backoff = 0
+	go watchConnection(conn, nc.closed)
+	return nil
+}
+
+// collectorClosed returns true if the collector closed the connection, so a
+// write is not lost in the buffers of a connection that is already gone
+func (nc *networkConnection) collectorClosed() bool {
+	select {
+	case <-nc.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (nc *networkConnection) disconnect() {
+	nc.conn.Close() //nolint:errcheck
+	nc.conn = nil
+}
+
+// frame returns p framed for the connection
+func (nc *networkConnection) frame(p []byte) []byte {
+	if nc.options.Framing == FramingOctetCounting {
+		record := bytes.TrimSuffix(p, []byte("\n"))
+		framed := strconv.AppendInt(nil, int64(len(record)), 10)
+		framed = append(framed, ' ')
+		return append(framed, record...)
+	}
+	if bytes.HasSuffix(p, []byte("\n")) {
+		return p
+	}
+	return append(append([]byte(nil), p...), '\n')
+}
+
+// Close closes the connection
+func (nc *networkConnection) Close() error {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	if nc.conn == nil {
+		return nil
+	}
+	err := nc.conn.Close()
+	nc.conn = nil
+	return err
+}
+
+// watchConnection closes closed once conn is closed, the collector never
+// writes to it otherwise
+func watchConnection(conn net.Conn, closed chan struct{}) {
+	io.Copy(io.Discard, conn) //nolint:errcheck
+	close(closed)
+}
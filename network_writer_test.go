@@ -0,0 +1,333 @@
+package pflog
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/yaml.v3"
+)
+
+// collector is a local log collector that can be stopped and restarted on
+// the same address
+type collector struct {
+	address  string
+	tls      *tls.Config
+	listener net.Listener
+	conns    []net.Conn
+	received bytes.Buffer
+	done     sync.WaitGroup
+	mu       sync.Mutex
+}
+
+func (c *collector) start() error {
+	listener, err := net.Listen("tcp", c.address)
+	if err != nil {
+		return err
+	}
+	if c.tls != nil {
+		listener = tls.NewListener(listener, c.tls)
+	}
+	c.listener = listener
+	c.address = listener.Addr().String()
+	c.done.Add(1)
+	go func() {
+		defer c.done.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			c.mu.Lock()
+			c.conns = append(c.conns, conn)
+			c.mu.Unlock()
+			c.done.Add(1)
+			go func() {
+				defer c.done.Done()
+				buffer := make([]byte, 1024)
+				for {
+					n, err := conn.Read(buffer)
+					c.mu.Lock()
+					c.received.Write(buffer[:n])
+					c.mu.Unlock()
+					if err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return nil
+}
+
+// stop closes the listener and every connection
+func (c *collector) stop() {
+	c.listener.Close() //nolint:errcheck
+	c.mu.Lock()
+	for _, conn := range c.conns {
+		conn.Close() //nolint:errcheck
+	}
+	c.conns = nil
+	c.mu.Unlock()
+	c.done.Wait()
+}
+
+func (c *collector) contents() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.received.String()
+}
+
+type NetworkWriterTestSuite struct {
+	suite.Suite
+	now       time.Time
+	collector *collector
+}
+
+func (suite *NetworkWriterTestSuite) SetupTest() {
+	suite.now = time.Date(2024, 3, 6, 10, 0, 0, 0, time.UTC)
+	suite.collector = &collector{address: "127.0.0.1:0"}
+	SetErrorHandler(func(error) {})
+}
+
+func (suite *NetworkWriterTestSuite) TearDownTest() {
+	if suite.collector.listener != nil {
+		suite.collector.stop()
+	}
+	SetErrorHandler(nil)
+}
+
+// newWriter returns a writer to the suite's collector with the suite's clock
+func (suite *NetworkWriterTestSuite) newWriter(options NetworkOptions) *NetworkWriter {
+	writer, err := NewNetworkWriter(suite.collector.address, options)
+	suite.Require().Nil(err)
+	clock := func() time.Time { return suite.now }
+	writer.connection.clock = clock
+	if failover, ok := writer.writer.(*FailoverWriter); ok {
+		failover.clock = clock
+	}
+	return writer
+}
+
+// receives waits for the collector to have received contents
+func (suite *NetworkWriterTestSuite) receives(contents string) {
+	suite.Require().Eventually(func() bool {
+		return suite.collector.contents() == contents
+	}, 5*time.Second, 10*time.Millisecond, "received %q", suite.collector.contents())
+}
+
+// disconnected stops the collector and waits for the writer to notice
+func (suite *NetworkWriterTestSuite) disconnected(writer *NetworkWriter) {
+	suite.collector.stop()
+	suite.Require().Eventually(func() bool {
+		writer.connection.mu.Lock()
+		defer writer.connection.mu.Unlock()
+		return writer.connection.conn == nil || writer.connection.collectorClosed()
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func (suite *NetworkWriterTestSuite) TestNewlineFraming() {
+	suite.Require().Nil(suite.collector.start())
+	writer := suite.newWriter(NetworkOptions{})
+	log := New()
+	suite.Require().Nil(log.SetLevel(Trace))
+	log.AddOutputTargetAndFormatter(writer, &TextFormatter{})
+	log.Information("first")
+	_, err := writer.Write([]byte("second"))
+	suite.Require().Nil(err)
+	suite.Require().Nil(writer.Close())
+
+	suite.Require().Eventually(func() bool {
+		return strings.Count(suite.collector.contents(), "\n") == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	lines := strings.Split(suite.collector.contents(), "\n")
+	suite.Assert().Contains(lines[0], "first")
+	suite.Assert().Equal("second", lines[1])
+}
+
+func (suite *NetworkWriterTestSuite) TestOctetCountingFraming() {
+	suite.Require().Nil(suite.collector.start())
+	writer := suite.newWriter(NetworkOptions{Framing: FramingOctetCounting})
+	for _, record := range []string{"one\n", "two\nlines\n"} {
+		_, err := writer.Write([]byte(record))
+		suite.Require().Nil(err)
+	}
+	suite.receives("3 one9 two\nlines")
+	suite.Require().Nil(writer.Close())
+
+	framing, err := convertStringToNetworkFraming("Octet-Counting")
+	suite.Require().Nil(err)
+	suite.Assert().Equal(FramingOctetCounting, framing)
+	_, err = convertStringToNetworkFraming("length")
+	suite.Assert().NotNil(err)
+}
+
+func (suite *NetworkWriterTestSuite) TestReconnect() {
+	suite.Require().Nil(suite.collector.start())
+	writer := suite.newWriter(NetworkOptions{})
+	_, err := writer.Write([]byte("before\n"))
+	suite.Require().Nil(err)
+	suite.receives("before\n")
+
+	suite.disconnected(writer)
+	_, err = writer.Write([]byte("lost\n"))
+	suite.Assert().NotNil(err)
+	_, err = writer.Write([]byte("lost\n"))
+	suite.Assert().True(errors.Is(err, ErrNotConnected))
+
+	suite.Require().Nil(suite.collector.start())
+	suite.now = suite.now.Add(DefaultNetworkMinBackoff)
+	_, err = writer.Write([]byte("after\n"))
+	suite.Require().Nil(err)
+	suite.receives("before\nafter\n")
+	suite.Require().Nil(writer.Close())
+}
+
+func (suite *NetworkWriterTestSuite) TestBackoff() {
+	// an address nothing listens on
+	suite.Require().Nil(suite.collector.start())
+	suite.collector.stop()
+	suite.collector.listener = nil
+	writer := suite.newWriter(NetworkOptions{MinBackoff: time.Second, MaxBackoff: 3 * time.Second})
+
+	var backoffs []time.Duration
+	for i := 0; i < 4; i++ {
+		_, err := writer.Write([]byte("unreachable\n"))
+		suite.Require().NotNil(err)
+		backoffs = append(backoffs, writer.connection.redial.Sub(suite.now))
+		suite.now = writer.connection.redial
+	}
+	suite.Assert().Equal([]time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}, backoffs)
+
+	_, err := NewNetworkWriter(suite.collector.address, NetworkOptions{MinBackoff: time.Minute})
+	suite.Assert().NotNil(err)
+}
+
+func (suite *NetworkWriterTestSuite) TestSpoolAcrossRestart() {
+	directory := suite.T().TempDir()
+	suite.Require().Nil(suite.collector.start())
+	writer := suite.newWriter(NetworkOptions{SpoolDirectory: directory})
+	_, err := writer.Write([]byte("one\n"))
+	suite.Require().Nil(err)
+	suite.receives("one\n")
+
+	suite.disconnected(writer)
+	for _, record := range []string{"two\n", "three\n"} {
+		_, err = writer.Write([]byte(record))
+		suite.Require().Nil(err)
+	}
+	suite.Assert().Equal(int64(2*spoolFrameHeader+10), writer.Spooled())
+	suite.Require().Nil(writer.Close())
+	_, err = os.Stat(filepath.Join(directory, spoolName(suite.collector.address)))
+	suite.Require().Nil(err)
+
+	// a new run replays the spool once the collector is back
+	suite.Require().Nil(suite.collector.start())
+	writer = suite.newWriter(NetworkOptions{SpoolDirectory: directory})
+	_, err = writer.Write([]byte("four\n"))
+	suite.Require().Nil(err)
+	suite.receives("one\ntwo\nthree\nfour\n")
+	suite.Assert().Equal(int64(0), writer.Spooled())
+	suite.Require().Nil(writer.Close())
+}
+
+func (suite *NetworkWriterTestSuite) TestTLS() {
+	directory := suite.T().TempDir()
+	caFile, certificate := suite.selfSigned(directory)
+	suite.collector.tls = &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}
+	suite.Require().Nil(suite.collector.start())
+
+	config, err := NewTLSConfig(caFile, "", "", "")
+	suite.Require().Nil(err)
+	writer := suite.newWriter(NetworkOptions{TLS: config})
+	_, err = writer.Write([]byte("secret\n"))
+	suite.Require().Nil(err)
+	suite.receives("secret\n")
+	suite.Require().Nil(writer.Close())
+
+	// the CA file must exist
+	config, err = NewTLSConfig(filepath.Join(directory, "missing.pem"), "", "", "")
+	suite.Assert().Nil(config)
+	suite.Assert().NotNil(err)
+}
+
+// selfSigned writes a self-signed certificate for 127.0.0.1 to directory,
+// returning its file and the certificate with its key
+func (suite *NetworkWriterTestSuite) selfSigned(directory string) (string, tls.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	suite.Require().Nil(err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "collector"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	suite.Require().Nil(err)
+	caFile := filepath.Join(directory, "ca.pem")
+	suite.Require().Nil(os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	return caFile, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func (suite *NetworkWriterTestSuite) TestConfiguration() {
+	directory := suite.T().TempDir()
+	suite.Require().Nil(suite.collector.start())
+	var configuration Configuration
+	suite.Require().Nil(yaml.Unmarshal([]byte(`
+settings:
+  level: Information
+  trigger_level: Fatal
+formatters:
+  - id: json
+    network:
+      address: `+suite.collector.address+`
+      framing: octet_counting
+      spool_directory: `+directory+`
+      max_backoff: 1m
+`), &configuration))
+	suite.Require().Nil(configuration.LoadConfiguration())
+	log := configuration.GetLogger()
+	suite.Require().IsType(&NetworkWriter{}, log.outputTargets[0])
+	writer := log.outputTargets[0].(*NetworkWriter)
+	suite.Assert().Equal(FramingOctetCounting, writer.connection.options.Framing)
+	suite.Assert().Equal(time.Minute, writer.connection.options.MaxBackoff)
+
+	log.Error("shipped")
+	suite.Require().Nil(log.Close(context.Background()))
+	suite.Require().Eventually(func() bool {
+		return strings.Contains(suite.collector.contents(), "shipped")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	configuration.Formatters[0].Network.Framing = "length"
+	suite.Assert().NotNil(configuration.LoadConfiguration())
+	configuration.Formatters[0].Network.Framing = ""
+	configuration.Formatters[0].Network.CAFile = filepath.Join(directory, "missing.pem")
+	suite.Assert().True(errors.Is(configuration.LoadConfiguration(), os.ErrNotExist))
+}
+
+func TestNetworkWriterTestSuite(t *testing.T) {
+	suite.Run(t, new(NetworkWriterTestSuite))
+}
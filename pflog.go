@@ -2,8 +2,11 @@
 package pflog
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"os"
 	"strings"
 	"sync"
 	"time"
@@ -29,6 +32,10 @@ var (
 
 const DefaultBacklogDepth = 500
 
+// ErrCloneClose is returned when closing a clone, whose outputs belong to
+// the log it was cloned from
+var ErrCloneClose = errors.New("a clone shares the outputs of its log, close that log instead")
+
 type LogLevel int
 
 // Log is a type that has both a level at which to log, and a
@@ -49,8 +56,18 @@ type Log struct {
 	bufferedMessages  []*Entry
 	outputTargets     []io.Writer
 	outputFormatters  []LogFormatter
+	outputLevels      []LogLevel
+	outputFilters     []*Filter
+	outputSyncs       []*targetSync
+	outputQueues      []*asyncQueue // nil unless output is asynchronous
+	outputHealth      []*targetHealth
+	async             *AsyncOptions
+	closed            bool
+	origin            *Log // log the outputs belong to, nil unless a clone
 	logLock           sync.Mutex
 	tags              []*Tag
+	formatBuffer      []byte
+	redactor          *Redactor
 }
 
 // New returns a new instance of a log object
@@ -67,12 +84,17 @@ func New() *Log {
 		bufferedMessages:  make([]*Entry, DefaultBacklogDepth),
 		outputTargets:     make([]io.Writer, 0),
 		outputFormatters:  make([]LogFormatter, 0),
+		outputLevels:      make([]LogLevel, 0),
+		outputFilters:     make([]*Filter, 0),
 		tags:              make([]*Tag, 0),
 	}
 }
 
-// Clone returns a clone of the given log allowing for the cascading of tags
-// this will need some thought
+// Clone returns a clone of the given log allowing for the cascading of tags.
+// The clone shares the outputs, queues included, of the log and their
+// lifecycle: closing the log closes them for the clone too, entries logged
+// on the clone afterwards are discarded, and Close on the clone returns
+// ErrCloneClose.
 func (l *Log) Clone() *Log {
 	newLog := New()
 
@@ -82,7 +104,18 @@ func (l *Log) Clone() *Log {
 	newLog.compactDuplicates = l.compactDuplicates
 	newLog.outputTargets = append(newLog.outputTargets, l.outputTargets...)
 	newLog.outputFormatters = append(newLog.outputFormatters, l.outputFormatters...)
+	newLog.outputLevels = append(newLog.outputLevels, l.outputLevels...)
+	newLog.outputFilters = append(newLog.outputFilters, l.outputFilters...)
+	newLog.outputSyncs = append(newLog.outputSyncs, l.outputSyncs...)
+	newLog.outputQueues = append(newLog.outputQueues, l.outputQueues...)
+	newLog.outputHealth = append(newLog.outputHealth, l.outputHealth...)
+	newLog.async = l.async
 	newLog.tags = append(newLog.tags, l.tags...)
+	newLog.redactor = l.redactor
+	newLog.origin = l
+	if l.origin != nil {
+		newLog.origin = l.origin
+	}
 
 	return newLog
 }
@@ -167,11 +200,26 @@ func (l *Log) AddOutputTargetAndFormatter(writer io.Writer, formatter LogFormatt
 	l.logLock.Lock()
 	defer l.logLock.Unlock()
 
+	index := len(l.outputTargets)
+	err := writeHeader(writer, formatter)
+	if err != nil {
+		reportError(&TargetError{Index: index, Err: fmt.Errorf("failed to write header: %w", err)})
+	}
+
 	l.outputTargets = append(l.outputTargets, writer)
 	l.outputFormatters = append(l.outputFormatters, formatter)
+	l.outputLevels = append(l.outputLevels, Trace)
+	l.outputFilters = append(l.outputFilters, nil)
+	l.outputSyncs = append(l.outputSyncs, nil)
+	health := newTargetHealth(index, writer)
+	l.outputHealth = append(l.outputHealth, health)
+	var queue *asyncQueue
+	if l.async != nil {
+		queue = newAsyncQueue(health, *l.async)
+	}
+	l.outputQueues = append(l.outputQueues, queue)
 
-	// index is 1 less than length
-	return len(l.outputTargets) - 1
+	return index
 }
 
 // SetOutputFormatter sets a specific output formatter for the given output target
@@ -198,6 +246,265 @@ func (l *Log) GetOutputFormatter(index int) (*LogFormatter, error) {
 	return &l.outputFormatters[index], nil
 }
 
+// SetOutputLevel sets the minimum level the given output target receives,
+// entries must also be at or above the log level to be output
+func (l *Log) SetOutputLevel(index int, level LogLevel) error {
+	l.logLock.Lock()
+	defer l.logLock.Unlock()
+
+	if index < 0 || index >= len(l.outputTargets) {
+		return fmt.Errorf("bad output level index")
+	}
+	if level < Trace || level > Fatal {
+		return fmt.Errorf("output level is out of range: %d", level)
+	}
+	l.outputLevels[index] = level
+	return nil
+}
+
+// SetOutputFilter sets the filter for the given output target,
+// nil removes any filter
+func (l *Log) SetOutputFilter(index int, filter *Filter) error {
+	l.logLock.Lock()
+	defer l.logLock.Unlock()
+
+	if index < 0 || index >= len(l.outputTargets) {
+		return fmt.Errorf("bad output filter index")
+	}
+	l.outputFilters[index] = filter
+	return nil
+}
+
+// SetOutputSyncPolicy sets when the given output target is synced to stable
+// storage, targets that cannot be synced ignore the policy
+func (l *Log) SetOutputSyncPolicy(index int, policy SyncPolicy) error {
+	l.logLock.Lock()
+	defer l.logLock.Unlock()
+
+	if index < 0 || index >= len(l.outputTargets) {
+		return fmt.Errorf("bad output sync index")
+	}
+	targetSync, err := newTargetSync(l.outputTargets[index], policy)
+	if err != nil {
+		return err
+	}
+	l.outputSyncs[index] = targetSync
+	return nil
+}
+
+// Sync commits every output target that supports syncing, such as files,
+// to stable storage.  With asynchronous output the queue of each target is
+// drained first, so entries logged before Sync are synced.
+func (l *Log) Sync() error {
+	l.logLock.Lock()
+	targets := append([]io.Writer(nil), l.outputTargets...)
+	queues := append([]*asyncQueue(nil), l.outputQueues...)
+	l.logLock.Unlock()
+
+	var errs []error
+	for index, target := range targets {
+		if queues[index] != nil {
+			// the context never ends, so waiting can not fail
+			queues[index].wait(context.Background(), false) //nolint:errcheck
+		}
+		if syncer := syncerOf(target); syncer != nil {
+			if err := syncer.Sync(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SetAsync makes output asynchronous: formatted entries are queued for each
+// target, current and future, and written out in batches by a goroutine per
+// target so a slow target no longer stalls logging.  Flush or Close drain
+// the queues, for instance before exiting.
+func (l *Log) SetAsync(options AsyncOptions) error {
+	l.logLock.Lock()
+	defer l.logLock.Unlock()
+
+	if l.async != nil {
+		return fmt.Errorf("output is already asynchronous")
+	}
+	options, err := options.withDefaults()
+	if err != nil {
+		return err
+	}
+	l.async = &options
+	for index, health := range l.outputHealth {
+		l.outputQueues[index] = newAsyncQueue(health, options)
+	}
+	return nil
+}
+
+// SetOutputRetryPolicy sets how failed writes to the given output target
+// are retried and when the target is disabled
+func (l *Log) SetOutputRetryPolicy(index int, policy RetryPolicy) error {
+	l.logLock.Lock()
+	defer l.logLock.Unlock()
+
+	if index < 0 || index >= len(l.outputTargets) {
+		return fmt.Errorf("bad output retry index")
+	}
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	l.outputHealth[index].setPolicy(policy)
+	return nil
+}
+
+// GetOutputHealth returns the health of the given output target
+func (l *Log) GetOutputHealth(index int) (TargetHealth, error) {
+	l.logLock.Lock()
+	defer l.logLock.Unlock()
+
+	if index < 0 || index >= len(l.outputTargets) {
+		return TargetHealth{}, fmt.Errorf("bad output health index")
+	}
+	return l.outputHealth[index].state(), nil
+}
+
+// GetOutputDropped returns the number of entries the given output target
+// dropped because its asynchronous queue was full
+func (l *Log) GetOutputDropped(index int) (uint64, error) {
+	l.logLock.Lock()
+	defer l.logLock.Unlock()
+
+	if index < 0 || index >= len(l.outputTargets) {
+		return 0, fmt.Errorf("bad output dropped index")
+	}
+	if l.outputQueues[index] == nil {
+		return 0, nil
+	}
+	return l.outputQueues[index].droppedEntries(), nil
+}
+
+// Flush waits until every queued entry was written, or until ctx is done
+func (l *Log) Flush(ctx context.Context) error {
+	l.logLock.Lock()
+	queues := append([]*asyncQueue(nil), l.outputQueues...)
+	l.logLock.Unlock()
+
+	for _, queue := range queues {
+		if queue == nil {
+			continue
+		}
+		if err := queue.wait(ctx, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close drains the queues, stops their goroutines and closes every target
+// implementing io.Closer except standard output and error.  Entries logged
+// afterwards are discarded.  If ctx is done first the remaining entries are
+// dropped, the targets are left open and the context's error is returned.
+func (l *Log) Close(ctx context.Context) error {
+	if l.origin != nil {
+		return ErrCloneClose
+	}
+	l.logLock.Lock()
+	if l.closed {
+		l.logLock.Unlock()
+		return nil
+	}
+	l.closed = true
+	targets := append([]io.Writer(nil), l.outputTargets...)
+	queues := append([]*asyncQueue(nil), l.outputQueues...)
+	l.logLock.Unlock()
+
+	for _, queue := range queues {
+		if queue != nil {
+			queue.close()
+		}
+	}
+	for _, queue := range queues {
+		if queue == nil {
+			continue
+		}
+		if err := queue.wait(ctx, true); err != nil {
+			for _, queue := range queues {
+				if queue != nil {
+					queue.discard()
+				}
+			}
+			return err
+		}
+	}
+
+	var errs []error
+	for _, target := range targets {
+		if closer := closerOf(target); closer != nil {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// isClosed returns whether the log was closed, false for a nil log
+func (l *Log) isClosed() bool {
+	if l == nil {
+		return false
+	}
+	l.logLock.Lock()
+	defer l.logLock.Unlock()
+
+	return l.closed
+}
+
+// closerOf returns the io.Closer of writer, nil if it cannot be closed or
+// is standard output or error
+func closerOf(writer io.Writer) io.Closer {
+	if writer == os.Stdout || writer == os.Stderr {
+		return nil
+	}
+	closer, _ := writer.(io.Closer)
+	return closer
+}
+
+// SetRedactor sets the redactor applied to every entry before it is
+// buffered or output, nil disables redaction
+func (l *Log) SetRedactor(redactor *Redactor) {
+	l.logLock.Lock()
+	defer l.logLock.Unlock()
+
+	l.redactor = redactor
+}
+
+// ReopenAll reopens every output target that supports reopening, such
+// as files, after an external tool like logrotate moved them
+func (l *Log) ReopenAll() error {
+	l.logLock.Lock()
+	defer l.logLock.Unlock()
+
+	var errs []error
+	for _, target := range l.outputTargets {
+		if reopener, ok := target.(Reopener); ok {
+			if err := reopener.Reopen(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// OnRotate registers callback on every output target that rotates, it
+// is called with the path of each finished backup
+func (l *Log) OnRotate(callback RotationCallback) {
+	l.logLock.Lock()
+	defer l.logLock.Unlock()
+
+	for _, target := range l.outputTargets {
+		for _, rw := range rotatingWritersOf(target) {
+			rw.OnRotate(callback)
+		}
+	}
+}
+
 // AddTag adds a give tag to a logger
 func (l *Log) AddTag(name string, value interface{}) {
 	l.tags = append(l.tags, CreateTag(name, value))
@@ -208,7 +515,10 @@ func (l *Log) Log(level LogLevel, message string) {
 	l.logLock.Lock()
 	defer l.logLock.Unlock()
 
-	logEntry := NewEntry(level, time.Now(), message, l.tags)
+	if l.closed || l.origin.isClosed() {
+		return
+	}
+	logEntry := l.redactor.Redact(NewEntry(level, time.Now(), message, l.tags))
 
 	// buffer everything
 	l.buffer(logEntry)
@@ -221,12 +531,11 @@ func (l *Log) Log(level LogLevel, message string) {
 			l.dumpBuffer()
 		} else {
 			// output information
-			for index, logger := range l.outputTargets {
-				logMessage := l.outputFormatters[index].Format(logEntry)
-				_, err := logger.Write(logMessage)
-				if err != nil {
-					fmt.Printf("failed to write to logger: %d", index)
+			for index := range l.outputTargets {
+				if !l.targetAccepts(index, logEntry) {
+					continue
 				}
+				l.writeTarget(index, logEntry)
 			}
 		}
 	}
@@ -330,6 +639,16 @@ func convertLevelToString(level LogLevel, capitalize bool) (string, error) {
 	return stringLevel, nil
 }
 
+// parseLevel converts a level name, failing on unknown names where
+// convertStringToLevel falls back to Error
+func parseLevel(level string) (LogLevel, error) {
+	switch strings.ToLower(level) {
+	case LogLevelTrace, LogLevelDebug, LogLevelInformation, LogLevelWarning, LogLevelError, LogLevelFatal:
+		return convertStringToLevel(level), nil
+	}
+	return Error, fmt.Errorf("unknown level: %v", level)
+}
+
 func convertStringToLevel(level string) LogLevel {
 	switch strings.ToLower(level) {
 	case LogLevelTrace:
@@ -357,27 +676,56 @@ func (l *Log) flushLastLog() {
 		l.addBufferEntry(l.lastLog)
 	case l.duplicateCount == 1:
 		l.addBufferEntry(l.lastLog)
-		l.addBufferEntry(NewEntry(l.lastLog.level, l.lastLog.timestamp, l.lastLog.message, l.tags))
+		l.addBufferEntry(NewEntry(l.lastLog.level, l.lastLog.timestamp, l.lastLog.message, l.lastLog.tags))
 	default:
 		l.addBufferEntry(NewEntry(
 			l.lastLog.level,
 			l.lastLog.timestamp,
 			fmt.Sprintf("%s (x%d)", l.lastLog.message, l.duplicateCount+1),
-			l.tags,
+			l.lastLog.tags,
 		))
 	}
 	l.duplicateCount = 0
 	l.lastLog = nil
 }
 
+// targetAccepts returns true if the indexed target's level
+// and filter allow the entry
+func (l *Log) targetAccepts(index int, entry *Entry) bool {
+	return entry.level >= l.outputLevels[index] && l.outputFilters[index].Match(entry)
+}
+
+// formatEntry formats the entry for the indexed target, formatters
+// able to append into the shared buffer avoid allocating.  The result
+// is only valid until the next call.
+func (l *Log) formatEntry(index int, entry *Entry) []byte {
+	appendFormatter, ok := l.outputFormatters[index].(AppendFormatter)
+	if !ok {
+		return l.outputFormatters[index].Format(entry)
+	}
+	l.formatBuffer = appendFormatter.AppendFormat(l.formatBuffer[:0], entry)
+	return l.formatBuffer
+}
+
+// writeTarget formats and writes the entry to the indexed target, through
+// its queue if output is asynchronous
+func (l *Log) writeTarget(index int, entry *Entry) {
+	logMessage := l.formatEntry(index, entry)
+	if queue := l.outputQueues[index]; queue != nil {
+		queue.enqueue(asyncItem{data: append([]byte(nil), logMessage...), level: entry.level, sync: l.outputSyncs[index]})
+		return
+	}
+	l.outputHealth[index].write(logMessage, 1)
+	l.outputSyncs[index].written(entry.level)
+}
+
 func (l *Log) dumpBufferRange(entries []*Entry) {
 	for _, entry := range entries {
-		for index, logger := range l.outputTargets {
-			logMessage := l.outputFormatters[index].Format(entry)
-			_, err := logger.Write(logMessage)
-			if err != nil {
-				fmt.Printf("failed to write to log index: %d", index)
+		for index := range l.outputTargets {
+			if !l.targetAccepts(index, entry) {
+				continue
 			}
+			l.writeTarget(index, entry)
 		}
 	}
 }
@@ -403,6 +751,15 @@ func (l *Log) dumpBuffer() {
 		l.firstEntry = 0
 		l.nextEntry = 0
 	}
+
+	// the dump is what matters most after a crash
+	for index, targetSync := range l.outputSyncs {
+		if queue := l.outputQueues[index]; queue != nil {
+			queue.enqueue(asyncItem{sync: targetSync, dump: true})
+		} else {
+			targetSync.dumped()
+		}
+	}
 }
 
 func (l *Log) addBufferEntry(logEntry *Entry) {
@@ -0,0 +1,209 @@
+// Package pflog defines all of the pflog package
+package pflog
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// RedactionStrategy is how a redacted value is replaced
+type RedactionStrategy int
+
+const (
+	// RedactReplace replaces the value with a fixed replacement
+	RedactReplace RedactionStrategy = iota
+	// RedactHash replaces the value with a (keyed) sha256 digest so
+	// equal values can still be correlated
+	RedactHash
+	// RedactPartial masks all but the last few characters
+	RedactPartial
+)
+
+// DefaultRedactionReplacement is used by RedactReplace when none is given
+const DefaultRedactionReplacement = "[REDACTED]"
+
+// DefaultRedactionKeep is the number of characters RedactPartial leaves visible
+const DefaultRedactionKeep = 4
+
+// redactionHashLength is the number of hex digits of the digest kept
+const redactionHashLength = 16
+
+// Built in patterns for common secrets and personal data
+var (
+	RedactCreditCard  = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+	RedactEmail       = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	RedactBearerToken = regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*`)
+)
+
+var builtinRedactionPatterns = map[string]*regexp.Regexp{
+	"credit_card": RedactCreditCard,
+	"email":       RedactEmail,
+	"bearer":      RedactBearerToken,
+}
+
+// RedactionRule redacts the values of the named tags and any part of
+// a message matching the pattern
+type RedactionRule struct {
+	Tags        []string       // tag names, compared case insensitively
+	Pattern     *regexp.Regexp // applied to messages and string tag values
+	Strategy    RedactionStrategy
+	Replacement string // for RedactReplace, defaults to DefaultRedactionReplacement
+	Keep        int    // for RedactPartial, defaults to DefaultRedactionKeep
+	Key         []byte // for RedactHash, optional HMAC key
+}
+
+// Redactor applies redaction rules to entries before they are
+// buffered or formatted, so neither live output nor backlog dumps
+// contain the redacted values
+type Redactor struct {
+	rules []*RedactionRule
+}
+
+// NewRedactor returns a redactor applying the rules in order
+func NewRedactor(rules ...*RedactionRule) *Redactor {
+	return &Redactor{rules: rules}
+}
+
+// Redact returns the entry with all rules applied, the entry itself
+// is returned if nothing needed redacting
+func (r *Redactor) Redact(entry *Entry) *Entry {
+	if r == nil || len(r.rules) == 0 {
+		return entry
+	}
+
+	message := entry.message
+	for _, rule := range r.rules {
+		message = rule.redactText(message)
+	}
+
+	var tags []*Tag
+	for index, v := range entry.tags {
+		value := r.redactTag(v)
+		if value == v && tags == nil {
+			continue
+		}
+		if tags == nil {
+			tags = make([]*Tag, len(entry.tags))
+			copy(tags, entry.tags[:index])
+		}
+		tags[index] = value
+	}
+
+	if tags == nil && message == entry.message {
+		return entry
+	}
+	if tags == nil {
+		tags = entry.tags
+	}
+	return NewEntry(entry.level, entry.timestamp, message, tags)
+}
+
+// redactTag returns the tag with rules applied, or the same tag if unchanged
+func (r *Redactor) redactTag(tag *Tag) *Tag {
+	for _, rule := range r.rules {
+		if rule.matchesTag(tag.name) {
+			return CreateTag(tag.name, rule.redactValue(fmt.Sprintf("%v", tag.value)))
+		}
+	}
+	text, ok := tag.value.(string)
+	if !ok {
+		return tag
+	}
+	redacted := text
+	for _, rule := range r.rules {
+		redacted = rule.redactText(redacted)
+	}
+	if redacted == text {
+		return tag
+	}
+	return CreateTag(tag.name, redacted)
+}
+
+func (rr *RedactionRule) matchesTag(name string) bool {
+	for _, tagName := range rr.Tags {
+		if strings.EqualFold(tagName, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rr *RedactionRule) redactText(text string) string {
+	if rr.Pattern == nil {
+		return text
+	}
+	return rr.Pattern.ReplaceAllStringFunc(text, rr.redactValue)
+}
+
+// redactValue applies the strategy to a single value
+func (rr *RedactionRule) redactValue(value string) string {
+	switch rr.Strategy {
+	case RedactHash:
+		var digest []byte
+		if len(rr.Key) > 0 {
+			mac := hmac.New(sha256.New, rr.Key)
+			mac.Write([]byte(value))
+			digest = mac.Sum(nil)
+		} else {
+			sum := sha256.Sum256([]byte(value))
+			digest = sum[:]
+		}
+		return "sha256:" + hex.EncodeToString(digest)[:redactionHashLength]
+	case RedactPartial:
+		keep := rr.Keep
+		if keep <= 0 {
+			keep = DefaultRedactionKeep
+		}
+		length := utf8.RuneCountInString(value)
+		if length <= keep {
+			return strings.Repeat("*", length)
+		}
+		runes := []rune(value)
+		return strings.Repeat("*", length-keep) + string(runes[length-keep:])
+	}
+	if rr.Replacement == "" {
+		return DefaultRedactionReplacement
+	}
+	return rr.Replacement
+}
+
+// convertStringToRedactionStrategy converts a configuration value to a strategy
+func convertStringToRedactionStrategy(strategy string) (RedactionStrategy, error) {
+	switch strings.ToLower(strategy) {
+	case "", "replace":
+		return RedactReplace, nil
+	case "hash":
+		return RedactHash, nil
+	case "partial":
+		return RedactPartial, nil
+	}
+	return RedactReplace, fmt.Errorf("unknown redaction strategy: %v", strategy)
+}
+
+// redactionPattern returns a built in pattern by name or compiles
+// pattern as a regular expression
+func redactionPattern(builtin string, pattern string) (*regexp.Regexp, error) {
+	if builtin != "" && pattern != "" {
+		return nil, fmt.Errorf("redaction rule has both a builtin and a pattern")
+	}
+	if builtin != "" {
+		compiled, exists := builtinRedactionPatterns[strings.ToLower(builtin)]
+		if !exists {
+			return nil, fmt.Errorf("unknown builtin redaction pattern: %v", builtin)
+		}
+		return compiled, nil
+	}
+	if pattern == "" {
+		return nil, nil
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("bad redaction pattern: %w", err)
+	}
+	return compiled, nil
+}
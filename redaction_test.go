@@ -0,0 +1,101 @@
+package pflog
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type RedactionTestSuite struct {
+	suite.Suite
+}
+
+func (suite *RedactionTestSuite) TestStrategies() {
+	redactor := NewRedactor(
+		&RedactionRule{Tags: []string{"password"}},
+		&RedactionRule{Tags: []string{"Authorization"}, Strategy: RedactHash},
+		&RedactionRule{Pattern: RedactCreditCard, Strategy: RedactPartial},
+		&RedactionRule{Pattern: RedactEmail, Replacement: "<email>"},
+		&RedactionRule{Pattern: RedactBearerToken},
+	)
+	tags := []*Tag{
+		CreateTag("PASSWORD", "hunter2"),
+		CreateTag("authorization", "secret"),
+		CreateTag("contact", "bob@example.com"),
+		CreateTag("count", 3),
+	}
+	entry := NewEntry(Error, time.Now(), "card 4111 1111 1111 1111 for bob@example.com with Bearer abc.def", tags)
+
+	redacted := redactor.Redact(entry)
+
+	suite.Assert().Equal("card ***************1111 for <email> with [REDACTED]", redacted.message)
+	suite.Assert().Equal("[REDACTED]", redacted.tags[0].value)
+	suite.Assert().Regexp("^sha256:[0-9a-f]{16}$", redacted.tags[1].value)
+	suite.Assert().Equal("<email>", redacted.tags[2].value)
+	suite.Assert().Same(tags[3], redacted.tags[3])
+	// the original entry is left untouched
+	suite.Assert().Equal("hunter2", tags[0].value)
+
+	clean := NewEntry(Error, time.Now(), "nothing here", nil)
+	suite.Assert().Same(clean, redactor.Redact(clean))
+}
+
+func (suite *RedactionTestSuite) TestHashKey() {
+	plain := (&RedactionRule{Strategy: RedactHash}).redactValue("value")
+	keyed := (&RedactionRule{Strategy: RedactHash, Key: []byte("key")}).redactValue("value")
+
+	suite.Assert().NotEqual(plain, keyed)
+	suite.Assert().Equal(keyed, (&RedactionRule{Strategy: RedactHash, Key: []byte("key")}).redactValue("value"))
+}
+
+func (suite *RedactionTestSuite) TestLiveAndBacklog() {
+	log := New()
+	var buffer bytes.Buffer
+	_ = log.AddOutputTarget(&buffer)
+	log.SetRedactor(NewRedactor(&RedactionRule{Tags: []string{"token"}}, &RedactionRule{Pattern: RedactEmail}))
+	log.AddTag("token", "abc123")
+
+	// below the log level, only reaches output via the backlog dump
+	log.Debug("mail bob@example.com")
+	log.Debug("mail bob@example.com")
+	log.Error("failed for alice@example.com")
+	log.Fatal("dump")
+
+	suite.Assert().NotContains(buffer.String(), "abc123")
+	suite.Assert().NotContains(buffer.String(), "@example.com")
+	suite.Assert().Contains(buffer.String(), "mail [REDACTED]")
+}
+
+func (suite *RedactionTestSuite) TestConfiguration() {
+	configuration := Configuration{
+		Settings: Settings{Level: "Information", TriggerLevel: "Fatal", Backlog: 10, Redaction: []RedactionEntry{
+			{Tags: []string{"password"}},
+			{Builtin: "email", Strategy: "partial", Keep: 2},
+		}},
+		Formatters: []FormatterEntry{{ID: "text", Filename: filepath.Join(suite.T().TempDir(), "out.log")}},
+	}
+	suite.Require().Nil(configuration.LoadConfiguration())
+	suite.Assert().Equal(2, len(configuration.GetLogger().redactor.rules))
+
+	configuration.Settings.Redaction = []RedactionEntry{{Builtin: "phone"}}
+	suite.Assert().NotNil(configuration.LoadConfiguration())
+	configuration.Settings.Redaction = []RedactionEntry{{Pattern: "(", Strategy: "replace"}}
+	suite.Assert().NotNil(configuration.LoadConfiguration())
+	configuration.Settings.Redaction = []RedactionEntry{{Tags: []string{"x"}, Strategy: "shred"}}
+	suite.Assert().NotNil(configuration.LoadConfiguration())
+
+	// a hash key that resolves to nothing must not silently leave hashing unkeyed
+	configuration.Settings.Redaction = []RedactionEntry{{Tags: []string{"x"}, Strategy: "hash", KeyEnv: "PFLOG_TEST_REDACTION_KEY"}}
+	suite.T().Setenv("PFLOG_TEST_REDACTION_KEY", "")
+	suite.Assert().NotNil(configuration.LoadConfiguration())
+	suite.T().Setenv("PFLOG_TEST_REDACTION_KEY", "secret")
+	suite.Require().Nil(configuration.LoadConfiguration())
+	suite.Assert().Equal([]byte("secret"), configuration.GetLogger().redactor.rules[0].Key)
+}
+
+func TestRedactionTestSuite(t *testing.T) {
+	suite.Run(t, new(RedactionTestSuite))
+}
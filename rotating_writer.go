@@ -1,39 +1,105 @@
 package pflog
 
 import (
-	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // RotatingWriter is an io.Writer that writes to a named file and rotates it
 // when the file would exceed maxSize bytes. Rotated files are renamed with a
-// UTC timestamp suffix (e.g. app.log.20060102-150405) so they sort in
-// chronological order.
+// UTC timestamp suffix (e.g. app.log.20060102-150405.000) so they sort in
+// chronological order, a counter is appended in the unlikely case the name is
+// taken (app.log.20060102-150405.000_1).  SetBackupNaming selects local time
+// or logrotate style numbering (app.log.1 being the newest) instead.
 //
 // When compress is true, all backup files except the most recently rotated one
 // are gzip-compressed (app.log.20060101-120000.gz). This mirrors logrotate's
 // behaviour: the newest backup stays plain for quick inspection; older ones are
-// compressed on the next rotation cycle.
+// compressed on the next rotation cycle.  Other codecs, such as zstd, are set
+// with SetCompression; backups of any registered codec are recognised so a
+// directory of mixed backups is retained and pruned as one.
+//
+// Compression, encryption, pruning and numbering of backups run on a
+// background worker so they never stall writers; Close waits for any pending work.  Callbacks
+// registered with OnRotate run on the same worker once a backup is finished,
+// and SetCurrentLink maintains a symlink such as app-current.log to the file.
 //
 // If maxBackups is non-zero, the oldest backup files (compressed or plain)
-// beyond that count are deleted automatically.
+// beyond that count are deleted automatically.  Backups can also be retained
+// by age and by their total size, see SetRetention.
+//
+// With encryption set every backup, including the newest, is encrypted
+// with AES-256-GCM after any compression (app.log.20060101-120000.gz.enc),
+// and the live file can be encrypted too as one frame per write.
+//
+// With a schedule the file also rotates at the start of every period and
+// backups are named after the period they cover (app.log.20060102 for
+// daily), with a -150405 suffix of the start time for any further files
+// of a period started by size rotation.
 type RotatingWriter struct {
-	filename   string
-	maxSize    int64
-	maxBackups int
+	filename    string
+	maxSize     int64
+	maxBackups  int
+	maxAge      time.Duration
+	maxTotal    int64
+	pruned      time.Time     // when retention was last evaluated
+	retention   *time.Timer   // evaluates retention while nothing is written
+	retainEvery time.Duration // how often retention is evaluated
+	checked     time.Time     // when the file was last checked for being moved
+	compress    bool
+	codec       CompressionCodec
+	level       int // codec specific, or DefaultCompressionLevel
+	header      []byte
+	encryptKey  []byte
+	encryptLive bool
+	encrypter   *frameEncrypter // non-nil while the live file is encrypted
+	schedule    RotationSchedule
+	period      time.Time // start of the period of the current file
+	part        time.Time // start of the current file if it is not the first of its period
+	next        time.Time // when the next scheduled rotation is due
+	clock       func() time.Time
+	file        *os.File
+	size        int64
+	naming      BackupNaming
+	numbered    atomic.Bool // naming is BackupNumbered, read by the worker
+	localTime   bool
+	currentLink string
+	callbacks   []RotationCallback
+	rotated     []string // stems of backups awaiting callbacks
+	closed      bool
+	maintenance chan struct{} // holds a pending pass over the backups
+	pending     sync.WaitGroup
+	mu          sync.Mutex
+}
+
+// backupPolicy is a snapshot of how backups are compressed, encrypted and
+// retained, taken by the background worker for each pass
+type backupPolicy struct {
 	compress   bool
-	file       *os.File
-	size       int64
-	mu         sync.Mutex
+	codec      CompressionCodec
+	level      int
+	encryptKey []byte
+	maxBackups int
+	maxAge     time.Duration
+	maxTotal   int64
+	now        time.Time
 }
 
+// RotationCallback is called with the path of each backup after a rotation,
+// once any encryption of it is done.  The newest backup is never compressed,
+// so the path is its name until the next rotation compresses or renames it.
+// Numbered backups are reported by their number once numbered, each backup
+// once even when several rotations are numbered in one pass.
+type RotationCallback func(backup string) error
+
 // newRotatingWriter opens (or creates) filename in append mode and returns a
 // RotatingWriter. maxSizeBytes == 0 disables rotation.
 func newRotatingWriter(filename string, maxSizeBytes int64, maxBackups int, compress bool) (*RotatingWriter, error) {
@@ -47,45 +113,518 @@ func newRotatingWriter(filename string, maxSizeBytes int64, maxBackups int, comp
 		return nil, err
 	}
 	return &RotatingWriter{
-		filename:   filename,
-		maxSize:    maxSizeBytes,
-		maxBackups: maxBackups,
-		compress:   compress,
-		file:       f,
-		size:       info.Size(),
+		filename:    filename,
+		maxSize:     maxSizeBytes,
+		maxBackups:  maxBackups,
+		compress:    compress,
+		codec:       gzipCodec{},
+		level:       DefaultCompressionLevel,
+		clock:       time.Now,
+		file:        f,
+		size:        info.Size(),
+		retainEvery: retentionInterval,
 	}, nil
 }
 
-// Write implements io.Writer. It rotates the backing file before writing if the
-// write would push the file past maxSize. Rotation failures are reported to
-// stderr but do not drop the log message.
+// SetBackupNaming sets how backups are named, with timestamps in local
+// time rather than UTC if localTime is true
+func (rw *RotatingWriter) SetBackupNaming(naming BackupNaming, localTime bool) error {
+	if naming != BackupTimestamp && naming != BackupNumbered {
+		return fmt.Errorf("unknown backup naming: %d", naming)
+	}
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	rw.naming = naming
+	rw.numbered.Store(naming == BackupNumbered)
+	rw.localTime = localTime
+	return nil
+}
+
+// SetCompression compresses backups with codec, a codec without a suffix
+// disables compression
+func (rw *RotatingWriter) SetCompression(codec CompressionCodec) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	rw.codec = codec
+	rw.compress = codec.Suffix() != ""
+}
+
+// SetCompressionLevel sets the codec specific level used to compress
+// backups, DefaultCompressionLevel is the codec's default
+// (gzip.BestCompression for gzip)
+func (rw *RotatingWriter) SetCompressionLevel(level int) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	writer, err := rw.codec.NewWriter(io.Discard, level)
+	if err != nil {
+		return fmt.Errorf("invalid compression level: %d: %w", level, err)
+	}
+	writer.Close() //nolint:errcheck
+	rw.level = level
+	return nil
+}
+
+// Close waits for pending compression, encryption and pruning of backups
+// and closes the current file.
+func (rw *RotatingWriter) Close() error {
+	rw.mu.Lock()
+	if rw.closed {
+		rw.mu.Unlock()
+		return nil
+	}
+	rw.closed = true
+	err := rw.file.Close()
+	if rw.retention != nil {
+		rw.retention.Stop()
+	}
+	if rw.maintenance != nil {
+		close(rw.maintenance)
+	}
+	rw.mu.Unlock()
+
+	rw.pending.Wait()
+	return err
+}
+
+// Sync commits the file to stable storage
+func (rw *RotatingWriter) Sync() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.closed {
+		return os.ErrClosed
+	}
+	return rw.file.Sync()
+}
+
+// rotatingWritersOf returns the RotatingWriters writer is or wraps
+func rotatingWritersOf(writer io.Writer) []*RotatingWriter {
+	switch wrapper := writer.(type) {
+	case *RotatingWriter:
+		return []*RotatingWriter{wrapper}
+	case interface{ Unwrap() io.Writer }:
+		return rotatingWritersOf(wrapper.Unwrap())
+	case interface{ Unwrap() []io.Writer }:
+		var writers []*RotatingWriter
+		for _, wrapped := range wrapper.Unwrap() {
+			writers = append(writers, rotatingWritersOf(wrapped)...)
+		}
+		return writers
+	}
+	return nil
+}
+
+// OnRotate registers a callback run after every rotation with the path of
+// the finished backup, for instance to upload or index it.  Callbacks run on
+// the background worker, never on the write path, and errors they return are
+// reported through pflog's internal error handler.
+func (rw *RotatingWriter) OnRotate(callback RotationCallback) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	rw.callbacks = append(rw.callbacks, callback)
+}
+
+// SetCurrentLink maintains a symbolic link named link pointing at the file,
+// relative if both are in the same directory.  The link is replaced
+// atomically and repaired after every rotation and reopen.
+func (rw *RotatingWriter) SetCurrentLink(link string) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	rw.currentLink = link
+	return rw.updateCurrentLink()
+}
+
+// updateCurrentLink makes the current link point at the file if it does not
+func (rw *RotatingWriter) updateCurrentLink() error {
+	if rw.currentLink == "" {
+		return nil
+	}
+	target, err := filepath.Abs(rw.filename)
+	if err != nil {
+		return err
+	}
+	linkDirectory, err := filepath.Abs(filepath.Dir(rw.currentLink))
+	if err != nil {
+		return err
+	}
+	if relative, relErr := filepath.Rel(linkDirectory, target); relErr == nil && !strings.HasPrefix(relative, "..") {
+		target = relative
+	}
+	if existing, readErr := os.Readlink(rw.currentLink); readErr == nil && existing == target {
+		return nil
+	}
+	temporary := rw.currentLink + ".tmp"
+	os.Remove(temporary) //nolint:errcheck
+	if err := os.Symlink(target, temporary); err != nil {
+		return fmt.Errorf("current link: %w", err)
+	}
+	if err := os.Rename(temporary, rw.currentLink); err != nil {
+		os.Remove(temporary) //nolint:errcheck
+		return fmt.Errorf("current link: %w", err)
+	}
+	return nil
+}
+
+// SetHeader sets a header that is written at the start of every file,
+// including the current one if it is still empty.
+func (rw *RotatingWriter) SetHeader(header []byte) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	rw.header = append([]byte(nil), header...)
+	if rw.empty() {
+		return rw.writeHeader()
+	}
+	return nil
+}
+
+// SetSchedule sets when the file rotates in addition to any maximum
+// size.  A non-empty file is taken to cover the period it was last
+// written in, so it rotates on the first write after a restart in a
+// later period.
+func (rw *RotatingWriter) SetSchedule(schedule RotationSchedule) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if schedule.Every < RotateNever || schedule.Every > RotateWeekly {
+		return fmt.Errorf("unknown rotation period: %d", schedule.Every)
+	}
+	rw.schedule = schedule
+	if schedule.Every == RotateNever {
+		return nil
+	}
+	covers := rw.clock()
+	if !rw.empty() {
+		info, err := rw.file.Stat()
+		if err != nil {
+			return err
+		}
+		covers = info.ModTime().In(covers.Location())
+	}
+	rw.startPeriod(covers)
+	return nil
+}
+
+// startPeriod makes the current file the first of the period containing t
+func (rw *RotatingWriter) startPeriod(t time.Time) {
+	rw.period = rw.schedule.periodStart(t)
+	rw.next = rw.schedule.next(rw.period)
+	rw.part = time.Time{}
+}
+
+// retentionInterval is how often retention is evaluated between rotations
+const retentionInterval = time.Hour
+
+// SetRetention removes backups last written more than maxAge ago and the
+// oldest backups while all of them together exceed maxTotalBytes, zero
+// disables either limit.  Retention is evaluated now, after every rotation
+// and hourly, also while nothing is written, until the writer is closed.
+func (rw *RotatingWriter) SetRetention(maxAge time.Duration, maxTotalBytes int64) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	rw.maxAge = maxAge
+	rw.maxTotal = maxTotalBytes
+	rw.scheduleMaintenance()
+	if (maxAge > 0 || maxTotalBytes > 0) && rw.retention == nil && !rw.closed {
+		rw.retention = time.AfterFunc(rw.retainEvery, rw.retentionDue)
+	}
+}
+
+// retentionDue queues a pass over the backups if retention was not
+// evaluated for an interval, so an idle log is kept within its limits
+func (rw *RotatingWriter) retentionDue() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.closed {
+		return
+	}
+	if (rw.maxAge > 0 || rw.maxTotal > 0) && rw.clock().Sub(rw.pruned) >= rw.retainEvery {
+		rw.scheduleMaintenance()
+	}
+	rw.retention.Reset(rw.retainEvery)
+}
+
+// parseRetentionAge parses a configured maximum age, a Go duration or a
+// whole number of days ("14d") or weeks ("2w"), empty is no limit
+func parseRetentionAge(age string) (time.Duration, error) {
+	if age == "" {
+		return 0, nil
+	}
+	unit := time.Duration(0)
+	switch {
+	case strings.HasSuffix(age, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(age, "w"):
+		unit = 7 * 24 * time.Hour
+	}
+	if unit != 0 {
+		count, err := strconv.Atoi(age[:len(age)-1])
+		if err != nil || count < 0 {
+			return 0, fmt.Errorf("bad max_age: %v", age)
+		}
+		return time.Duration(count) * unit, nil
+	}
+	duration, err := time.ParseDuration(age)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("bad max_age: %v", age)
+	}
+	return duration, nil
+}
+
+// SetEncryption encrypts backups with the 32 byte AES-256 key from now
+// on and, if live is true, the current file as well.  A non-empty
+// current file whose encryption does not match is rotated first so no
+// file mixes plain and encrypted data.
+func (rw *RotatingWriter) SetEncryption(key []byte, live bool) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if _, err := newEncryptionAEAD(key); err != nil {
+		return fmt.Errorf("encryption key: %w", err)
+	}
+	rw.encryptKey = append([]byte(nil), key...)
+	rw.encryptLive = live
+	return rw.resumeEncryption()
+}
+
+// resumeEncryption picks up the encryption of the current file, rotating it
+// first if it does not match, and starts encrypting an empty file if live
+func (rw *RotatingWriter) resumeEncryption() error {
+	rw.encrypter = nil
+	if rw.encryptKey == nil {
+		return nil
+	}
+	aead, err := newEncryptionAEAD(rw.encryptKey)
+	if err != nil {
+		return err
+	}
+	if encrypted, frames, stateErr := encryptedFileState(rw.filename); stateErr != nil {
+		return stateErr
+	} else if encrypted {
+		rw.encrypter = &frameEncrypter{aead: aead, frame: frames}
+		// a torn frame may have been truncated
+		info, err := rw.file.Stat()
+		if err != nil {
+			return err
+		}
+		rw.size = info.Size()
+	}
+
+	if rw.size > 0 && (rw.encrypter != nil) != rw.encryptLive {
+		return rw.rotate()
+	}
+	if rw.encryptLive && rw.size == 0 {
+		return rw.startEncryptedFile()
+	}
+	return nil
+}
+
+// Reopen closes the current file and opens the file by name again, for use
+// after an external tool such as logrotate moved it.  Writes also notice
+// within a second that the file was renamed or deleted and reopen it.
+func (rw *RotatingWriter) Reopen() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.closed {
+		return os.ErrClosed
+	}
+	return rw.reopen()
+}
+
+func (rw *RotatingWriter) reopen() error {
+	f, err := openAppend(rw.filename)
+	if err != nil {
+		return fmt.Errorf("reopen: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("reopen: %w", err)
+	}
+	rw.file.Close() //nolint:errcheck
+	rw.file = f
+	rw.size = info.Size()
+	if err := rw.updateCurrentLink(); err != nil {
+		reportError(err)
+	}
+	if err := rw.resumeEncryption(); err != nil {
+		return fmt.Errorf("reopen: %w", err)
+	}
+	if rw.empty() {
+		return rw.writeHeader()
+	}
+	return nil
+}
+
+// encryptedFileState returns whether filename is encrypted and if so
+// how many frames it holds, truncating a torn final frame so the file
+// can be appended to
+func encryptedFileState(filename string) (bool, uint64, error) {
+	file, err := os.Open(filepath.Clean(filename))
+	if err != nil {
+		return false, 0, err
+	}
+	defer file.Close()
+
+	magic := make([]byte, len(encryptionMagic))
+	if _, err := io.ReadFull(file, magic); err != nil || string(magic) != encryptionMagic {
+		return false, 0, nil
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return true, 0, err
+	}
+	frames, end, err := countFrames(file)
+	if errors.Is(err, errTornFrame) {
+		reportError(fmt.Errorf("%s: %w, truncated to the last whole frame", filename, err))
+		err = os.Truncate(filename, end)
+	}
+	return true, frames, err
+}
+
+// startEncryptedFile begins encrypting the empty current file
+func (rw *RotatingWriter) startEncryptedFile() error {
+	encrypter, err := newFrameEncrypter(rw.encryptKey)
+	if err != nil {
+		return err
+	}
+	n, err := rw.file.Write([]byte(encryptionMagic))
+	rw.size += int64(n)
+	if err != nil {
+		return err
+	}
+	rw.encrypter = encrypter
+	return nil
+}
+
+// empty returns true if nothing but the encryption magic has been written
+func (rw *RotatingWriter) empty() bool {
+	if rw.encrypter != nil {
+		return rw.size <= int64(len(encryptionMagic))
+	}
+	return rw.size == 0
+}
+
+// writeHeader writes the header, if any, to the current file
+func (rw *RotatingWriter) writeHeader() error {
+	if len(rw.header) == 0 {
+		return nil
+	}
+	_, err := rw.write(rw.header)
+	return err
+}
+
+// write writes p to the current file, as a single frame if encrypted
+func (rw *RotatingWriter) write(p []byte) (int, error) {
+	if rw.encrypter == nil {
+		n, err := rw.file.Write(p)
+		rw.size += int64(n)
+		return n, err
+	}
+	frame, err := rw.encrypter.seal(p)
+	if err != nil {
+		return 0, err
+	}
+	n, err := rw.file.Write(frame)
+	rw.size += int64(n)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Write implements io.Writer. It rotates the backing file before writing if a
+// scheduled rotation is due or the write would push a non-empty file past
+// maxSize.
+// Rotation failures are reported but do not drop the log message.
 func (rw *RotatingWriter) Write(p []byte) (int, error) {
 	rw.mu.Lock()
 	defer rw.mu.Unlock()
 
-	if rw.maxSize > 0 && rw.size+int64(len(p)) > rw.maxSize {
+	now := rw.clock()
+	if now.Sub(rw.checked) >= reopenCheckInterval {
+		rw.checked = now
+		if fileMoved(rw.file, rw.filename) {
+			if err := rw.reopen(); err != nil {
+				reportError(fmt.Errorf("%s: %w", rw.filename, err))
+			}
+		}
+	}
+	if rw.schedule.Every != RotateNever && !now.Before(rw.next) {
+		if err := rw.rotateSchedule(now); err != nil {
+			reportError(fmt.Errorf("rotation failed for %s: %w", rw.filename, err))
+		}
+	}
+
+	if rw.maxSize > 0 && rw.size+int64(len(p)) > rw.maxSize && !rw.empty() {
 		if err := rw.rotate(); err != nil {
-			fmt.Fprintf(os.Stderr, "pflog: rotation failed for %s: %v\n", rw.filename, err)
+			reportError(fmt.Errorf("rotation failed for %s: %w", rw.filename, err))
 		}
 	}
 
-	n, err := rw.file.Write(p)
-	rw.size += int64(n)
-	return n, err
+	if (rw.maxAge > 0 || rw.maxTotal > 0) && now.Sub(rw.pruned) >= rw.retainEvery {
+		rw.scheduleMaintenance()
+	}
+
+	return rw.write(p)
+}
+
+// rotateSchedule starts the period containing now, rotating the current
+// file unless nothing was written to it
+func (rw *RotatingWriter) rotateSchedule(now time.Time) error {
+	var err error
+	if !rw.empty() {
+		err = rw.rotate()
+	}
+	rw.startPeriod(now)
+	return err
 }
 
-// rotate closes the current file, moves it to a timestamped backup name, opens
-// a fresh log file, optionally compresses old backups, and prunes when
-// maxBackups is set.
+// backupName returns the name the current file is rotated to
+func (rw *RotatingWriter) backupName() string {
+	if rw.naming == BackupNumbered {
+		return rw.stagedBackupName()
+	}
+	var backup string
+	if rw.schedule.Every == RotateNever {
+		now := rw.clock()
+		if !rw.localTime {
+			now = now.UTC()
+		}
+		backup = rw.filename + "." + now.Format(backupTimestampFormat)
+	} else {
+		backup = rw.filename + "." + rw.schedule.label(rw.period)
+		if !rw.part.IsZero() {
+			backup += rw.part.Format("-150405")
+		}
+	}
+	return rw.uniqueBackupName(backup)
+}
+
+// rotate closes the current file, moves it to a backup name, opens a fresh
+// log file and queues compression, encryption and pruning of the backups.
 func (rw *RotatingWriter) rotate() error {
 	if err := rw.file.Close(); err != nil {
 		return fmt.Errorf("close: %w", err)
 	}
 
-	backup := rw.filename + "." + time.Now().UTC().Format("20060102-150405")
+	backup := rw.backupName()
+	if rw.encrypter != nil {
+		backup += encryptedSuffix
+	}
 	if err := os.Rename(rw.filename, backup); err != nil {
 		return fmt.Errorf("rename: %w", err)
 	}
+	if len(rw.callbacks) > 0 {
+		rw.rotated = append(rw.rotated, backupStem(backup))
+	}
 
 	f, err := os.OpenFile(filepath.Clean(rw.filename), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
@@ -93,58 +632,199 @@ func (rw *RotatingWriter) rotate() error {
 	}
 	rw.file = f
 	rw.size = 0
+	rw.part = rw.clock()
+	rw.encrypter = nil
+	if rw.encryptLive {
+		if err := rw.startEncryptedFile(); err != nil {
+			return fmt.Errorf("encrypt: %w", err)
+		}
+	}
+	if err := rw.writeHeader(); err != nil {
+		return fmt.Errorf("header: %w", err)
+	}
+	if err := rw.updateCurrentLink(); err != nil {
+		reportError(err)
+	}
 
-	// Compress old backups (all except the one just created) before pruning,
-	// so maxBackups counts compressed files too.
-	if rw.compress {
-		rw.compressOldBackups()
+	rw.scheduleMaintenance()
+	return nil
+}
+
+// scheduleMaintenance queues a pass over the backups for the background
+// worker, starting it if needed.  At most one pass is queued as a queued pass
+// covers every backup present when it runs.  Must be called with rw.mu held.
+func (rw *RotatingWriter) scheduleMaintenance() {
+	if rw.closed {
+		return
+	}
+	rw.pruned = rw.clock()
+	if rw.maintenance == nil {
+		rw.maintenance = make(chan struct{}, 1)
+		go rw.maintainBackups(rw.maintenance)
 	}
+	select {
+	case rw.maintenance <- struct{}{}:
+		rw.pending.Add(1)
+	default:
+	}
+}
+
+// maintainBackups is the background worker, running a pass per request
+// until the channel is closed
+func (rw *RotatingWriter) maintainBackups(requests chan struct{}) {
+	for range requests {
+		rw.mu.Lock()
+		policy := backupPolicy{
+			compress:   rw.compress,
+			codec:      rw.codec,
+			level:      rw.level,
+			encryptKey: rw.encryptKey,
+			maxBackups: rw.maxBackups,
+			maxAge:     rw.maxAge,
+			maxTotal:   rw.maxTotal,
+			now:        rw.pruned,
+		}
+		if rw.numbered.Load() {
+			if err := rw.numberStagedBackups(); err != nil {
+				reportError(fmt.Errorf("numbering backups of %s: %w", rw.filename, err))
+			}
+		}
+		rotated := rw.rotated
+		rw.rotated = nil
+		callbacks := rw.callbacks
+		rw.mu.Unlock()
 
-	if rw.maxBackups > 0 {
-		rw.pruneBackups()
+		// Compress old backups (all except the newest) before pruning,
+		// so maxBackups and maxTotal count compressed files too.
+		if policy.compress {
+			rw.compressOldBackups(&policy)
+		}
+		if policy.encryptKey != nil {
+			rw.encryptBackups(&policy)
+		}
+		rw.pruneBackups(&policy)
+
+		rw.runCallbacks(callbacks, rotated)
+		rw.pending.Done()
 	}
-	return nil
+}
+
+// runCallbacks calls every callback for each finished backup, given by
+// the stem of its name, that has not been pruned
+func (rw *RotatingWriter) runCallbacks(callbacks []RotationCallback, rotated []string) {
+	if len(rotated) == 0 {
+		return
+	}
+	paths := make(map[string]string)
+	for _, path := range rw.allBackups() {
+		paths[backupStem(path)] = path
+	}
+	for _, stem := range rotated {
+		path, exists := paths[stem]
+		if !exists {
+			continue
+		}
+		for _, callback := range callbacks {
+			if err := callback(path); err != nil {
+				reportError(fmt.Errorf("rotation callback for %s: %w", path, err))
+			}
+		}
+	}
+}
+
+// waitForBackups waits until no pass over the backups is pending
+func (rw *RotatingWriter) waitForBackups() {
+	rw.pending.Wait()
 }
 
 // compressOldBackups gzip-compresses every plain backup file except the newest
 // one (which was just created by rotate and should stay readable for quick
-// inspection). Already-compressed files are left alone.
-func (rw *RotatingWriter) compressOldBackups() {
+// inspection). Already-compressed files are left alone.  When backups are
+// encrypted the newest is compressed too, as it will not stay readable.
+func (rw *RotatingWriter) compressOldBackups(policy *backupPolicy) {
 	plain := rw.findBackups(false)
-	sort.Strings(plain) // oldest first; timestamp suffix sorts lexicographically
+	sortBackups(filepath.Base(rw.filename), plain) // oldest first
 
-	// Leave the newest plain backup uncompressed.
-	if len(plain) <= 1 {
-		return
+	toCompress := plain
+	if policy.encryptKey == nil {
+		// Leave the newest plain backup uncompressed.
+		if len(plain) <= 1 {
+			return
+		}
+		toCompress = plain[:len(plain)-1]
 	}
-	toCompress := plain[:len(plain)-1]
 	for _, path := range toCompress {
-		if err := compressFile(path); err != nil {
-			fmt.Fprintf(os.Stderr, "pflog: compress %s: %v\n", path, err)
+		if err := compressFile(path, policy.codec, policy.level); err != nil {
+			reportError(fmt.Errorf("compress %s: %w", path, err))
 		}
 	}
 }
 
-// pruneBackups removes the oldest backup files (plain and .gz counted together)
-// so that at most maxBackups remain.
-func (rw *RotatingWriter) pruneBackups() {
-	all := append(rw.findBackups(false), rw.findBackups(true)...)
-	sort.Strings(all) // oldest first
+// encryptBackups encrypts every plain and compressed backup file.
+// Already-encrypted files are left alone.
+func (rw *RotatingWriter) encryptBackups(policy *backupPolicy) {
+	for _, path := range append(rw.findBackups(false), rw.findBackups(true)...) {
+		if err := encryptFile(path, policy.encryptKey); err != nil {
+			reportError(fmt.Errorf("encrypt %s: %w", path, err))
+		}
+	}
+}
+
+// pruneBackups removes the oldest backup files (plain, .gz and .enc counted
+// together) so that at most maxBackups remain, those older than maxAge and
+// the oldest while their total size exceeds maxTotal.
+func (rw *RotatingWriter) pruneBackups(policy *backupPolicy) {
+	if policy.maxBackups <= 0 && policy.maxAge <= 0 && policy.maxTotal <= 0 {
+		return
+	}
+
+	all := rw.allBackups()
+	sortBackups(filepath.Base(rw.filename), all) // oldest first
 
-	for len(all) > rw.maxBackups {
-		if err := os.Remove(all[0]); err != nil {
-			fmt.Fprintf(os.Stderr, "pflog: prune %s: %v\n", all[0], err)
+	remove := make([]bool, len(all))
+	if policy.maxBackups > 0 {
+		for index := 0; index < len(all)-policy.maxBackups; index++ {
+			remove[index] = true
+		}
+	}
+	if policy.maxAge > 0 || policy.maxTotal > 0 {
+		var total int64
+		for index := len(all) - 1; index >= 0; index-- {
+			if remove[index] {
+				continue
+			}
+			info, err := os.Stat(all[index])
+			if err != nil {
+				continue
+			}
+			if policy.maxAge > 0 && policy.now.Sub(info.ModTime()) > policy.maxAge {
+				remove[index] = true
+				continue
+			}
+			total += info.Size()
+			if policy.maxTotal > 0 && total > policy.maxTotal {
+				remove[index] = true
+			}
+		}
+	}
+
+	for index, path := range all {
+		if !remove[index] {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			reportError(fmt.Errorf("prune %s: %w", path, err))
 		}
-		all = all[1:]
 	}
 }
 
 // findBackups returns absolute paths for backup files in the same directory as
-// filename. If compressed is true, only .gz files are returned; otherwise only
-// plain (non-.gz) files are returned.
+// filename. If compressed is true, only files compressed by any registered
+// codec (.gz, .zst) are returned; otherwise only plain files are returned.
+// Encrypted (.enc) files are neither.
 func (rw *RotatingWriter) findBackups(compressed bool) []string {
 	dir := filepath.Dir(rw.filename)
-	prefix := filepath.Base(rw.filename) + "."
+	base := filepath.Base(rw.filename)
 
 	entries, err := os.ReadDir(dir)
 	if err != nil {
@@ -157,32 +837,83 @@ func (rw *RotatingWriter) findBackups(compressed bool) []string {
 			continue
 		}
 		name := e.Name()
-		if !strings.HasPrefix(name, prefix) {
+		if _, ok := backupStamp(base, name, rw.numbered.Load()); !ok || strings.HasSuffix(name, encryptedSuffix) {
 			continue
 		}
-		isGz := strings.HasSuffix(name, ".gz")
-		if isGz == compressed {
+		isCompressed := compressionSuffix(name) != ""
+		if isCompressed == compressed {
+			out = append(out, filepath.Join(dir, name))
+		}
+	}
+	return out
+}
+
+// findEncryptedBackups returns absolute paths for encrypted (.enc) backup
+// files in the same directory as filename.
+func (rw *RotatingWriter) findEncryptedBackups() []string {
+	dir := filepath.Dir(rw.filename)
+	base := filepath.Base(rw.filename)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var out []string
+	for _, e := range entries {
+		name := e.Name()
+		if _, ok := backupStamp(base, name, rw.numbered.Load()); ok && !e.IsDir() && strings.HasSuffix(name, encryptedSuffix) {
 			out = append(out, filepath.Join(dir, name))
 		}
 	}
 	return out
 }
 
-// compressFile gzip-compresses src to src+".gz" and removes src on success.
-func compressFile(src string) error {
+// allBackups returns the plain, compressed and encrypted backups
+func (rw *RotatingWriter) allBackups() []string {
+	all := append(rw.findBackups(false), rw.findBackups(true)...)
+	return append(all, rw.findEncryptedBackups()...)
+}
+
+// backupFiles returns the plain, compressed and encrypted backups of
+// filename, oldest first.  Numbered backups are only included if the
+// newest of them, filename.1, or one not numbered yet exists so an unrelated file such as
+// app.log.2024 is not taken for a backup.
+func backupFiles(filename string) []string {
+	rw := &RotatingWriter{filename: filename}
+	rw.numbered.Store(true)
+	all := rw.allBackups()
+	base := filepath.Base(filename)
+	numbered := false
+	for _, path := range all {
+		if number, _, ok := backupNumber(base, filepath.Base(path)); ok && number <= 1 {
+			numbered = true
+		}
+	}
+	if !numbered {
+		rw.numbered.Store(false)
+		all = rw.allBackups()
+	}
+	sortBackups(base, all)
+	return all
+}
+
+// compressFile compresses src with codec to src plus the codec's suffix at
+// level and removes src on success.
+func compressFile(src string, codec CompressionCodec, level int) error {
 	in, err := os.Open(src)
 	if err != nil {
 		return err
 	}
 	defer in.Close()
 
-	gzPath := src + ".gz"
+	gzPath := src + codec.Suffix()
 	out, err := os.Create(gzPath)
 	if err != nil {
 		return err
 	}
 
-	gz, err := gzip.NewWriterLevel(out, gzip.BestCompression)
+	gz, err := codec.NewWriter(out, level)
 	if err != nil {
 		out.Close()
 		os.Remove(gzPath) //nolint:errcheck
@@ -206,5 +937,14 @@ func compressFile(src string) error {
 		return outCloseErr
 	}
 
+	keepModTime(in, gzPath)
 	return os.Remove(src)
 }
+
+// keepModTime gives path the modification time of the file it was made
+// from, so retention by age sees when the backup was last written
+func keepModTime(from *os.File, path string) {
+	if info, err := from.Stat(); err == nil {
+		os.Chtimes(path, info.ModTime(), info.ModTime()) //nolint:errcheck
+	}
+}
@@ -0,0 +1,486 @@
+package pflog
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type RotatingWriterTestSuite struct {
+	suite.Suite
+	now time.Time
+}
+
+func (suite *RotatingWriterTestSuite) clock() time.Time {
+	return suite.now
+}
+
+// newWriter returns a writer for a new file using the suite's clock
+func (suite *RotatingWriterTestSuite) newWriter(maxSize int64, schedule RotationSchedule) (*RotatingWriter, string) {
+	filename := filepath.Join(suite.T().TempDir(), "app.log")
+	writer, err := newRotatingWriter(filename, maxSize, 0, false)
+	suite.Require().Nil(err)
+	writer.clock = suite.clock
+	suite.Require().Nil(writer.SetSchedule(schedule))
+	return writer, filename
+}
+
+func (suite *RotatingWriterTestSuite) write(writer *RotatingWriter, text string) {
+	_, err := writer.Write([]byte(text))
+	suite.Require().Nil(err)
+}
+
+func (suite *RotatingWriterTestSuite) backups(writer *RotatingWriter) []string {
+	writer.waitForBackups()
+	var names []string
+	for _, path := range backupFiles(writer.filename) {
+		names = append(names, filepath.Base(path))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (suite *RotatingWriterTestSuite) TestParseSchedule() {
+	schedule, err := ParseRotationSchedule("daily", "02:30")
+	suite.Require().Nil(err)
+	suite.Assert().Equal(RotationSchedule{Every: RotateDaily, Hour: 2, Minute: 30}, schedule)
+
+	schedule, err = ParseRotationSchedule("weekly", "sun 23:00")
+	suite.Require().Nil(err)
+	suite.Assert().Equal(RotationSchedule{Every: RotateWeekly, Weekday: time.Sunday, Hour: 23}, schedule)
+
+	schedule, err = ParseRotationSchedule("weekly", "")
+	suite.Require().Nil(err)
+	suite.Assert().Equal(RotationSchedule{Every: RotateWeekly, Weekday: time.Monday}, schedule)
+
+	schedule, err = ParseRotationSchedule("hourly", ":15")
+	suite.Require().Nil(err)
+	suite.Assert().Equal(RotationSchedule{Every: RotateHourly, Minute: 15}, schedule)
+
+	schedule, err = ParseRotationSchedule("", "")
+	suite.Require().Nil(err)
+	suite.Assert().Equal(RotateNever, schedule.Every)
+
+	for _, bad := range [][2]string{{"monthly", ""}, {"daily", "25:00"}, {"daily", "mon 02:00"}, {"weekly", "someday 02:00"}, {"", "02:00"}} {
+		_, err = ParseRotationSchedule(bad[0], bad[1])
+		suite.Assert().NotNil(err, bad)
+	}
+}
+
+func (suite *RotatingWriterTestSuite) TestPeriods() {
+	at := time.Date(2024, 3, 6, 1, 10, 0, 0, time.UTC) // a Wednesday
+
+	daily := RotationSchedule{Every: RotateDaily, Hour: 2}
+	suite.Assert().Equal(time.Date(2024, 3, 5, 2, 0, 0, 0, time.UTC), daily.periodStart(at))
+	suite.Assert().Equal(time.Date(2024, 3, 6, 2, 0, 0, 0, time.UTC), daily.next(daily.periodStart(at)))
+
+	hourly := RotationSchedule{Every: RotateHourly, Minute: 30}
+	suite.Assert().Equal(time.Date(2024, 3, 6, 0, 30, 0, 0, time.UTC), hourly.periodStart(at))
+
+	weekly := RotationSchedule{Every: RotateWeekly, Weekday: time.Monday}
+	suite.Assert().Equal(time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), weekly.periodStart(at))
+	suite.Assert().Equal(time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC), weekly.next(weekly.periodStart(at)))
+	weekly.Weekday = time.Wednesday
+	weekly.Hour = 3
+	suite.Assert().Equal(time.Date(2024, 2, 28, 3, 0, 0, 0, time.UTC), weekly.periodStart(at))
+}
+
+func (suite *RotatingWriterTestSuite) TestDaily() {
+	suite.now = time.Date(2024, 3, 6, 10, 0, 0, 0, time.UTC)
+	writer, _ := suite.newWriter(0, RotationSchedule{Every: RotateDaily})
+	suite.write(writer, "wednesday\n")
+
+	suite.now = suite.now.Add(13 * time.Hour)
+	suite.write(writer, "still wednesday\n")
+	suite.Assert().Empty(suite.backups(writer))
+
+	suite.now = suite.now.Add(time.Hour)
+	suite.write(writer, "thursday\n")
+	suite.now = suite.now.Add(48 * time.Hour)
+	suite.write(writer, "saturday\n")
+
+	suite.Assert().Equal([]string{"app.log.20240306", "app.log.20240307"}, suite.backups(writer))
+	contents, err := os.ReadFile(filepath.Join(filepath.Dir(writer.filename), "app.log.20240306"))
+	suite.Require().Nil(err)
+	suite.Assert().Equal("wednesday\nstill wednesday\n", string(contents))
+}
+
+func (suite *RotatingWriterTestSuite) TestHourlyWithSize() {
+	suite.now = time.Date(2024, 3, 6, 10, 5, 0, 0, time.UTC)
+	writer, _ := suite.newWriter(20, RotationSchedule{Every: RotateHourly})
+	suite.write(writer, "first of the hour\n")
+	suite.now = suite.now.Add(10 * time.Minute)
+	suite.write(writer, "too big for the file\n")
+	suite.now = suite.now.Add(time.Hour)
+	suite.write(writer, "next hour\n")
+
+	suite.Assert().Equal([]string{"app.log.20240306-10", "app.log.20240306-10-101500"}, suite.backups(writer))
+}
+
+func (suite *RotatingWriterTestSuite) TestRestartInLaterPeriod() {
+	suite.now = time.Date(2024, 3, 6, 10, 0, 0, 0, time.UTC)
+	writer, filename := suite.newWriter(0, RotationSchedule{Every: RotateDaily})
+	suite.write(writer, "yesterday\n")
+	yesterday := time.Date(2024, 3, 5, 12, 0, 0, 0, time.UTC)
+	suite.Require().Nil(os.Chtimes(filename, yesterday, yesterday))
+
+	writer, err := newRotatingWriter(filename, 0, 0, false)
+	suite.Require().Nil(err)
+	writer.clock = suite.clock
+	suite.Require().Nil(writer.SetSchedule(RotationSchedule{Every: RotateDaily}))
+	suite.write(writer, "today\n")
+
+	suite.Assert().Equal([]string{"app.log.20240305"}, suite.backups(writer))
+}
+
+func (suite *RotatingWriterTestSuite) TestEmptyPeriodNotRotated() {
+	suite.now = time.Date(2024, 3, 6, 10, 0, 0, 0, time.UTC)
+	writer, _ := suite.newWriter(0, RotationSchedule{Every: RotateDaily})
+	suite.now = suite.now.Add(72 * time.Hour)
+	suite.write(writer, "first\n")
+	suite.Assert().Empty(suite.backups(writer))
+}
+
+// backupAt creates a backup of the writer's file last written at modified
+func (suite *RotatingWriterTestSuite) backupAt(writer *RotatingWriter, suffix string, size int, modified time.Time) {
+	path := writer.filename + "." + suffix
+	suite.Require().Nil(os.WriteFile(path, make([]byte, size), 0600))
+	suite.Require().Nil(os.Chtimes(path, modified, modified))
+}
+
+func (suite *RotatingWriterTestSuite) TestParseRetentionAge() {
+	for text, expected := range map[string]time.Duration{"": 0, "14d": 14 * 24 * time.Hour, "2w": 14 * 24 * time.Hour, "36h": 36 * time.Hour} {
+		age, err := parseRetentionAge(text)
+		suite.Require().Nil(err)
+		suite.Assert().Equal(expected, age, text)
+	}
+	for _, bad := range []string{"d", "-1d", "fortnight", "-1h"} {
+		_, err := parseRetentionAge(bad)
+		suite.Assert().NotNil(err, bad)
+	}
+}
+
+func (suite *RotatingWriterTestSuite) TestRetentionByAge() {
+	suite.now = time.Date(2024, 3, 20, 12, 0, 0, 0, time.UTC)
+	writer, _ := suite.newWriter(0, RotationSchedule{})
+	suite.backupAt(writer, "20240301", 10, suite.now.Add(-19*24*time.Hour))
+	suite.backupAt(writer, "20240310.gz", 10, suite.now.Add(-10*24*time.Hour))
+	suite.backupAt(writer, "20240319", 10, suite.now.Add(-24*time.Hour))
+
+	writer.SetRetention(14*24*time.Hour, 0)
+	suite.Assert().Equal([]string{"app.log.20240310.gz", "app.log.20240319"}, suite.backups(writer))
+
+	// evaluated again on a write once the interval has passed
+	suite.now = suite.now.Add(5 * 24 * time.Hour)
+	suite.write(writer, "later\n")
+	suite.Assert().Equal([]string{"app.log.20240319"}, suite.backups(writer))
+}
+
+func (suite *RotatingWriterTestSuite) TestRetentionWhileIdle() {
+	suite.now = time.Date(2024, 3, 20, 12, 0, 0, 0, time.UTC)
+	writer, _ := suite.newWriter(0, RotationSchedule{})
+	var mu sync.Mutex
+	now := suite.now
+	writer.clock = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	writer.retainEvery = 10 * time.Millisecond
+	suite.backupAt(writer, "20240310", 10, suite.now.Add(-10*24*time.Hour))
+	suite.backupAt(writer, "20240319", 10, suite.now.Add(-24*time.Hour))
+	writer.SetRetention(14*24*time.Hour, 0)
+	suite.Assert().Len(suite.backups(writer), 2)
+
+	// evaluated without any write
+	mu.Lock()
+	now = now.Add(5 * 24 * time.Hour)
+	mu.Unlock()
+	suite.Require().Eventually(func() bool {
+		return len(backupFiles(writer.filename)) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	suite.Require().Nil(writer.Close())
+	suite.Assert().False(writer.retention.Stop())
+}
+
+func (suite *RotatingWriterTestSuite) TestRetentionByTotalSize() {
+	suite.now = time.Date(2024, 3, 20, 12, 0, 0, 0, time.UTC)
+	writer, _ := suite.newWriter(0, RotationSchedule{})
+	suite.backupAt(writer, "20240317", 400, suite.now)
+	suite.backupAt(writer, "20240318", 400, suite.now)
+	suite.backupAt(writer, "20240319", 400, suite.now)
+
+	writer.SetRetention(0, 1000)
+	suite.Assert().Equal([]string{"app.log.20240318", "app.log.20240319"}, suite.backups(writer))
+}
+
+func (suite *RotatingWriterTestSuite) TestRetentionAfterRotation() {
+	suite.now = time.Date(2024, 3, 6, 10, 0, 0, 0, time.UTC)
+	writer, _ := suite.newWriter(0, RotationSchedule{Every: RotateDaily})
+	writer.SetRetention(0, 15)
+	for day := 0; day < 4; day++ {
+		suite.write(writer, "a day of logs\n")
+		suite.now = suite.now.Add(24 * time.Hour)
+	}
+	suite.write(writer, "today\n")
+	suite.Assert().Equal([]string{"app.log.20240309"}, suite.backups(writer))
+}
+
+func (suite *RotatingWriterTestSuite) TestCloseWaitsForCompression() {
+	suite.now = time.Date(2024, 3, 6, 10, 0, 0, 0, time.UTC)
+	filename := filepath.Join(suite.T().TempDir(), "app.log")
+	writer, err := newRotatingWriter(filename, 0, 0, true)
+	suite.Require().Nil(err)
+	writer.clock = suite.clock
+	suite.Require().Nil(writer.SetCompressionLevel(gzip.BestSpeed))
+	suite.Require().Nil(writer.SetSchedule(RotationSchedule{Every: RotateDaily}))
+	suite.Assert().NotNil(writer.SetCompressionLevel(10))
+
+	for day := 0; day < 3; day++ {
+		suite.write(writer, strings.Repeat("a day of logs\n", 1000))
+		suite.now = suite.now.Add(24 * time.Hour)
+	}
+	suite.write(writer, "today\n")
+	suite.Require().Nil(writer.Close())
+	suite.Require().Nil(writer.Close())
+
+	var names []string
+	for _, path := range backupFiles(filename) {
+		names = append(names, filepath.Base(path))
+	}
+	suite.Assert().Equal([]string{"app.log.20240306.gz", "app.log.20240307.gz", "app.log.20240308"}, names)
+
+	_, err = writer.Write([]byte("after close\n"))
+	suite.Assert().NotNil(err)
+}
+
+func (suite *RotatingWriterTestSuite) TestSameInstantRotations() {
+	suite.now = time.Date(2024, 3, 6, 10, 0, 0, 0, time.UTC)
+	writer, _ := suite.newWriter(10, RotationSchedule{})
+	for _, line := range []string{"first file\n", "second file\n", "third file\n", "fourth file\n"} {
+		suite.write(writer, line)
+	}
+	suite.Assert().Equal([]string{
+		"app.log.20240306-100000.000",
+		"app.log.20240306-100000.000_1",
+		"app.log.20240306-100000.000_2",
+	}, suite.backups(writer))
+}
+
+func (suite *RotatingWriterTestSuite) TestLocalTimeNaming() {
+	location := time.FixedZone("test", 2*60*60)
+	suite.now = time.Date(2024, 3, 6, 10, 0, 0, 500000000, location)
+	writer, _ := suite.newWriter(10, RotationSchedule{})
+	suite.Require().Nil(writer.SetBackupNaming(BackupTimestamp, true))
+	suite.write(writer, "first file\n")
+	suite.write(writer, "second file\n")
+	suite.Assert().Equal([]string{"app.log.20240306-100000.500"}, suite.backups(writer))
+}
+
+func (suite *RotatingWriterTestSuite) TestNumberedNaming() {
+	suite.now = time.Date(2024, 3, 6, 10, 0, 0, 0, time.UTC)
+	filename := filepath.Join(suite.T().TempDir(), "app.log")
+	writer, err := newRotatingWriter(filename, 10, 3, true)
+	suite.Require().Nil(err)
+	writer.clock = suite.clock
+	suite.Require().Nil(writer.SetBackupNaming(BackupNumbered, false))
+	suite.Assert().NotNil(writer.SetBackupNaming(BackupNaming(5), false))
+
+	for index := 0; index < 6; index++ {
+		suite.write(writer, fmt.Sprintf("file number %d\n", index))
+		writer.waitForBackups()
+	}
+	suite.Assert().Equal([]string{"app.log.1", "app.log.2.gz", "app.log.3.gz"}, suite.backups(writer))
+
+	contents, err := os.ReadFile(filename + ".1")
+	suite.Require().Nil(err)
+	suite.Assert().Equal("file number 4\n", string(contents))
+	oldest := filepath.Base(backupFiles(filename)[0])
+	suite.Assert().Equal("app.log.3.gz", oldest)
+}
+
+// blockingCodec is gzip waiting for release before compressing
+type blockingCodec struct {
+	gzipCodec
+	compressing chan struct{}
+	release     chan struct{}
+}
+
+func (codec blockingCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	select {
+	case codec.compressing <- struct{}{}:
+	default:
+	}
+	<-codec.release
+	return codec.gzipCodec.NewWriter(w, level)
+}
+
+func (suite *RotatingWriterTestSuite) TestNumberedRotationDuringCompression() {
+	filename := filepath.Join(suite.T().TempDir(), "app.log")
+	writer, err := newRotatingWriter(filename, 10, 0, true)
+	suite.Require().Nil(err)
+	suite.Require().Nil(writer.SetBackupNaming(BackupNumbered, false))
+	codec := blockingCodec{compressing: make(chan struct{}, 1), release: make(chan struct{})}
+	writer.SetCompression(codec)
+
+	suite.write(writer, "file number 0\n")
+	suite.write(writer, "file number 1\n")
+	writer.waitForBackups()
+	suite.write(writer, "file number 2\n")
+	<-codec.compressing
+
+	// writers rotate while the worker is compressing
+	suite.write(writer, "file number 3\n")
+	suite.write(writer, "file number 4\n")
+	close(codec.release)
+	suite.Assert().Equal([]string{"app.log.1", "app.log.2.gz", "app.log.3.gz", "app.log.4.gz"}, suite.backups(writer))
+	contents, err := os.ReadFile(filename + ".1")
+	suite.Require().Nil(err)
+	suite.Assert().Equal("file number 3\n", string(contents))
+	reader, err := OpenLogFile(filename+".4.gz", nil)
+	suite.Require().Nil(err)
+	defer reader.Close()
+	contents, err = io.ReadAll(reader)
+	suite.Require().Nil(err)
+	suite.Assert().Equal("file number 0\n", string(contents))
+	suite.Require().Nil(writer.Close())
+}
+
+func (suite *RotatingWriterTestSuite) TestStrictBackupMatching() {
+	for name, expected := range map[string]bool{
+		"app.log.20240306-100000":           true,
+		"app.log.20240306-100000.000_2.gz":  true,
+		"app.log.20240306-10.zst.enc":       true,
+		"app.log.20240306":                  true,
+		"app.log.12":                        false,
+		"app.log.12.gz":                     false,
+		"app.log.old":                       false,
+		"app.log.swp":                       false,
+		"app.log.2024":                      false,
+		"app.log.20240306.bak":              false,
+		"app.logger.20240306":               false,
+		"app.log.20240306-100000.tar":       false,
+		"app.log.20240306-100000.gz.backup": false,
+	} {
+		_, ok := backupStamp("app.log", name, false)
+		suite.Assert().Equal(expected, ok, name)
+	}
+	// logrotate style numbers are only backups with numbered naming
+	for _, name := range []string{"app.log.12", "app.log.12.gz", "app.log.1999999", "app.log.0_3.enc"} {
+		_, ok := backupStamp("app.log", name, true)
+		suite.Assert().True(ok, name)
+	}
+
+	// without app.log.1 a numbered name is not taken for a backup of a log
+	directory := suite.T().TempDir()
+	filename := filepath.Join(directory, "app.log")
+	for _, name := range []string{"app.log.2024", "app.log.20240306"} {
+		suite.Require().Nil(os.WriteFile(filepath.Join(directory, name), nil, 0600))
+	}
+	suite.Assert().Equal([]string{filename + ".20240306"}, backupFiles(filename))
+	suite.Require().Nil(os.WriteFile(filename+".1", nil, 0600))
+	suite.Assert().Len(backupFiles(filename), 3)
+
+	paths := []string{"d/app.log.0_2", "d/app.log.2.gz", "d/app.log.20240306", "d/app.log.0", "d/app.log.10", "d/app.log.1", "d/app.log.20240305"}
+	sortBackups("app.log", paths)
+	suite.Assert().Equal([]string{"d/app.log.20240305", "d/app.log.20240306", "d/app.log.10", "d/app.log.2.gz", "d/app.log.1", "d/app.log.0", "d/app.log.0_2"}, paths)
+}
+
+func (suite *RotatingWriterTestSuite) TestCurrentLink() {
+	suite.now = time.Date(2024, 3, 6, 10, 0, 0, 0, time.UTC)
+	writer, filename := suite.newWriter(10, RotationSchedule{})
+	link := filepath.Join(filepath.Dir(filename), "app-current.log")
+	suite.Require().Nil(os.WriteFile(link, []byte("stale\n"), 0600))
+	suite.Require().Nil(writer.SetCurrentLink(link))
+	target, err := os.Readlink(link)
+	suite.Require().Nil(err)
+	suite.Assert().Equal("app.log", target)
+
+	suite.write(writer, "first file\n")
+	suite.write(writer, "second file\n")
+	suite.Require().Nil(os.Remove(link))
+	suite.write(writer, "third file\n")
+	suite.Require().Nil(writer.Close())
+
+	contents, err := os.ReadFile(link)
+	suite.Require().Nil(err)
+	suite.Assert().Equal("third file\n", string(contents))
+	suite.Assert().NoFileExists(link + ".tmp")
+}
+
+func (suite *RotatingWriterTestSuite) TestRotationCallbacks() {
+	suite.now = time.Date(2024, 3, 6, 10, 0, 0, 0, time.UTC)
+	filename := filepath.Join(suite.T().TempDir(), "app.log")
+	rotating, err := newRotatingWriter(filename, 10, 0, false)
+	suite.Require().Nil(err)
+	rotating.clock = suite.clock
+	chain, err := newFileChainWriter(rotating, filename, nil, nil)
+	suite.Require().Nil(err)
+
+	log := New()
+	log.AddOutputTargetAndFormatter(chain, &TextFormatter{})
+	var rotated []string
+	log.OnRotate(func(backup string) error {
+		rotated = append(rotated, filepath.Base(backup))
+		return nil
+	})
+	log.OnRotate(func(backup string) error {
+		return fmt.Errorf("upload failed")
+	})
+	for index := 0; index < 3; index++ {
+		_, err = rotating.Write([]byte(fmt.Sprintf("file number %d\n", index)))
+		suite.Require().Nil(err)
+		suite.now = suite.now.Add(time.Second)
+	}
+	suite.Require().Nil(rotating.Close())
+	suite.Assert().Equal([]string{"app.log.20240306-100001.000", "app.log.20240306-100002.000"}, rotated)
+}
+
+func (suite *RotatingWriterTestSuite) TestNumberedRotationCallbacks() {
+	filename := filepath.Join(suite.T().TempDir(), "app.log")
+	writer, err := newRotatingWriter(filename, 10, 0, false)
+	suite.Require().Nil(err)
+	suite.Require().Nil(writer.SetBackupNaming(BackupNumbered, false))
+	calling := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var rotated []string
+	writer.OnRotate(func(backup string) error {
+		contents, err := os.ReadFile(backup)
+		rotated = append(rotated, filepath.Base(backup)+": "+string(contents))
+		select {
+		case calling <- struct{}{}:
+			<-release
+		default:
+		}
+		return err
+	})
+
+	suite.write(writer, "file number 0\n")
+	suite.write(writer, "file number 1\n")
+	<-calling
+
+	// two rotations before the worker numbers them
+	suite.write(writer, "file number 2\n")
+	suite.write(writer, "file number 3\n")
+	close(release)
+	suite.Require().Nil(writer.Close())
+	suite.Assert().Equal([]string{
+		"app.log.1: file number 0\n",
+		"app.log.2: file number 1\n",
+		"app.log.1: file number 2\n",
+	}, rotated)
+}
+
+func TestRotatingWriterTestSuite(t *testing.T) {
+	suite.Run(t, new(RotatingWriterTestSuite))
+}
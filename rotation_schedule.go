@@ -0,0 +1,146 @@
+// Package pflog defines all of the pflog package
+package pflog
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RotationPeriod is how often a RotatingWriter rotates on schedule
+type RotationPeriod int
+
+const (
+	// RotateNever disables scheduled rotation
+	RotateNever RotationPeriod = iota
+	// RotateHourly rotates every hour at Minute past the hour
+	RotateHourly
+	// RotateDaily rotates every day at Hour:Minute
+	RotateDaily
+	// RotateWeekly rotates every week on Weekday at Hour:Minute
+	RotateWeekly
+)
+
+// RotationSchedule is when a RotatingWriter rotates independently of
+// its size, in the location of the writer's clock (local time by
+// default) so daylight saving changes move with the wall clock
+type RotationSchedule struct {
+	Every   RotationPeriod
+	Weekday time.Weekday // for RotateWeekly
+	Hour    int          // for RotateDaily and RotateWeekly
+	Minute  int
+}
+
+// periodLabels name backups after the start of the period they cover
+var periodLabels = map[RotationPeriod]string{
+	RotateHourly: "20060102-15",
+	RotateDaily:  "20060102",
+	RotateWeekly: "20060102",
+}
+
+// ParseRotationSchedule parses the configuration of a schedule, every
+// is hourly, daily or weekly and at is when in the period to rotate:
+// "MM" or ":MM" for hourly, "HH:MM" for daily and "[weekday] HH:MM"
+// for weekly, where the weekday defaults to Monday.  An empty every
+// returns a schedule that never rotates.
+func ParseRotationSchedule(every string, at string) (RotationSchedule, error) {
+	var schedule RotationSchedule
+	switch strings.ToLower(every) {
+	case "", "never":
+		if at != "" {
+			return schedule, fmt.Errorf("rotate_at given without rotate_every")
+		}
+		return schedule, nil
+	case "hourly":
+		schedule.Every = RotateHourly
+	case "daily":
+		schedule.Every = RotateDaily
+	case "weekly":
+		schedule.Every = RotateWeekly
+		schedule.Weekday = time.Monday
+	default:
+		return schedule, fmt.Errorf("unknown rotation period: %v", every)
+	}
+
+	fields := strings.Fields(at)
+	if len(fields) == 2 && schedule.Every == RotateWeekly {
+		weekday, err := parseWeekday(fields[0])
+		if err != nil {
+			return schedule, err
+		}
+		schedule.Weekday = weekday
+		fields = fields[1:]
+	}
+	if len(fields) > 1 {
+		return schedule, fmt.Errorf("bad rotate_at for %s rotation: %v", every, at)
+	}
+	if len(fields) == 0 {
+		return schedule, nil
+	}
+
+	clock := fields[0]
+	if schedule.Every == RotateHourly {
+		clock = "00:" + strings.TrimPrefix(clock, ":")
+	}
+	parsed, err := time.Parse("15:04", clock)
+	if err != nil {
+		return schedule, fmt.Errorf("bad rotate_at for %s rotation: %v", every, at)
+	}
+	schedule.Hour = parsed.Hour()
+	schedule.Minute = parsed.Minute()
+	return schedule, nil
+}
+
+func parseWeekday(name string) (time.Weekday, error) {
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		full := strings.ToLower(weekday.String())
+		if strings.EqualFold(name, full) || strings.EqualFold(name, full[:3]) {
+			return weekday, nil
+		}
+	}
+	return time.Sunday, fmt.Errorf("unknown weekday: %v", name)
+}
+
+// periodStart returns the start of the period containing t
+func (schedule RotationSchedule) periodStart(t time.Time) time.Time {
+	year, month, day := t.Date()
+	var start time.Time
+	switch schedule.Every {
+	case RotateHourly:
+		start = time.Date(year, month, day, t.Hour(), schedule.Minute, 0, 0, t.Location())
+		if start.After(t) {
+			start = start.Add(-time.Hour)
+		}
+	case RotateDaily:
+		start = time.Date(year, month, day, schedule.Hour, schedule.Minute, 0, 0, t.Location())
+		if start.After(t) {
+			start = time.Date(year, month, day-1, schedule.Hour, schedule.Minute, 0, 0, t.Location())
+		}
+	case RotateWeekly:
+		back := (int(t.Weekday()) - int(schedule.Weekday) + 7) % 7
+		start = time.Date(year, month, day-back, schedule.Hour, schedule.Minute, 0, 0, t.Location())
+		if start.After(t) {
+			start = time.Date(year, month, day-back-7, schedule.Hour, schedule.Minute, 0, 0, t.Location())
+		}
+	}
+	return start
+}
+
+// next returns the start of the period after the one starting at start
+func (schedule RotationSchedule) next(start time.Time) time.Time {
+	year, month, day := start.Date()
+	switch schedule.Every {
+	case RotateHourly:
+		return start.Add(time.Hour)
+	case RotateDaily:
+		return time.Date(year, month, day+1, schedule.Hour, schedule.Minute, 0, 0, start.Location())
+	case RotateWeekly:
+		return time.Date(year, month, day+7, schedule.Hour, schedule.Minute, 0, 0, start.Location())
+	}
+	return time.Time{}
+}
+
+// label returns the backup name suffix of the period starting at start
+func (schedule RotationSchedule) label(start time.Time) string {
+	return start.Format(periodLabels[schedule.Every])
+}
@@ -0,0 +1,220 @@
+// Package pflog defines all of the pflog package
+package pflog
+
+import (
+	"fmt"
+)
+
+// Standard fields of a structured entry
+const (
+	FieldTimestamp = "timestamp"
+	FieldLevel     = "level"
+	FieldMessage   = "message"
+	FieldTags      = "tags"
+)
+
+// OmitField as a key removes the field from the output
+const OmitField = "-"
+
+// flattenedTagPrefix is prepended to flattened tags whose
+// name collides with the key of a standard field
+const flattenedTagPrefix = "tag_"
+
+var defaultFieldOrder = []string{FieldTimestamp, FieldLevel, FieldMessage, FieldTags}
+
+// StructuredLayout controls the keys written by structured formatters
+// such as json and yaml.  The zero value gives the default layout of
+// timestamp, level, message and a nested tags object.
+type StructuredLayout struct {
+	// Keys renames standard fields, a key of OmitField drops the field
+	Keys map[string]string
+	// Order lists the standard fields in output order, fields not
+	// listed are omitted, empty means the default order
+	Order []string
+	// LowercaseLevel writes level names in lower case
+	LowercaseLevel bool
+	// FlattenTags writes tags at the top level instead of under tags
+	FlattenTags bool
+}
+
+// StructuredFormatter is implemented by formatters that honour a StructuredLayout
+type StructuredFormatter interface {
+	SetLayout(layout StructuredLayout) error
+}
+
+// Validate checks that only standard fields are referenced and that
+// no two fields are written with the same key
+func (sl *StructuredLayout) Validate() error {
+	for field, key := range sl.Keys {
+		if !isStandardField(field) {
+			return fmt.Errorf("unknown field: %v", field)
+		}
+		if key == "" {
+			return fmt.Errorf("empty key for field: %v", field)
+		}
+	}
+	seen := make(map[string]bool, len(sl.Order))
+	for _, field := range sl.Order {
+		if !isStandardField(field) {
+			return fmt.Errorf("unknown field: %v", field)
+		}
+		if seen[field] {
+			return fmt.Errorf("field listed twice: %v", field)
+		}
+		seen[field] = true
+	}
+	keys := make(map[string]string)
+	for _, v := range sl.compile().fields {
+		if other, exists := keys[v.key]; exists {
+			return fmt.Errorf("fields %v and %v are both written as %v", other, v.field, v.key)
+		}
+		keys[v.key] = v.field
+	}
+	return nil
+}
+
+// layoutField is a standard field and the key it is written with
+type layoutField struct {
+	field string
+	key   string
+}
+
+// compiledLayout is a layout resolved ahead of formatting so that
+// formatters do not repeat the work for every entry
+type compiledLayout struct {
+	fields         []layoutField
+	lowercaseLevel bool
+	flattenTags    bool
+}
+
+var defaultCompiledLayout = (&StructuredLayout{}).compile()
+
+// compile resolves the fields to write in order with their keys
+func (sl *StructuredLayout) compile() *compiledLayout {
+	order := sl.Order
+	if len(order) == 0 {
+		order = defaultFieldOrder
+	}
+	compiled := &compiledLayout{
+		fields:         make([]layoutField, 0, len(order)),
+		lowercaseLevel: sl.LowercaseLevel,
+		flattenTags:    sl.FlattenTags,
+	}
+	for _, field := range order {
+		key := field
+		if renamed, ok := sl.Keys[field]; ok {
+			key = renamed
+		}
+		if key == OmitField || (field == FieldTags && sl.FlattenTags) {
+			continue
+		}
+		compiled.fields = append(compiled.fields, layoutField{field: field, key: key})
+	}
+	return compiled
+}
+
+// level returns the level name as configured
+func (cl *compiledLayout) level(level LogLevel) string {
+	if cl.lowercaseLevel {
+		levelString, err := convertLevelToString(level, false)
+		if err != nil {
+			return err.Error()
+		}
+		return levelString
+	}
+	return levelName(level)
+}
+
+// flattenedKeys returns the top level key of each tag when flattened.  A
+// tag whose name is the key of a standard field is prefixed with tag_,
+// repeatedly while that key is taken by another tag too, so no key is
+// written twice.  Tags overridden by a later one of the same name are
+// not written and get no key.
+func (cl *compiledLayout) flattenedKeys(tags []*Tag) []string {
+	taken := make(map[string]bool, len(cl.fields)+len(tags))
+	for _, v := range cl.fields {
+		taken[v.key] = true
+	}
+	keys := make([]string, len(tags))
+	var colliding []int
+	for index, v := range tags {
+		if tagOverridden(tags, index) {
+			continue
+		}
+		if taken[v.name] {
+			colliding = append(colliding, index)
+			continue
+		}
+		keys[index] = v.name
+	}
+	for _, key := range keys {
+		if key != "" {
+			taken[key] = true
+		}
+	}
+	for _, index := range colliding {
+		key := tags[index].name
+		for taken[key] {
+			key = flattenedTagPrefix + key
+		}
+		keys[index] = key
+		taken[key] = true
+	}
+	return keys
+}
+
+func isStandardField(field string) bool {
+	for _, standard := range defaultFieldOrder {
+		if field == standard {
+			return true
+		}
+	}
+	return false
+}
+
+// layoutFromOptions builds a layout from formatter options:
+//
+//	keys: map of standard field to output key ("-" omits)
+//	order: list of standard fields
+//	lowercase_level: boolean
+//	flatten_tags: boolean
+func layoutFromOptions(options map[string]interface{}) (StructuredLayout, error) {
+	var layout StructuredLayout
+	var err error
+
+	if keys, exists := options["keys"]; exists {
+		keyMap, ok := keys.(map[string]interface{})
+		if !ok {
+			if stringMap, isStringMap := keys.(map[string]string); isStringMap {
+				keyMap = make(map[string]interface{}, len(stringMap))
+				for k, v := range stringMap {
+					keyMap[k] = v
+				}
+			} else {
+				return layout, fmt.Errorf("option keys must be a map of field to key")
+			}
+		}
+		layout.Keys = make(map[string]string, len(keyMap))
+		for field, key := range keyMap {
+			keyString, isString := key.(string)
+			if !isString {
+				return layout, fmt.Errorf("option keys must be a map of field to key")
+			}
+			layout.Keys[field] = keyString
+		}
+	}
+	layout.Order, err = optionStrings(options, "order")
+	if err != nil {
+		return layout, err
+	}
+	layout.LowercaseLevel, err = optionBool(options, "lowercase_level")
+	if err != nil {
+		return layout, err
+	}
+	layout.FlattenTags, err = optionBool(options, "flatten_tags")
+	if err != nil {
+		return layout, err
+	}
+
+	return layout, layout.Validate()
+}
@@ -0,0 +1,89 @@
+package pflog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/yaml.v3"
+)
+
+type StructuredLayoutTestSuite struct {
+	suite.Suite
+}
+
+func (suite *StructuredLayoutTestSuite) testEntry() *Entry {
+	timestamp := time.Date(2021, time.June, 1, 0, 0, 0, 0, time.Local)
+	return NewEntry(Warning, timestamp, "layout", []*Tag{CreateTag("user", "bob"), CreateTag("ts", 1)})
+}
+
+func (suite *StructuredLayoutTestSuite) TestJSONRenameAndFlatten() {
+	formatter := &JSONFormatter{timeFormat: "2006"}
+	suite.Require().Nil(formatter.SetLayout(StructuredLayout{
+		Keys:           map[string]string{FieldTimestamp: "ts", FieldLevel: "severity", FieldMessage: "msg"},
+		Order:          []string{FieldLevel, FieldTimestamp, FieldMessage},
+		LowercaseLevel: true,
+		FlattenTags:    true,
+	}))
+
+	output := string(formatter.Format(suite.testEntry()))
+
+	suite.Assert().Equal(`{"severity":"warning","ts":"2021","msg":"layout","user":"bob","tag_ts":1}`+"\n", output)
+}
+
+func (suite *StructuredLayoutTestSuite) TestJSONOmit() {
+	formatter := &JSONFormatter{timeFormat: "2006"}
+	suite.Require().Nil(formatter.SetLayout(StructuredLayout{Keys: map[string]string{FieldTimestamp: OmitField, FieldTags: OmitField}}))
+
+	output := string(formatter.Format(suite.testEntry()))
+
+	suite.Assert().Equal(`{"level":"WARNING","message":"layout"}`+"\n", output)
+}
+
+func (suite *StructuredLayoutTestSuite) TestYAML() {
+	formatter, err := CreateFormatterWithOptions("yaml", map[string]interface{}{
+		"keys":         map[string]interface{}{"level": "severity"},
+		"order":        []interface{}{"message", "level", "tags"},
+		"flatten_tags": false,
+	})
+	suite.Require().Nil(err)
+
+	output := string(formatter.Format(suite.testEntry()))
+	suite.Assert().Equal("message: layout\nseverity: WARNING\ntags:\n    user: bob\n    ts: 1\n", output)
+
+	var decoded map[string]interface{}
+	suite.Require().Nil(yaml.Unmarshal([]byte(output), &decoded))
+	suite.Assert().Equal("bob", decoded["tags"].(map[string]interface{})["user"])
+}
+
+func (suite *StructuredLayoutTestSuite) TestInvalid() {
+	suite.Assert().NotNil((&JSONFormatter{}).SetLayout(StructuredLayout{Order: []string{"bogus"}}))
+	suite.Assert().NotNil((&YAMLFormatter{}).SetLayout(StructuredLayout{Keys: map[string]string{FieldLevel: ""}}))
+
+	_, err := CreateFormatterWithOptions("json", map[string]interface{}{"keys": "level"})
+	suite.Assert().NotNil(err)
+
+	// two fields written with the same key
+	suite.Assert().NotNil((&JSONFormatter{}).SetLayout(StructuredLayout{Keys: map[string]string{FieldLevel: "msg", FieldMessage: "msg"}}))
+	suite.Assert().NotNil((&YAMLFormatter{}).SetLayout(StructuredLayout{Keys: map[string]string{FieldLevel: FieldMessage}}))
+	suite.Assert().Nil((&JSONFormatter{}).SetLayout(StructuredLayout{Keys: map[string]string{FieldLevel: FieldMessage}, Order: []string{FieldLevel}}))
+}
+
+func (suite *StructuredLayoutTestSuite) TestFlattenedCollisions() {
+	entry := NewEntry(Warning, time.Now(), "layout", []*Tag{CreateTag("level", 1), CreateTag("tag_level", 2), CreateTag("user", "bob")})
+	formatter := &JSONFormatter{}
+	suite.Require().Nil(formatter.SetLayout(StructuredLayout{Order: []string{FieldLevel, FieldMessage}, FlattenTags: true}))
+
+	output := string(formatter.Format(entry))
+	suite.Assert().Equal(`{"level":"WARNING","message":"layout","tag_tag_level":1,"tag_level":2,"user":"bob"}`+"\n", output)
+
+	yamlFormatter := &YAMLFormatter{}
+	suite.Require().Nil(yamlFormatter.SetLayout(StructuredLayout{Order: []string{FieldLevel, FieldMessage}, FlattenTags: true}))
+	var decoded map[string]interface{}
+	suite.Require().Nil(yaml.Unmarshal(yamlFormatter.Format(entry), &decoded))
+	suite.Assert().Equal(map[string]interface{}{"level": "WARNING", "message": "layout", "tag_tag_level": 1, "tag_level": 2, "user": "bob"}, decoded)
+}
+
+func TestStructuredLayoutTestSuite(t *testing.T) {
+	suite.Run(t, new(StructuredLayoutTestSuite))
+}
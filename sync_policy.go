@@ -0,0 +1,150 @@
+// Package pflog defines all of the pflog package
+package pflog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncMode is when an output target is synced to stable storage
+type SyncMode int
+
+const (
+	// SyncNever leaves syncing to the operating system
+	SyncNever SyncMode = iota
+	// SyncEveryWrite syncs after every entry written
+	SyncEveryWrite
+	// SyncInterval syncs at most Interval after an entry was written
+	SyncInterval
+	// SyncAtLevel syncs after entries at or above Level
+	SyncAtLevel
+	// SyncAfterTrigger syncs only after a trigger dump
+	SyncAfterTrigger
+)
+
+// SyncPolicy is the durability policy of an output target.  Every mode
+// except SyncNever also syncs after a trigger dump, as the backlog written
+// right before a crash is the most important output.
+type SyncPolicy struct {
+	Mode     SyncMode
+	Interval time.Duration // for SyncInterval
+	Level    LogLevel      // for SyncAtLevel
+}
+
+// Syncer is implemented by writers that can commit written data to
+// stable storage, such as files
+type Syncer interface {
+	Sync() error
+}
+
+// targetSync applies a SyncPolicy to an output target
+type targetSync struct {
+	policy SyncPolicy
+	syncer Syncer
+	timer  *time.Timer // pending SyncInterval sync, nil if none
+	mu     sync.Mutex
+}
+
+// convertStringToSyncPolicy converts a configuration value to a policy:
+// never, write, trigger, a Go duration such as 100ms for an interval or a
+// level name to sync at or above that level
+func convertStringToSyncPolicy(policy string) (SyncPolicy, error) {
+	switch strings.ToLower(policy) {
+	case "", "never":
+		return SyncPolicy{Mode: SyncNever}, nil
+	case "write", "always":
+		return SyncPolicy{Mode: SyncEveryWrite}, nil
+	case "trigger":
+		return SyncPolicy{Mode: SyncAfterTrigger}, nil
+	case LogLevelTrace, LogLevelDebug, LogLevelInformation, LogLevelWarning, LogLevelError, LogLevelFatal:
+		return SyncPolicy{Mode: SyncAtLevel, Level: convertStringToLevel(policy)}, nil
+	}
+	interval, err := time.ParseDuration(policy)
+	if err != nil || interval <= 0 {
+		return SyncPolicy{}, fmt.Errorf("invalid sync policy: %v", policy)
+	}
+	return SyncPolicy{Mode: SyncInterval, Interval: interval}, nil
+}
+
+// syncerOf returns the Syncer of writer, nil if it cannot be synced.
+// Standard output and error are never synced as they are rarely files.
+func syncerOf(writer io.Writer) Syncer {
+	if writer == os.Stdout || writer == os.Stderr {
+		return nil
+	}
+	syncer, _ := writer.(Syncer)
+	return syncer
+}
+
+// newTargetSync returns the sync state of writer under policy, nil if the
+// policy never syncs or writer cannot be synced
+func newTargetSync(writer io.Writer, policy SyncPolicy) (*targetSync, error) {
+	switch policy.Mode {
+	case SyncNever:
+		return nil, nil
+	case SyncEveryWrite, SyncAfterTrigger:
+	case SyncInterval:
+		if policy.Interval <= 0 {
+			return nil, fmt.Errorf("sync interval must be positive: %v", policy.Interval)
+		}
+	case SyncAtLevel:
+		if policy.Level < Trace || policy.Level > Fatal {
+			return nil, fmt.Errorf("sync level is out of range: %d", policy.Level)
+		}
+	default:
+		return nil, fmt.Errorf("unknown sync mode: %d", policy.Mode)
+	}
+	syncer := syncerOf(writer)
+	if syncer == nil {
+		return nil, nil
+	}
+	return &targetSync{policy: policy, syncer: syncer}, nil
+}
+
+// written applies the policy after an entry at level was written
+func (ts *targetSync) written(level LogLevel) {
+	if ts == nil {
+		return
+	}
+	switch ts.policy.Mode {
+	case SyncEveryWrite:
+		ts.sync()
+	case SyncAtLevel:
+		if level >= ts.policy.Level {
+			ts.sync()
+		}
+	case SyncInterval:
+		ts.mu.Lock()
+		if ts.timer == nil {
+			ts.timer = time.AfterFunc(ts.policy.Interval, ts.sync)
+		}
+		ts.mu.Unlock()
+	}
+}
+
+// dumped syncs after a trigger dump
+func (ts *targetSync) dumped() {
+	if ts != nil {
+		ts.sync()
+	}
+}
+
+// sync syncs the target reporting any error, a sync after the target was
+// closed is not an error
+func (ts *targetSync) sync() {
+	ts.mu.Lock()
+	if ts.timer != nil {
+		ts.timer.Stop()
+		ts.timer = nil
+	}
+	ts.mu.Unlock()
+
+	if err := ts.syncer.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+		reportError(fmt.Errorf("sync: %w", err))
+	}
+}
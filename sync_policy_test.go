@@ -0,0 +1,124 @@
+package pflog
+
+import (
+	"bytes"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// syncCounter is an output target counting its syncs
+type syncCounter struct {
+	bytes.Buffer
+	syncs atomic.Int32
+}
+
+func (sc *syncCounter) Sync() error {
+	sc.syncs.Add(1)
+	return nil
+}
+
+type SyncPolicyTestSuite struct {
+	suite.Suite
+}
+
+// newLog returns a log writing everything to a counter under policy
+func (suite *SyncPolicyTestSuite) newLog(policy SyncPolicy) (*Log, *syncCounter) {
+	log := New()
+	suite.Require().Nil(log.SetLevel(Trace))
+	counter := &syncCounter{}
+	index := log.AddOutputTargetAndFormatter(counter, &TextFormatter{})
+	suite.Require().Nil(log.SetOutputSyncPolicy(index, policy))
+	return log, counter
+}
+
+func (suite *SyncPolicyTestSuite) TestConvert() {
+	for value, expected := range map[string]SyncPolicy{
+		"":        {Mode: SyncNever},
+		"never":   {Mode: SyncNever},
+		"write":   {Mode: SyncEveryWrite},
+		"Trigger": {Mode: SyncAfterTrigger},
+		"warning": {Mode: SyncAtLevel, Level: Warning},
+		"250ms":   {Mode: SyncInterval, Interval: 250 * time.Millisecond},
+	} {
+		policy, err := convertStringToSyncPolicy(value)
+		suite.Require().Nil(err, value)
+		suite.Assert().Equal(expected, policy, value)
+	}
+	for _, bad := range []string{"sometimes", "-1s", "0s"} {
+		_, err := convertStringToSyncPolicy(bad)
+		suite.Assert().NotNil(err, bad)
+	}
+}
+
+func (suite *SyncPolicyTestSuite) TestEveryWrite() {
+	log, counter := suite.newLog(SyncPolicy{Mode: SyncEveryWrite})
+	log.Information("one")
+	log.Information("two")
+	suite.Assert().Equal(int32(2), counter.syncs.Load())
+}
+
+func (suite *SyncPolicyTestSuite) TestAtLevel() {
+	log, counter := suite.newLog(SyncPolicy{Mode: SyncAtLevel, Level: Warning})
+	log.Information("one")
+	log.Warning("two")
+	log.Error("three")
+	suite.Assert().Equal(int32(2), counter.syncs.Load())
+}
+
+func (suite *SyncPolicyTestSuite) TestAfterTrigger() {
+	log, counter := suite.newLog(SyncPolicy{Mode: SyncAfterTrigger})
+	log.Information("one")
+	log.Error("two")
+	suite.Assert().Equal(int32(0), counter.syncs.Load())
+	log.Fatal("three")
+	suite.Assert().Equal(int32(1), counter.syncs.Load())
+
+	never, neverCounter := suite.newLog(SyncPolicy{Mode: SyncNever})
+	never.Fatal("three")
+	suite.Assert().Equal(int32(0), neverCounter.syncs.Load())
+}
+
+func (suite *SyncPolicyTestSuite) TestInterval() {
+	log, counter := suite.newLog(SyncPolicy{Mode: SyncInterval, Interval: 10 * time.Millisecond})
+	for index := 0; index < 5; index++ {
+		log.Informationf("entry %d", index)
+	}
+	suite.Assert().Eventually(func() bool { return counter.syncs.Load() == 1 }, time.Second, time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	suite.Assert().Equal(int32(1), counter.syncs.Load())
+}
+
+func (suite *SyncPolicyTestSuite) TestInvalidPolicy() {
+	log := New()
+	index := log.AddOutputTargetAndFormatter(&syncCounter{}, &TextFormatter{})
+	suite.Assert().NotNil(log.SetOutputSyncPolicy(index, SyncPolicy{Mode: SyncInterval}))
+	suite.Assert().NotNil(log.SetOutputSyncPolicy(index, SyncPolicy{Mode: SyncAtLevel, Level: testBadLevel}))
+	suite.Assert().NotNil(log.SetOutputSyncPolicy(index+1, SyncPolicy{Mode: SyncEveryWrite}))
+}
+
+func (suite *SyncPolicyTestSuite) TestLogSync() {
+	directory := suite.T().TempDir()
+	plain, err := NewFileWriter(filepath.Join(directory, "plain.log"))
+	suite.Require().Nil(err)
+	rotating, err := newRotatingWriter(filepath.Join(directory, "rotating.log"), 1024, 0, false)
+	suite.Require().Nil(err)
+	chain, err := newFileChainWriter(rotating, rotating.filename, nil, nil)
+	suite.Require().Nil(err)
+
+	log := New()
+	log.AddOutputTargetAndFormatter(plain, &TextFormatter{})
+	log.AddOutputTargetAndFormatter(chain, &TextFormatter{})
+	log.Error("synced")
+	suite.Assert().Nil(log.Sync())
+
+	suite.Require().Nil(rotating.Close())
+	suite.Assert().NotNil(log.Sync())
+}
+
+func TestSyncPolicyTestSuite(t *testing.T) {
+	suite.Run(t, new(SyncPolicyTestSuite))
+}
@@ -11,3 +11,13 @@ type Tag struct {
 func CreateTag(name string, value interface{}) *Tag {
 	return &Tag{name: name, value: value}
 }
+
+// Name returns the name of the tag
+func (t *Tag) Name() string {
+	return t.name
+}
+
+// Value returns the value of the tag
+func (t *Tag) Value() interface{} {
+	return t.value
+}
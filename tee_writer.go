@@ -0,0 +1,87 @@
+// Package pflog defines all of the pflog package
+package pflog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// TeeWriter writes every record to several sinks.  Unlike io.MultiWriter
+// a failing sink does not stop the others: its error is reported through
+// the error handler and Write only fails if every sink failed, so retries
+// never duplicate records on the healthy sinks.
+type TeeWriter struct {
+	writers []io.Writer
+}
+
+// NewTeeWriter returns a writer to all of writers
+func NewTeeWriter(writers ...io.Writer) *TeeWriter {
+	return &TeeWriter{writers: append([]io.Writer(nil), writers...)}
+}
+
+// Write implements io.Writer
+func (tw *TeeWriter) Write(p []byte) (int, error) {
+	var errs []error
+	for index, writer := range tw.writers {
+		if _, err := writer.Write(p); err != nil {
+			errs = append(errs, fmt.Errorf("tee %d: %w", index, err))
+		}
+	}
+	if len(errs) > 0 && len(errs) == len(tw.writers) {
+		return 0, errors.Join(errs...)
+	}
+	for _, err := range errs {
+		reportError(err)
+	}
+	return len(p), nil
+}
+
+// Unwrap returns the sinks
+func (tw *TeeWriter) Unwrap() []io.Writer {
+	return tw.writers
+}
+
+// writesRecords returns true if any sink treats every Write as one record
+func (tw *TeeWriter) writesRecords() bool {
+	for _, writer := range tw.writers {
+		if writesRecords(writer) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reopen reopens every sink that supports reopening
+func (tw *TeeWriter) Reopen() error {
+	var errs []error
+	for _, writer := range tw.writers {
+		if reopener, ok := writer.(Reopener); ok {
+			errs = append(errs, reopener.Reopen())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Sync syncs every sink that supports syncing
+func (tw *TeeWriter) Sync() error {
+	var errs []error
+	for _, writer := range tw.writers {
+		if syncer := syncerOf(writer); syncer != nil {
+			errs = append(errs, syncer.Sync())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every sink that supports closing except standard output
+// and error
+func (tw *TeeWriter) Close() error {
+	var errs []error
+	for _, writer := range tw.writers {
+		if closer := closerOf(writer); closer != nil {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,36 @@
+package pflog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type TeeWriterTestSuite struct {
+	suite.Suite
+}
+
+func (suite *TeeWriterTestSuite) SetupTest() {
+	SetErrorHandler(func(error) {})
+}
+
+func (suite *TeeWriterTestSuite) TearDownTest() {
+	SetErrorHandler(nil)
+}
+
+func (suite *TeeWriterTestSuite) TestTee() {
+	healthy := &failingWriter{}
+	failing := &failingWriter{failing: true}
+	tee := NewTeeWriter(healthy, failing)
+	n, err := tee.Write([]byte("record\n"))
+	suite.Assert().Nil(err)
+	suite.Assert().Equal(7, n)
+	suite.Assert().Equal([]string{"record\n"}, healthy.written)
+
+	_, err = NewTeeWriter(failing, failing).Write([]byte("record\n"))
+	suite.Assert().NotNil(err)
+}
+
+func TestTeeWriterTestSuite(t *testing.T) {
+	suite.Run(t, new(TeeWriterTestSuite))
+}
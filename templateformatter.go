@@ -0,0 +1,170 @@
+// Package pflog defines all of the pflog package
+package pflog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var templateformatterTypeID = "template"
+
+// DefaultTemplate mirrors the layout of the text formatter
+const DefaultTemplate = `{{timestamp .Time}} [{{level .Level}}] {{range .Tags}}{{.Name}}: {{.Value}} {{end}}{{.Message}}`
+
+// TemplateFormatter formats entries using a user supplied
+// text/template.  The template is executed against a TemplateEntry
+// and has the following helper functions available:
+//
+//	level      upper case level name i.e. ERROR
+//	padLevel   upper case level name padded to a fixed width
+//	timestamp  time formatted with the formatter's timestamp format
+//	formatTime time formatted with the given layout
+//	tag        value of the named tag or nil if not present
+//	json       JSON quoted representation of any value
+type TemplateFormatter struct {
+	timeFormat string
+	tmpl       *template.Template
+}
+
+// TemplateTag is a tag as exposed to a template
+type TemplateTag struct {
+	Name  string
+	Value interface{}
+}
+
+// TemplateEntry is the data a template is executed against
+type TemplateEntry struct {
+	Time    time.Time
+	Level   LogLevel
+	Message string
+	Tags    []TemplateTag
+}
+
+// Tag returns the value of the named tag or nil if not present
+func (te TemplateEntry) Tag(name string) interface{} {
+	for _, v := range te.Tags {
+		if v.Name == name {
+			return v.Value
+		}
+	}
+	return nil
+}
+
+// NewTemplateFormatter returns a template formatter using the given
+// template text, or an error if the template does not parse
+func NewTemplateFormatter(text string) (*TemplateFormatter, error) {
+	tf := &TemplateFormatter{}
+	err := tf.SetTemplate(text)
+	if err != nil {
+		return nil, err
+	}
+	return tf, nil
+}
+
+// newTemplateFormatter is the factory for template formatters, the
+// template option defaults to DefaultTemplate
+func newTemplateFormatter(options map[string]interface{}) (LogFormatter, error) {
+	text, err := optionString(options, "template")
+	if err != nil {
+		return nil, err
+	}
+	if text == "" {
+		text = DefaultTemplate
+	}
+	return NewTemplateFormatter(text)
+}
+
+// ID returns the specified ID of this formatter
+func (tf *TemplateFormatter) ID() string {
+	return templateformatterTypeID
+}
+
+// SetTimestampFormat sets the time stamp format
+// to the provided string representation for outputing
+// time/date information
+func (tf *TemplateFormatter) SetTimestampFormat(format string) {
+	tf.timeFormat = format
+}
+
+// SetTemplate parses and sets the template used to format entries
+func (tf *TemplateFormatter) SetTemplate(text string) error {
+	tmpl, err := template.New(templateformatterTypeID).Funcs(tf.funcs()).Parse(text)
+	if err != nil {
+		return fmt.Errorf("unable to parse template: %w", err)
+	}
+	tf.tmpl = tmpl
+	return nil
+}
+
+// Format formats a log entry by executing the template, a trailing
+// newline is added if the template does not supply one
+func (tf *TemplateFormatter) Format(entry *Entry) []byte {
+	if tf.tmpl == nil {
+		err := tf.SetTemplate(DefaultTemplate)
+		if err != nil {
+			return []byte(err.Error() + "\n")
+		}
+	}
+
+	data := TemplateEntry{
+		Time:    entry.timestamp,
+		Level:   entry.level,
+		Message: entry.message,
+		Tags:    make([]TemplateTag, 0, len(entry.tags)),
+	}
+	for _, v := range entry.tags {
+		data.Tags = append(data.Tags, TemplateTag{Name: v.name, Value: v.value})
+	}
+
+	var buffer bytes.Buffer
+	err := tf.tmpl.Execute(&buffer, data)
+	if err != nil {
+		buffer.Reset()
+		buffer.WriteString(err.Error())
+	}
+	if buffer.Len() == 0 || buffer.Bytes()[buffer.Len()-1] != '\n' {
+		buffer.WriteByte('\n')
+	}
+
+	return buffer.Bytes()
+}
+
+// funcs returns the helper functions available to templates
+func (tf *TemplateFormatter) funcs() template.FuncMap {
+	return template.FuncMap{
+		"level": templateLevel,
+		"padLevel": func(level LogLevel) string {
+			levelString := templateLevel(level)
+			if len(levelString) < consoleLevelWidth {
+				levelString += strings.Repeat(" ", consoleLevelWidth-len(levelString))
+			}
+			return levelString
+		},
+		"timestamp": func(timestamp time.Time) string {
+			return timestamp.Local().Format(tf.timeFormat)
+		},
+		"formatTime": func(timestamp time.Time, layout string) string {
+			return timestamp.Local().Format(layout)
+		},
+		"tag": func(entry TemplateEntry, name string) interface{} {
+			return entry.Tag(name)
+		},
+		"json": func(value interface{}) (string, error) {
+			quoted, err := json.Marshal(value)
+			return string(quoted), err
+		},
+	}
+}
+
+// templateLevel returns the upper case level name
+func templateLevel(level LogLevel) string {
+	levelString, err := convertLevelToString(level, true)
+	if err != nil {
+		return err.Error()
+	}
+	return levelString
+}
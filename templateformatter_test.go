@@ -0,0 +1,47 @@
+package pflog
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type TemplateFormatterTestSuite struct {
+	suite.Suite
+}
+
+func (suite *TemplateFormatterTestSuite) TestHelpers() {
+	formatter, err := NewTemplateFormatter(`{{padLevel .Level}}|{{tag . "user"}}|{{.Tag "missing"}}|{{json .Message}}`)
+	suite.Require().Nil(err)
+
+	output := string(formatter.Format(NewEntry(Warning, time.Now(), `say "hi"`, []*Tag{CreateTag("user", "bob")})))
+
+	suite.Assert().Equal("WARNING    |bob|<no value>|\"say \\\"hi\\\"\"\n", output)
+}
+
+func (suite *TemplateFormatterTestSuite) TestDefaultTemplate() {
+	formatter := &TemplateFormatter{}
+	formatter.SetTimestampFormat("2006")
+	timestamp := time.Date(2021, time.January, 2, 3, 4, 5, 0, time.Local)
+
+	output := string(formatter.Format(NewEntry(Error, timestamp, "message", []*Tag{CreateTag("one", 1)})))
+
+	suite.Assert().Equal("2021 [ERROR] one: 1 message\n", output)
+}
+
+func (suite *TemplateFormatterTestSuite) TestParseErrorFromConfiguration() {
+	configuration := Configuration{
+		Settings: Settings{Level: "Information", TriggerLevel: "Error", Backlog: 10},
+		Formatters: []FormatterEntry{
+			{ID: "template", Filename: filepath.Join(suite.T().TempDir(), "out.log"), Template: "{{.Message"},
+		},
+	}
+
+	suite.Assert().NotNil(configuration.LoadConfiguration())
+}
+
+func TestTemplateFormatterTestSuite(t *testing.T) {
+	suite.Run(t, new(TemplateFormatterTestSuite))
+}
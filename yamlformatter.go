@@ -9,8 +9,10 @@ var yamlformatterTypeID = "yaml"
 
 type YAMLFormatter struct {
 	timeFormat string
+	layout     *compiledLayout
 }
 
+// YAMLOutputFormat describes the default layout of a formatted entry
 type YAMLOutputFormat struct {
 	TimeStamp string                 `yaml:"timestamp"`
 	Level     string                 `yaml:"level"`
@@ -18,6 +20,16 @@ type YAMLOutputFormat struct {
 	Tags      map[string]interface{} `yaml:"tags"`
 }
 
+// newYAMLFormatter is the factory for yaml formatters, supporting
+// the structured layout options
+func newYAMLFormatter(options map[string]interface{}) (LogFormatter, error) {
+	layout, err := layoutFromOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	return &YAMLFormatter{layout: layout.compile()}, nil
+}
+
 // ID returns the specified ID of this formatter
 func (yf *YAMLFormatter) ID() string {
 	return yamlformatterTypeID
@@ -30,29 +42,79 @@ func (yf *YAMLFormatter) SetTimestampFormat(format string) {
 	yf.timeFormat = format
 }
 
-// Format formats a log entry into a json
+// SetLayout sets the keys, their order and the tag placement
+func (yf *YAMLFormatter) SetLayout(layout StructuredLayout) error {
+	err := layout.Validate()
+	if err != nil {
+		return err
+	}
+	yf.layout = layout.compile()
+	return nil
+}
+
+// Format formats a log entry into a yaml
 // entry such as:
-// "message": "whatever"
-// "level": "information"
+// message: whatever
+// level: INFORMATION
 // etc
 func (yf *YAMLFormatter) Format(entry *Entry) []byte {
-	var err error
+	layout := yf.layout
+	if layout == nil {
+		layout = defaultCompiledLayout
+	}
 
-	yamlOutput := YAMLOutputFormat{}
-	yamlOutput.TimeStamp = entry.timestamp.Local().Format(yf.timeFormat)
-	yamlOutput.Level, err = convertLevelToString(entry.level, true)
-	if err != nil {
-		yamlOutput.Level = err.Error()
+	document := &yaml.Node{Kind: yaml.MappingNode}
+	for _, v := range layout.fields {
+		var value *yaml.Node
+		switch v.field {
+		case FieldTimestamp:
+			value = yamlScalar(entry.timestamp.Local().Format(yf.timeFormat))
+		case FieldLevel:
+			value = yamlScalar(layout.level(entry.level))
+		case FieldMessage:
+			value = yamlScalar(entry.message)
+		case FieldTags:
+			value = &yaml.Node{Kind: yaml.MappingNode}
+			yamlAppendTags(value, entry.tags, nil)
+		}
+		document.Content = append(document.Content, yamlScalar(v.key), value)
 	}
-	for _, v := range entry.tags {
-		yamlOutput.Tags[v.name] = v.value
+	if layout.flattenTags {
+		yamlAppendTags(document, entry.tags, layout)
 	}
-	yamlOutput.Message = entry.message
 
-	formattedMessage, marshallErr := yaml.Marshal(yamlOutput)
+	formattedMessage, marshallErr := yaml.Marshal(document)
 
 	if marshallErr != nil {
 		formattedMessage = []byte(marshallErr.Error())
 	}
 	return formattedMessage
 }
+
+// yamlAppendTags appends the tags to a mapping node, flattened tags
+// are renamed by the layout if they collide with a standard key
+func yamlAppendTags(mapping *yaml.Node, tags []*Tag, layout *compiledLayout) {
+	var keys []string
+	if layout != nil {
+		keys = layout.flattenedKeys(tags)
+	}
+	for index, v := range tags {
+		if tagOverridden(tags, index) {
+			continue
+		}
+		name := v.name
+		if keys != nil {
+			name = keys[index]
+		}
+		value := &yaml.Node{}
+		err := value.Encode(v.value)
+		if err != nil {
+			value = yamlScalar(err.Error())
+		}
+		mapping.Content = append(mapping.Content, yamlScalar(name), value)
+	}
+}
+
+func yamlScalar(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}